@@ -0,0 +1,411 @@
+package geecache
+
+import (
+	"container/list"
+	"errors"
+	"hash/maphash"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRebalanceInterval = 10 * time.Second
+	// 默认每次调整总预算的 1/20
+	defaultRebalanceSteps = 20
+)
+
+// Budget 是多个 group 共享的内存预算。加入预算的 group 的本地缓存容量由 Budget 分配，
+// 所有 group 的容量之和等于总预算（每个 group 的下限之和超过总预算时除外）。
+//
+// Budget 为每个 group 记录最近被淘汰的 key（ghost），缓存未命中的 key 如果在 ghost 中，
+// 说明多给这个 group 一些内存就能命中。每次调整时，把一部分容量从 ghost 命中最少的 group
+// 移给 ghost 命中最多的 group，同时遵守每个 group 的上下限。
+type Budget struct {
+	mtx   sync.Mutex
+	total int64
+	// 每次调整移动的字节数，也是每个 group 的 ghost 的容量；为 0 时使用 total / defaultRebalanceSteps
+	step     int64
+	interval time.Duration
+	members  []*budgetMember
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// budgetMember 是加入预算的一个 group
+type budgetMember struct {
+	budget   *Budget
+	group    *Group
+	min, max int64
+	size     int64
+}
+
+// BudgetOption 用于配置 Budget
+type BudgetOption func(*Budget)
+
+// WithRebalanceInterval 设置调整各个 group 容量的间隔，默认 10s，<= 0 表示不自动调整，只在调用 Rebalance 时调整
+func WithRebalanceInterval(d time.Duration) BudgetOption {
+	return func(b *Budget) {
+		b.interval = d
+	}
+}
+
+// WithRebalanceStep 设置每次调整移动的字节数，默认是总预算的 1/20
+func WithRebalanceStep(n int64) BudgetOption {
+	return func(b *Budget) {
+		b.step = n
+	}
+}
+
+// NewBudget 创建总共 total 字节的内存预算，并在后台定期调整各个 group 的容量，不再使用时调用 Close
+func NewBudget(total int64, opts ...BudgetOption) *Budget {
+	b := &Budget{total: total, interval: defaultRebalanceInterval}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.interval > 0 {
+		b.stop = make(chan struct{})
+		b.done.Add(1)
+		go b.loop()
+	}
+	return b
+}
+
+// NewBudgetFromCgroup 创建大小为 cgroup 内存限制的 fraction 倍的内存预算，见 CgroupMemoryLimit
+func NewBudgetFromCgroup(fraction float64, opts ...BudgetOption) (*Budget, error) {
+	limit, err := CgroupMemoryLimit()
+	if err != nil {
+		return nil, err
+	}
+	return NewBudget(int64(float64(limit)*fraction), opts...), nil
+}
+
+// WithBudget 让 group 的本地缓存从 b 中分配容量，NewGroup 的 cacheBytes 参数被忽略。
+// 分配给 group 的容量不少于 minBytes，不超过 maxBytes（<= 0 表示不限制）。
+// 加入预算之后 Group.SetCacheBytes 的修改会在下一次调整时被覆盖。Group.Shutdown 时 group 退出预算。
+func WithBudget(b *Budget, minBytes, maxBytes int64) GroupOption {
+	return func(g *Group) {
+		g.budget = &budgetMember{budget: b, min: minBytes, max: maxBytes}
+	}
+}
+
+func (b *Budget) loop() {
+	defer b.done.Done()
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.Rebalance()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// Close 停止后台的调整，各个 group 保持当前的容量
+func (b *Budget) Close() {
+	if b.stop != nil {
+		close(b.stop)
+		b.done.Wait()
+		b.stop = nil
+	}
+}
+
+// stepLocked 返回每次调整移动的字节数，调用者必须持有 b.mtx
+func (b *Budget) stepLocked() int64 {
+	if b.step > 0 {
+		return b.step
+	}
+	return max(b.total/defaultRebalanceSteps, 1)
+}
+
+// join 把 m 加入预算，重新平均分配容量
+func (b *Budget) join(m *budgetMember) {
+	b.mtx.Lock()
+	b.members = append(b.members, m)
+	m.group.localCache.setGhostBytes(b.stepLocked())
+	apply := b.redistributeLocked()
+	b.mtx.Unlock()
+	apply()
+}
+
+// leave 把 m 移出预算，它的容量分给其他 group
+func (b *Budget) leave(m *budgetMember) {
+	b.mtx.Lock()
+	for i, member := range b.members {
+		if member == m {
+			b.members = append(b.members[:i], b.members[i+1:]...)
+			break
+		}
+	}
+	m.group.localCache.setGhostBytes(0)
+	apply := b.redistributeLocked()
+	b.mtx.Unlock()
+	apply()
+}
+
+// SetTotal 修改总预算，重新平均分配容量
+func (b *Budget) SetTotal(total int64) {
+	b.mtx.Lock()
+	b.total = total
+	for _, m := range b.members {
+		m.group.localCache.setGhostBytes(b.stepLocked())
+	}
+	apply := b.redistributeLocked()
+	b.mtx.Unlock()
+	apply()
+}
+
+// Total 返回总预算
+func (b *Budget) Total() int64 {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.total
+}
+
+// redistributeLocked 先满足每个 group 的下限，再把剩下的容量尽量平均地分给没有达到上限的 group。
+// 调用者必须持有 b.mtx，返回的函数在释放锁之后调用，把新的容量应用到缓存上。
+func (b *Budget) redistributeLocked() func() {
+	remaining := b.total
+	for _, m := range b.members {
+		m.size = m.min
+		remaining -= m.min
+	}
+	for remaining > 0 {
+		var open []*budgetMember
+		for _, m := range b.members {
+			if m.max <= 0 || m.size < m.max {
+				open = append(open, m)
+			}
+		}
+		if len(open) == 0 {
+			break
+		}
+		// 除不尽的部分给前面的 group
+		share, extra := remaining/int64(len(open)), remaining%int64(len(open))
+		for i, m := range open {
+			n := share
+			if int64(i) < extra {
+				n++
+			}
+			if m.max > 0 {
+				n = min(n, m.max-m.size)
+			}
+			m.size += n
+			remaining -= n
+		}
+	}
+	return b.applyLocked(b.members...)
+}
+
+// applyLocked 返回把 members 当前的容量应用到缓存上的函数，调用者必须持有 b.mtx
+func (b *Budget) applyLocked(members ...*budgetMember) func() {
+	sizes := make(map[*Cache]int64, len(members))
+	for _, m := range members {
+		sizes[m.group.localCache] = m.size
+	}
+	return func() {
+		for c, n := range sizes {
+			c.SetCacheBytes(n)
+		}
+	}
+}
+
+// Rebalance 立即调整一次：把一步的容量从 ghost 命中最少的 group 移给命中最多的 group。
+// 命中次数在每次调整之后清零，只反映最近一段时间的访问。
+func (b *Budget) Rebalance() {
+	b.mtx.Lock()
+	var donor, recipient *budgetMember
+	var donorHits, recipientHits int64
+	for _, m := range b.members {
+		hits := m.group.localCache.takeGhostHits()
+		if m.size > m.min && (donor == nil || hits < donorHits) {
+			donor, donorHits = m, hits
+		}
+		if (m.max <= 0 || m.size < m.max) && (recipient == nil || hits > recipientHits) {
+			recipient, recipientHits = m, hits
+		}
+	}
+	if donor == nil || recipient == nil || donor == recipient || recipientHits <= donorHits {
+		b.mtx.Unlock()
+		return
+	}
+	n := min(b.stepLocked(), donor.size-donor.min)
+	if recipient.max > 0 {
+		n = min(n, recipient.max-recipient.size)
+	}
+	donor.size -= n
+	recipient.size += n
+	log.Printf("[Budget] move %d bytes from group %s (%d ghost hits) to group %s (%d ghost hits)",
+		n, donor.group.name, donorHits, recipient.group.name, recipientHits)
+	apply := b.applyLocked(donor, recipient)
+	b.mtx.Unlock()
+	apply()
+}
+
+// BudgetShare 是一个 group 在预算中分到的容量
+type BudgetShare struct {
+	Group string
+	Bytes int64
+	Min   int64
+	Max   int64
+}
+
+// Shares 返回各个 group 当前分到的容量，按加入预算的顺序排列
+func (b *Budget) Shares() []BudgetShare {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	shares := make([]BudgetShare, 0, len(b.members))
+	for _, m := range b.members {
+		shares = append(shares, BudgetShare{Group: m.group.name, Bytes: m.size, Min: m.min, Max: m.max})
+	}
+	return shares
+}
+
+// ghostList 记录最近被淘汰的 key 的哈希和大小，总大小不超过 capacity
+type ghostList struct {
+	seed     maphash.Seed
+	capacity int64
+	bytes    int64
+	// 队头最新，队尾最旧
+	ll    *list.List
+	index map[uint64]*list.Element
+	// 上一次 takeGhostHits 之后的命中次数
+	hits int64
+}
+
+type ghostEntry struct {
+	hash uint64
+	size int64
+}
+
+func newGhostList(capacity int64) *ghostList {
+	return &ghostList{
+		seed:     maphash.MakeSeed(),
+		capacity: capacity,
+		ll:       list.New(),
+		index:    make(map[uint64]*list.Element),
+	}
+}
+
+// add 记录一个被淘汰的 key
+func (gl *ghostList) add(key string, size int64) {
+	h := maphash.String(gl.seed, key)
+	if ele, ok := gl.index[h]; ok {
+		gl.bytes -= ele.Value.(ghostEntry).size
+		gl.ll.Remove(ele)
+	}
+	gl.index[h] = gl.ll.PushFront(ghostEntry{h, size})
+	gl.bytes += size
+	for gl.bytes > gl.capacity && gl.ll.Len() > 0 {
+		e := gl.ll.Remove(gl.ll.Back()).(ghostEntry)
+		delete(gl.index, e.hash)
+		gl.bytes -= e.size
+	}
+}
+
+// miss 在缓存未命中时调用，key 在 ghost 中时计一次命中
+func (gl *ghostList) miss(key string) {
+	h := maphash.String(gl.seed, key)
+	if ele, ok := gl.index[h]; ok {
+		gl.bytes -= ele.Value.(ghostEntry).size
+		gl.ll.Remove(ele)
+		delete(gl.index, h)
+		gl.hits++
+	}
+}
+
+// ErrNoCgroupLimit 表示进程所在的 cgroup 没有内存限制，或者无法读取 cgroup 的信息
+var ErrNoCgroupLimit = errors.New("geecache: no cgroup memory limit")
+
+// CgroupMemoryLimit 返回进程所在 cgroup 的内存限制，支持 cgroup v2 和 v1。
+// 根据 /proc/self/cgroup 找到进程所在的 cgroup，在 /sys/fs/cgroup 中读取它以及所有上级 cgroup 的限制，
+// 返回其中最小的一个，因为上级的限制同样约束进程。
+func CgroupMemoryLimit() (int64, error) {
+	// 读不到 /proc/self/cgroup 时只检查挂载点的根，容器内通常就是容器自己的 cgroup
+	self, _ := os.ReadFile("/proc/self/cgroup")
+	return cgroupMemoryLimit(os.DirFS("/sys/fs/cgroup"), string(self))
+}
+
+// cgroupMemoryLimit 从 cgroup 文件系统 fsys 读取内存限制，self 是 /proc/self/cgroup 的内容
+func cgroupMemoryLimit(fsys fs.FS, self string) (int64, error) {
+	v2, v1 := cgroupPaths(self)
+	for _, h := range []struct{ root, dir, file string }{
+		{".", v2, "memory.max"},                 // cgroup v2
+		{"memory", v1, "memory.limit_in_bytes"}, // cgroup v1
+	} {
+		found := false
+		var limit int64
+		// 没有使用 cgroup 命名空间时，容器内看到的路径在挂载点中可能不存在，向上找到根为止
+		for dir := path.Join(h.root, h.dir); ; dir = path.Dir(dir) {
+			data, err := fs.ReadFile(fsys, path.Join(dir, h.file))
+			if err == nil {
+				found = true
+				n, err := parseCgroupLimit(data)
+				if err != nil {
+					return 0, err
+				}
+				if n > 0 && (limit == 0 || n < limit) {
+					limit = n
+				}
+			}
+			if dir == h.root {
+				break
+			}
+		}
+		if !found {
+			continue
+		}
+		if limit == 0 {
+			return 0, ErrNoCgroupLimit
+		}
+		return limit, nil
+	}
+	return 0, ErrNoCgroupLimit
+}
+
+// cgroupPaths 从 /proc/self/cgroup 的内容中找出进程在 cgroup v2 和 v1 的 memory 层级中的路径，
+// 每行的格式是 "hierarchy-ID:controller-list:cgroup-path"，v2 的 hierarchy-ID 为 0 并且 controller-list 为空。
+// 找不到时返回 "/"。
+func cgroupPaths(self string) (v2, v1 string) {
+	v2, v1 = "/", "/"
+	for _, line := range strings.Split(self, "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		// 清理成以 / 开头的路径，不会通过 .. 离开挂载点
+		p := path.Clean("/" + parts[2])
+		if parts[0] == "0" && parts[1] == "" {
+			v2 = p
+		} else if slices.Contains(strings.Split(parts[1], ","), "memory") {
+			v1 = p
+		}
+	}
+	return v2, v1
+}
+
+// parseCgroupLimit 解析 memory.max 或者 memory.limit_in_bytes 的内容，没有限制时返回 0
+func parseCgroupLimit(data []byte) (int64, error) {
+	s := strings.TrimSpace(string(data))
+	if s == "max" {
+		return 0, nil
+	}
+	limit, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	// cgroup v1 没有限制时是一个接近 int64 上限的值
+	if limit <= 0 || limit >= 1<<62 {
+		return 0, nil
+	}
+	return limit, nil
+}
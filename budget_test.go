@@ -0,0 +1,127 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestBudget(t *testing.T) {
+	b := NewBudget(1000, WithRebalanceInterval(0), WithRebalanceStep(200))
+	defer b.Close()
+	r := NewRegistry()
+	// 每个 key 是 1 个字节，值是 99 个字节，一个缓存项正好 100 个字节
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(strings.Repeat("v", 99)), nil
+	})
+	newGroup := func(name string, minBytes, maxBytes int64) *Group {
+		g, err := r.NewGroup(name, 0, getter, WithBudget(b, minBytes, maxBytes))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return g
+	}
+	sizes := func(groups ...*Group) []int64 {
+		var list []int64
+		for _, g := range groups {
+			list = append(list, g.CacheBytes())
+		}
+		return list
+	}
+
+	hot := newGroup("hot", 0, 0)
+	cold := newGroup("cold", 0, 0)
+	if got := sizes(hot, cold); got[0] != 500 || got[1] != 500 {
+		t.Fatalf("budget should be split evenly, got %v", got)
+	}
+	// 下限和上限优先满足，剩下的平均分配
+	capped := newGroup("capped", 100, 200)
+	if got := sizes(hot, cold, capped); got[0] != 400 || got[1] != 400 || got[2] != 200 {
+		t.Fatalf("unexpected shares %v", got)
+	}
+
+	// hot 循环访问 6 个 key，放不下；cold 只访问 1 个 key
+	for range 3 {
+		for i := range 6 {
+			hot.Get(strconv.Itoa(i))
+		}
+		cold.Get("0")
+	}
+	b.Rebalance()
+	if got := sizes(hot, cold, capped); got[0] != 600 || got[1] != 200 || got[2] != 200 {
+		t.Fatalf("capacity should move from cold to hot, got %v", got)
+	}
+	// 没有 ghost 命中时不调整
+	b.Rebalance()
+	if got := sizes(hot, cold, capped); got[0] != 600 || got[1] != 200 {
+		t.Fatalf("capacity should not move without ghost hits, got %v", got)
+	}
+
+	// group 退出预算之后，它的容量分给其他 group
+	if err := r.Delete(context.Background(), "cold"); err != nil {
+		t.Fatal(err)
+	}
+	if got := sizes(hot, capped); got[0] != 800 || got[1] != 200 {
+		t.Fatalf("unexpected shares after delete %v", got)
+	}
+	b.SetTotal(2000)
+	if shares := b.Shares(); len(shares) != 2 || shares[0].Bytes != 1800 || shares[1].Bytes != 200 {
+		t.Fatalf("unexpected shares after SetTotal %+v", shares)
+	}
+}
+
+func TestCgroupMemoryLimit(t *testing.T) {
+	const v2Self = "0::/system.slice/geecache.service\n"
+	const v1Self = "12:cpu,cpuacct:/other\n11:memory:/docker/abc\n0::/\n"
+	for _, tt := range []struct {
+		name  string
+		fsys  fstest.MapFS
+		self  string
+		limit int64
+		err   error
+	}{
+		{"v2", fstest.MapFS{"memory.max": {Data: []byte("536870912\n")}}, "", 512 << 20, nil},
+		{"v2 unlimited", fstest.MapFS{"memory.max": {Data: []byte("max\n")}}, "", 0, ErrNoCgroupLimit},
+		{"v1", fstest.MapFS{"memory/memory.limit_in_bytes": {Data: []byte("1073741824\n")}}, "", 1 << 30, nil},
+		{"v1 unlimited", fstest.MapFS{"memory/memory.limit_in_bytes": {Data: []byte("9223372036854771712\n")}}, "", 0, ErrNoCgroupLimit},
+		{"no cgroup", fstest.MapFS{}, "", 0, ErrNoCgroupLimit},
+		// 读取进程所在的 cgroup，而不是根 cgroup
+		{"v2 nested", fstest.MapFS{
+			"system.slice/memory.max":                  {Data: []byte("max\n")},
+			"system.slice/geecache.service/memory.max": {Data: []byte("268435456\n")},
+			"system.slice/other.service/memory.max":    {Data: []byte("1024\n")},
+		}, v2Self, 256 << 20, nil},
+		// 上级 cgroup 的限制更小时使用上级的限制
+		{"v2 parent", fstest.MapFS{
+			"system.slice/memory.max":                  {Data: []byte("134217728\n")},
+			"system.slice/geecache.service/memory.max": {Data: []byte("max\n")},
+		}, v2Self, 128 << 20, nil},
+		{"v2 nested unlimited", fstest.MapFS{
+			"system.slice/memory.max":                  {Data: []byte("max\n")},
+			"system.slice/geecache.service/memory.max": {Data: []byte("max\n")},
+		}, v2Self, 0, ErrNoCgroupLimit},
+		// 容器内没有 cgroup 命名空间时，看到的路径在挂载点中不存在，挂载点的根就是容器的 cgroup
+		{"v2 without namespace", fstest.MapFS{"memory.max": {Data: []byte("536870912\n")}}, v2Self, 512 << 20, nil},
+		{"v1 nested", fstest.MapFS{
+			"memory/memory.limit_in_bytes":            {Data: []byte("9223372036854771712\n")},
+			"memory/docker/abc/memory.limit_in_bytes": {Data: []byte("1073741824\n")},
+			"memory/other/memory.limit_in_bytes":      {Data: []byte("1024\n")},
+		}, v1Self, 1 << 30, nil},
+		// 路径中的 .. 不会离开挂载点
+		{"escaping path", fstest.MapFS{"memory.max": {Data: []byte("536870912\n")}}, "0::/../../etc\n", 512 << 20, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			limit, err := cgroupMemoryLimit(tt.fsys, tt.self)
+			if limit != tt.limit || !errors.Is(err, tt.err) {
+				t.Fatalf("cgroupMemoryLimit() = %d, %v, want %d, %v", limit, err, tt.limit, tt.err)
+			}
+		})
+	}
+
+	if _, err := cgroupMemoryLimit(fstest.MapFS{"memory.max": {Data: []byte("lots\n")}}, ""); err == nil {
+		t.Fatal("expected an error for a malformed limit")
+	}
+}
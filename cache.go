@@ -3,33 +3,323 @@ package geecache
 import (
 	"geecache/lru"
 	"geecache/util"
+	"log"
 	"sync"
+	"time"
+	"unsafe"
 )
 
+// SecondTier 是本地缓存的第二级存储（比如 disk.Store），保存从内存中淘汰的项。
+// 内存缓存未命中时先查第二级存储，再去对端节点或数据源。
+// expiry 是缓存项的过期时间（Unix 纳秒时间戳，0 表示不过期），第二级存储原样保存，由 Cache 判断是否过期。
+type SecondTier interface {
+	Get(key string) (value util.ByteView, expiry int64, ok bool)
+	Put(key string, value util.ByteView, expiry int64) error
+	Delete(key string) error
+}
+
 type Cache struct {
 	mtx        sync.Mutex
-	lru        *lru.Cache
+	store      store
 	cacheBytes int64
+	// 第二级存储，为 nil 时淘汰的项直接丢弃
+	l2 SecondTier
+	// store.put 中被淘汰、等待写入 l2 的项。在锁外写入 l2，不让磁盘 IO 阻塞其他读写
+	evicted []evictedEntry
+	// 缓存项写入后的存活时间，<= 0 表示不过期
+	ttl time.Duration
+	// 返回当前时间，为 nil 时使用 time.Now，测试中可以替换
+	now func() time.Time
+	// 最近被淘汰的 key，加入 Budget 时才记录，用来估计增加容量能带来多少命中
+	ghost *ghostList
+	// 缓存项计入 cacheBytes 的方式，见 lru.Accounting
+	accounting lru.Accounting
+	// 创建 store，为 nil 时使用 lruStore
+	newStore newStoreFunc
+}
+
+// evictedEntry 是从内存中淘汰的项
+type evictedEntry struct {
+	key   string
+	value cacheValue
+}
+
+// cacheValue 是 store 中保存的值
+type cacheValue struct {
+	view util.ByteView
+	// 过期时间的 Unix 纳秒时间戳，0 表示不过期
+	expiry int64
+}
+
+func (v cacheValue) Size() int {
+	return v.view.Size()
+}
+
+// Footprint 返回装箱到 lru.Value 接口中的 cacheValue 和底层数组占用的内存。
+// ByteView 不暴露底层数组的容量，按数据的长度计算。
+func (v cacheValue) Footprint() int {
+	return int(unsafe.Sizeof(v)) + v.view.Size()
+}
+
+func (c *Cache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// expired 判断 v 是否已经过期
+func (c *Cache) expired(v cacheValue) bool {
+	return v.expiry != 0 && c.clock().UnixNano() >= v.expiry
+}
+
+// newExpiry 返回现在写入的项的过期时间，调用者必须持有 c.mtx
+func (c *Cache) newExpiry() int64 {
+	if c.ttl <= 0 {
+		return 0
+	}
+	return c.clock().Add(c.ttl).UnixNano()
+}
+
+// lazyInit 在第一次使用时创建 store，调用者必须持有 c.mtx
+func (c *Cache) lazyInit() {
+	// 如果等于 nil 再创建实例。这种方法称之为延迟初始化(Lazy Initialization)，一个对象的延迟初始化意味着该对象的创建将会延迟至第一次使用该对象时。主要用于提高性能，并减少程序内存要求。
+	if c.store == nil {
+		newStore := c.newStore
+		if newStore == nil {
+			newStore = newLRUStore(c.accounting)
+		}
+		c.store = newStore(c.cacheBytes, func(key string, value cacheValue) {
+			if c.ghost != nil {
+				c.ghost.add(key, int64(len(key)+value.Size()))
+			}
+			if c.l2 != nil {
+				c.evicted = append(c.evicted, evictedEntry{key, value})
+			}
+		})
+	}
 }
 
 func (c *Cache) Get(key string) (value util.ByteView, ok bool) {
 	c.mtx.Lock()
-	defer c.mtx.Unlock()
-	// 如果等于 nil 再创建实例。这种方法称之为延迟初始化(Lazy Initialization)，一个对象的延迟初始化意味着该对象的创建将会延迟至第一次使用该对象时。主要用于提高性能，并减少程序内存要求。
-	if c.lru == nil {
-		c.lru = lru.New(c.cacheBytes, nil)
+	c.lazyInit()
+	val, ok := c.store.get(key)
+	if ok && c.expired(val) {
+		c.store.remove(key)
+		ok = false
 	}
-	if val, ok := c.lru.Get(key); ok {
-		return val.(util.ByteView), true
+	if !ok && c.ghost != nil {
+		c.ghost.miss(key)
 	}
-	return
+	c.mtx.Unlock()
+	if ok {
+		return val.view, true
+	}
+	if c.l2 == nil {
+		return
+	}
+	value, expiry, ok := c.l2.Get(key)
+	if !ok {
+		return util.ByteView{}, false
+	}
+	if c.expired(cacheValue{expiry: expiry}) {
+		if err := c.l2.Delete(key); err != nil {
+			log.Printf("[Cache] delete %s from second tier: %v", key, err)
+		}
+		return util.ByteView{}, false
+	}
+	return c.promote(key, cacheValue{view: value, expiry: expiry}), true
+}
+
+// promote 把从 l2 读到的 v 提升回内存缓存，保留原来的过期时间，返回 key 在内存中的值。
+// 读 l2 时没有持有锁，并发的 Put 可能已经把新值写入内存并从 l2 中删除了 key，
+// 所以只在内存中仍然没有 key 时才写入，否则以内存中的新值为准。
+func (c *Cache) promote(key string, v cacheValue) util.ByteView {
+	c.mtx.Lock()
+	c.lazyInit()
+	if cur, ok := c.store.get(key); ok && !c.expired(cur) {
+		c.mtx.Unlock()
+		return cur.view
+	}
+	c.store.put(key, v)
+	evicted := c.evicted
+	c.evicted = nil
+	c.mtx.Unlock()
+
+	// 两级缓存不重复保存同一个 key
+	if err := c.l2.Delete(key); err != nil {
+		log.Printf("[Cache] delete %s from second tier: %v", key, err)
+	}
+	c.spill(evicted)
+	return v.view
 }
 
 func (c *Cache) Put(key string, value util.ByteView) {
+	c.put(key, value, -1)
+}
+
+// put 写入 key，expiry < 0 时按照 ttl 计算过期时间
+func (c *Cache) put(key string, value util.ByteView, expiry int64) {
+	c.mtx.Lock()
+	c.lazyInit()
+	if expiry < 0 {
+		expiry = c.newExpiry()
+	}
+	c.store.put(key, cacheValue{view: value, expiry: expiry})
+	evicted := c.evicted
+	c.evicted = nil
+	c.mtx.Unlock()
+
+	if c.l2 == nil {
+		return
+	}
+	// 两级缓存不重复保存同一个 key，l2 中的旧值也不会在之后被读到
+	if err := c.l2.Delete(key); err != nil {
+		log.Printf("[Cache] delete %s from second tier: %v", key, err)
+	}
+	c.spill(evicted)
+}
+
+// spill 把从内存中淘汰的项连同过期时间写入 l2，已经过期的项直接丢弃
+func (c *Cache) spill(evicted []evictedEntry) {
+	for _, e := range evicted {
+		if c.expired(e.value) {
+			continue
+		}
+		if err := c.l2.Put(e.key, e.value.view, e.value.expiry); err != nil {
+			log.Printf("[Cache] write %s to second tier: %v", e.key, err)
+		}
+	}
+}
+
+// SetCacheBytes 修改内存缓存的容量上限，缩小时立即淘汰超出的部分
+func (c *Cache) SetCacheBytes(n int64) {
+	c.mtx.Lock()
+	c.cacheBytes = n
+	if c.store != nil {
+		c.store.setMaxBytes(n)
+	}
+	evicted := c.evicted
+	c.evicted = nil
+	c.mtx.Unlock()
+	if c.l2 != nil {
+		c.spill(evicted)
+	}
+}
+
+// SetTTL 修改之后写入的项的存活时间，<= 0 表示不过期。已经在缓存中的项保持原来的过期时间。
+func (c *Cache) SetTTL(ttl time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.ttl = ttl
+}
+
+// TTL 返回缓存项的存活时间
+func (c *Cache) TTL() time.Duration {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.ttl
+}
+
+// setGhostBytes 设置 ghost 的容量，0 表示不再记录被淘汰的 key
+func (c *Cache) setGhostBytes(n int64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	switch {
+	case n <= 0:
+		c.ghost = nil
+	case c.ghost == nil:
+		c.ghost = newGhostList(n)
+	default:
+		c.ghost.capacity = n
+	}
+}
+
+// takeGhostHits 返回上一次调用之后 ghost 的命中次数
+func (c *Cache) takeGhostHits() int64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.ghost == nil {
+		return 0
+	}
+	hits := c.ghost.hits
+	c.ghost.hits = 0
+	return hits
+}
+
+// CacheBytes 返回内存缓存的容量上限
+func (c *Cache) CacheBytes() int64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.cacheBytes
+}
+
+// Purge 清空缓存。第二级存储实现了 Purge() error 时也一起清空。
+func (c *Cache) Purge() error {
+	c.mtx.Lock()
+	c.store = nil
+	c.mtx.Unlock()
+	if p, ok := c.l2.(interface{ Purge() error }); ok {
+		return p.Purge()
+	}
+	return nil
+}
+
+// Remove 从内存缓存和第二级存储中删除 key，返回内存缓存中是否存在 key
+func (c *Cache) Remove(key string) bool {
+	c.mtx.Lock()
+	ok := c.store != nil && c.store.remove(key)
+	c.mtx.Unlock()
+	if c.l2 != nil {
+		if err := c.l2.Delete(key); err != nil {
+			log.Printf("[Cache] delete %s from second tier: %v", key, err)
+		}
+	}
+	return ok
+}
+
+// Len 返回内存缓存中的项数，不包括第二级存储
+func (c *Cache) Len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.store == nil {
+		return 0
+	}
+	return c.store.len()
+}
+
+// Bytes 返回内存缓存当前占用的字节数，不包括第二级存储
+func (c *Cache) Bytes() int64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.store == nil {
+		return 0
+	}
+	return c.store.bytes()
+}
+
+// Range 遍历内存缓存中所有未过期的项（从最新到最旧），不包括第二级存储。遍历的是加锁时拷贝出的快照，fn 中可以执行耗时操作或者再次访问缓存。
+func (c *Cache) Range(fn func(key string, value util.ByteView) bool) {
+	for _, e := range c.entries() {
+		if !fn(e.key, e.value) {
+			return
+		}
+	}
+}
+
+// entries 返回内存缓存中所有未过期的项（从最新到最旧）
+func (c *Cache) entries() []snapshotEntry {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
-	if c.lru == nil {
-		c.lru = lru.New(c.cacheBytes, nil)
+	if c.store == nil {
+		return nil
 	}
-	c.lru.Put(key, value)
+	entries := make([]snapshotEntry, 0, c.store.len())
+	c.store.rangeEntries(func(key string, v cacheValue) bool {
+		if !c.expired(v) {
+			entries = append(entries, snapshotEntry{key, v.view, v.expiry})
+		}
+		return true
+	})
+	return entries
 }
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"geecache/consistenthash"
+	"geecache/network"
+	"geecache/slab"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config 是 geecache-server 的配置文件，支持 YAML、JSON 和 TOML，按扩展名区分。
+// 三种格式使用相同的字段名，见 example.yaml。
+type Config struct {
+	// 本节点的地址，其他节点用这个地址访问本节点，同时也是本节点在哈希环上的 ID
+	Self string `json:"self"`
+	// 节点间通讯（和运维接口）监听的地址，默认是 Self 中的 host:port
+	Listen string `json:"listen"`
+	// 客户端 REST API、Redis 协议和 memcached 协议监听的地址，为空时不启动
+	APIListen      string `json:"api_listen"`
+	RESPListen     string `json:"resp_listen"`
+	MemcacheListen string `json:"memcache_listen"`
+	// 本节点所在的可用区和机架
+	Zone string `json:"zone"`
+	Rack string `json:"rack"`
+	// 集群中的其他节点，可以包含本节点。SIGHUP 时重新加载。
+	Peers []PeerConfig `json:"peers"`
+
+	Ring      RingConfig      `json:"ring"`
+	Transport TransportConfig `json:"transport"`
+	Security  SecurityConfig  `json:"security"`
+	Auth      AuthConfig      `json:"auth"`
+	Snapshot  SnapshotConfig  `json:"snapshot"`
+	Memory    MemoryConfig    `json:"memory"`
+	Groups    []GroupConfig   `json:"groups"`
+}
+
+// PeerConfig 是集群中的一个节点
+type PeerConfig struct {
+	ID   string `json:"id"`
+	Zone string `json:"zone"`
+	Rack string `json:"rack"`
+}
+
+// RingConfig 是一致性哈希的参数，集群内所有节点必须相同
+type RingConfig struct {
+	// 哈希函数，默认 xxhash64
+	Hash string `json:"hash"`
+	// 每个节点的虚拟节点个数，默认 50
+	VirtualNodes int `json:"virtual_nodes"`
+	// 每个 key 保存在多少个节点上，默认 1
+	Replication int `json:"replication"`
+	// 一秒内转发次数达到这个值的 key 是热点 key，0 表示不识别热点 key
+	HotKeyThreshold int `json:"hot_key_threshold"`
+}
+
+// TransportConfig 是节点间传输和 HTTP 服务的参数
+type TransportConfig struct {
+	// 哈希环变化时迁移数据的速率（每秒缓存项个数），默认 1000，负数表示不限速
+	TransferRate int `json:"transfer_rate"`
+	// 流式传输时每次写出的块大小
+	ChunkSize ByteSize `json:"chunk_size"`
+	// 节点间传输的值的最大字节数，0 表示不限制
+	MaxValueSize ByteSize `json:"max_value_size"`
+	ReadTimeout  Duration `json:"read_timeout"`
+	WriteTimeout Duration `json:"write_timeout"`
+	IdleTimeout  Duration `json:"idle_timeout"`
+	// 退出时等待正在处理的请求完成的时间，默认 10s
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+	// 退出时每个 group 迁移给新的归属节点的最热的缓存项个数，默认 0 不迁移，负数表示全部迁移
+	ShutdownHandoff int `json:"shutdown_handoff"`
+}
+
+// SecurityConfig 是运维接口和 TLS 的参数
+type SecurityConfig struct {
+	// 运维接口的 bearer token，拥有全部权限。两者都为空并且没有开启 auth 时不提供运维接口
+	AdminToken     string `json:"admin_token"`
+	AdminTokenFile string `json:"admin_token_file"`
+	// 证书和私钥，配置之后所有监听 HTTP 的地址都使用 HTTPS，访问其他节点时也用这个证书作为客户端证书
+	TLSCertFile string `json:"tls_cert_file"`
+	TLSKeyFile  string `json:"tls_key_file"`
+	// 验证其他节点证书的 CA，为空时使用系统的根证书
+	TLSCAFile string `json:"tls_ca_file"`
+	// 开启 mTLS：节点间的接口只接受由 tls_ca_file 签发、身份（证书的 SAN）是集群中的节点的客户端证书
+	TLSClientAuth bool `json:"tls_client_auth"`
+	// 开启 mTLS 时，除了集群中的节点之外还允许访问节点间接口的证书身份，例如 geecachectl 的证书
+	TLSAllowedIdentities []string `json:"tls_allowed_identities"`
+}
+
+// AuthConfig 是请求认证和 ACL 的参数，cluster_secret 和 cluster_secret_file 都为空时不开启认证。
+// 开启之后节点间的请求用集群密钥签名，REST API、运维接口和节点间接口也接受 clients 中的 bearer token。
+type AuthConfig struct {
+	// 节点间请求签名的密钥，集群内所有节点必须相同
+	ClusterSecret     string `json:"cluster_secret"`
+	ClusterSecretFile string `json:"cluster_secret_file"`
+	// 签名的有效期，节点之间的时钟误差必须小于它，默认 30s
+	ReplayWindow Duration `json:"replay_window"`
+	// 使用 bearer token 认证的客户端
+	Clients []ClientConfig `json:"clients"`
+}
+
+// ClientConfig 是一个使用 bearer token 认证的客户端
+type ClientConfig struct {
+	Name      string `json:"name"`
+	Token     string `json:"token"`
+	TokenFile string `json:"token_file"`
+	// group 名称到权限列表（read、write、admin）的映射，"*" 匹配所有 group
+	Groups map[string][]string `json:"groups"`
+}
+
+func (a *AuthConfig) enabled() bool {
+	return a.ClusterSecret != "" || a.ClusterSecretFile != ""
+}
+
+// SnapshotConfig 配置本地缓存的快照，Dir 为空时不保存快照
+type SnapshotConfig struct {
+	Dir      string   `json:"dir"`
+	Interval Duration `json:"interval"`
+}
+
+// MemoryConfig 配置所有 group 共享的内存预算，Budget 和 CgroupFraction 都为 0 时每个 group 使用自己的 cache_bytes
+type MemoryConfig struct {
+	// 总预算
+	Budget ByteSize `json:"budget"`
+	// 总预算是进程所在 cgroup 的内存限制（包括上级 cgroup 的限制）的多少倍，例如 0.5，不能和 Budget 同时设置
+	CgroupFraction float64 `json:"cgroup_fraction"`
+	// 按照各个 group 的命中情况调整容量的间隔，默认 10s
+	RebalanceInterval Duration `json:"rebalance_interval"`
+	// 缓存项计入容量的方式：payload 只计算 key 和值的长度（默认），footprint 按实际占用的内存计算
+	Accounting string `json:"accounting"`
+	// 缓存项的存储方式：lru 每项一个对象（默认），slab 保存在预先分配的大块内存中，缓存项很多时 GC 停顿更短。
+	// slab 要求每个 group 的 cache_bytes（使用预算时是 min_bytes）至少是一个 page（1MiB）
+	Storage string `json:"storage"`
+}
+
+func (m *MemoryConfig) enabled() bool {
+	return m.Budget > 0 || m.CgroupFraction > 0
+}
+
+// GroupConfig 是一个 group，CacheBytes、TTL 和 Source 在 SIGHUP 时重新加载
+type GroupConfig struct {
+	Name string `json:"name"`
+	// 没有配置内存预算时 group 的缓存容量
+	CacheBytes ByteSize `json:"cache_bytes"`
+	// 配置了内存预算时 group 分到的容量的下限和上限，0 表示不限制
+	MinBytes ByteSize     `json:"min_bytes"`
+	MaxBytes ByteSize     `json:"max_bytes"`
+	TTL      Duration     `json:"ttl"`
+	Source   SourceConfig `json:"source"`
+}
+
+// SourceConfig 是 group 的数据源，见 geecache/source
+type SourceConfig struct {
+	// 数据源类型：http、dir 或 sql，默认 http
+	Type string `json:"type"`
+	// http：从 URL 读取 key，{key} 会被替换成转义后的 key，源站返回 404 或 410 时表示 key 不存在
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	// dir：从目录 Dir 中读取 key，Pattern 是 key 对应的文件路径，默认是 {key}
+	Dir     string `json:"dir"`
+	Pattern string `json:"pattern"`
+	// sql：用 Query 查询 key，Column 是值所在的列，默认第一列。Driver 必须已经链接到程序中，参考 main.go 的说明。
+	Driver string `json:"driver"`
+	DSN    string `json:"dsn"`
+	Query  string `json:"query"`
+	Column string `json:"column"`
+	// 值的最大字节数，0 表示不限制，适用于 http 和 dir
+	MaxSize ByteSize `json:"max_size"`
+	Timeout Duration `json:"timeout"`
+}
+
+// Duration 在配置文件中写成 "1m30s" 这样的字符串
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// ByteSize 在配置文件中可以写成整数，也可以写成 "64MiB" 这样带单位的字符串
+type ByteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	n      int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+	{"B", 1},
+}
+
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var n int64
+	if err := json.Unmarshal(data, &n); err == nil {
+		*b = ByteSize(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("size must be an integer or a string like \"64MiB\"")
+	}
+	s = strings.TrimSpace(s)
+	for _, unit := range byteUnits {
+		if num, ok := strings.CutSuffix(s, unit.suffix); ok {
+			v, err := strconv.ParseInt(strings.TrimSpace(num), 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid size %q", s)
+			}
+			*b = ByteSize(v * unit.n)
+			return nil
+		}
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid size %q", s)
+	}
+	*b = ByteSize(v)
+	return nil
+}
+
+// LoadConfig 读取并检查配置文件
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ParseConfig(data, filepath.Ext(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if cfg.Security.AdminTokenFile != "" {
+		token, err := os.ReadFile(cfg.Security.AdminTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: security.admin_token_file: %w", path, err)
+		}
+		cfg.Security.AdminToken = strings.TrimSpace(string(token))
+	}
+	if cfg.Auth.ClusterSecretFile != "" {
+		secret, err := os.ReadFile(cfg.Auth.ClusterSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: auth.cluster_secret_file: %w", path, err)
+		}
+		cfg.Auth.ClusterSecret = strings.TrimSpace(string(secret))
+	}
+	for i := range cfg.Auth.Clients {
+		client := &cfg.Auth.Clients[i]
+		if client.TokenFile != "" {
+			token, err := os.ReadFile(client.TokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("%s: auth.clients[%d].token_file: %w", path, i, err)
+			}
+			client.Token = strings.TrimSpace(string(token))
+		}
+	}
+	return cfg, nil
+}
+
+// ParseConfig 解析 ext 格式（.yaml、.yml、.json 或 .toml）的配置，填充默认值并检查
+func ParseConfig(data []byte, ext string) (*Config, error) {
+	// YAML 和 TOML 先解析成通用的结构再转成 JSON，三种格式共用 json 标签、默认值和未知字段的检查
+	var raw any
+	switch strings.ToLower(ext) {
+	case ".json":
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		if err := toml.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown config format %q, use .yaml, .json or .toml", ext)
+	}
+	if raw != nil {
+		var err error
+		if data, err = json.Marshal(raw); err != nil {
+			return nil, err
+		}
+	}
+	var cfg Config
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, err
+	}
+	cfg.setDefaults()
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (cfg *Config) setDefaults() {
+	if cfg.Listen == "" {
+		if u, err := url.Parse(cfg.Self); err == nil {
+			cfg.Listen = u.Host
+		}
+	}
+	if cfg.Ring.Hash == "" {
+		cfg.Ring.Hash = consistenthash.HashXXHash64
+	}
+	if cfg.Ring.VirtualNodes == 0 {
+		cfg.Ring.VirtualNodes = 50
+	}
+	if cfg.Ring.Replication == 0 {
+		cfg.Ring.Replication = 1
+	}
+	if cfg.Transport.TransferRate == 0 {
+		cfg.Transport.TransferRate = 1000
+	}
+	if cfg.Transport.ShutdownTimeout == 0 {
+		cfg.Transport.ShutdownTimeout = Duration(10 * time.Second)
+	}
+	if cfg.Auth.ReplayWindow == 0 {
+		cfg.Auth.ReplayWindow = Duration(30 * time.Second)
+	}
+	if cfg.Snapshot.Interval == 0 {
+		cfg.Snapshot.Interval = Duration(time.Minute)
+	}
+	if cfg.Memory.RebalanceInterval == 0 {
+		cfg.Memory.RebalanceInterval = Duration(10 * time.Second)
+	}
+	if cfg.Memory.Accounting == "" {
+		cfg.Memory.Accounting = accountingPayload
+	}
+	if cfg.Memory.Storage == "" {
+		cfg.Memory.Storage = storageLRU
+	}
+	for i := range cfg.Groups {
+		src := &cfg.Groups[i].Source
+		if src.Type == "" {
+			src.Type = sourceHTTP
+		}
+		if src.Timeout == 0 {
+			src.Timeout = Duration(5 * time.Second)
+		}
+	}
+}
+
+// validURL 检查节点地址是否是 http(s)://host:port
+func validURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q must start with http:// or https://", s)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", s)
+	}
+	return nil
+}
+
+// validate 检查所有字段，一次性返回所有错误
+func (cfg *Config) validate() error {
+	var errs []error
+	check := func(field string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field, err))
+		}
+	}
+	require := func(field string, ok bool, msg string) {
+		if !ok {
+			check(field, errors.New(msg))
+		}
+	}
+
+	require("self", cfg.Self != "", "is required")
+	if cfg.Self != "" {
+		check("self", validURL(cfg.Self))
+	}
+	for _, l := range []struct{ field, addr string }{
+		{"listen", cfg.Listen}, {"api_listen", cfg.APIListen}, {"resp_listen", cfg.RESPListen}, {"memcache_listen", cfg.MemcacheListen},
+	} {
+		if l.addr != "" {
+			_, _, err := net.SplitHostPort(l.addr)
+			check(l.field, err)
+		}
+	}
+	seenPeers := make(map[string]bool)
+	for i, peer := range cfg.Peers {
+		field := fmt.Sprintf("peers[%d].id", i)
+		check(field, validURL(peer.ID))
+		require(field, !seenPeers[peer.ID], fmt.Sprintf("duplicate peer %q", peer.ID))
+		seenPeers[peer.ID] = true
+	}
+
+	_, err := consistenthash.HashByName(cfg.Ring.Hash)
+	check("ring.hash", err)
+	require("ring.virtual_nodes", cfg.Ring.VirtualNodes > 0, "must be positive")
+	require("ring.replication", cfg.Ring.Replication > 0, "must be positive")
+	require("ring.hot_key_threshold", cfg.Ring.HotKeyThreshold >= 0, "must not be negative")
+
+	require("transport.chunk_size", cfg.Transport.ChunkSize >= 0, "must not be negative")
+	require("transport.max_value_size", cfg.Transport.MaxValueSize >= 0, "must not be negative")
+	require("transport.shutdown_timeout", cfg.Transport.ShutdownTimeout > 0, "must be positive")
+
+	require("security.admin_token", cfg.Security.AdminToken == "" || cfg.Security.AdminTokenFile == "", "only one of admin_token and admin_token_file may be set")
+	require("security.tls_key_file", (cfg.Security.TLSCertFile == "") == (cfg.Security.TLSKeyFile == ""), "tls_cert_file and tls_key_file must be set together")
+	if cfg.Security.TLSCertFile != "" {
+		require("self", strings.HasPrefix(cfg.Self, "https://"), "must start with https:// when TLS is enabled")
+	}
+	require("security.tls_ca_file", cfg.Security.TLSCAFile == "" || cfg.Security.TLSCertFile != "", "requires tls_cert_file")
+	require("security.tls_client_auth", !cfg.Security.TLSClientAuth || cfg.Security.TLSCAFile != "", "requires tls_ca_file")
+	require("security.tls_allowed_identities", len(cfg.Security.TLSAllowedIdentities) == 0 || cfg.Security.TLSClientAuth, "requires tls_client_auth")
+	cfg.Auth.validate(cfg, check, require)
+	require("snapshot.interval", cfg.Snapshot.Interval > 0, "must be positive")
+	require("memory.budget", cfg.Memory.Budget >= 0, "must not be negative")
+	require("memory.cgroup_fraction", cfg.Memory.CgroupFraction >= 0 && cfg.Memory.CgroupFraction <= 1, "must be between 0 and 1")
+	require("memory.cgroup_fraction", cfg.Memory.Budget == 0 || cfg.Memory.CgroupFraction == 0, "only one of budget and cgroup_fraction may be set")
+	require("memory.rebalance_interval", cfg.Memory.RebalanceInterval > 0, "must be positive")
+	require("memory.accounting", cfg.Memory.Accounting == accountingPayload || cfg.Memory.Accounting == accountingFootprint,
+		fmt.Sprintf("unknown accounting %q, use payload or footprint", cfg.Memory.Accounting))
+	require("memory.storage", cfg.Memory.Storage == storageLRU || cfg.Memory.Storage == storageSlab,
+		fmt.Sprintf("unknown storage %q, use lru or slab", cfg.Memory.Storage))
+	require("memory.accounting", cfg.Memory.Storage != storageSlab || cfg.Memory.Accounting == accountingPayload,
+		"footprint accounting is not supported by slab storage")
+
+	require("groups", len(cfg.Groups) > 0, "at least one group is required")
+	seenGroups := make(map[string]bool)
+	for i, g := range cfg.Groups {
+		field := fmt.Sprintf("groups[%d]", i)
+		require(field+".name", g.Name != "", "is required")
+		require(field+".name", !seenGroups[g.Name], fmt.Sprintf("duplicate group %q", g.Name))
+		seenGroups[g.Name] = true
+		if cfg.Memory.enabled() {
+			require(field+".cache_bytes", g.CacheBytes == 0, "must not be set when the memory budget is enabled")
+			require(field+".min_bytes", g.MinBytes >= 0, "must not be negative")
+			require(field+".max_bytes", g.MaxBytes >= 0, "must not be negative")
+			require(field+".max_bytes", g.MaxBytes == 0 || g.MaxBytes >= g.MinBytes, "must not be less than min_bytes")
+		} else {
+			require(field+".cache_bytes", g.CacheBytes > 0, "must be positive")
+			require(field+".min_bytes", g.MinBytes == 0 && g.MaxBytes == 0, "min_bytes and max_bytes require the memory budget")
+		}
+		// slab 按整 page 使用内存，容量小于一个 page 时 page 会缩小，大一些的值就放不进内存了
+		if cfg.Memory.Storage == storageSlab {
+			pageMsg := fmt.Sprintf("must be at least %d bytes (one page) with slab storage", slab.DefaultPageSize)
+			if cfg.Memory.enabled() {
+				require(field+".min_bytes", g.MinBytes >= slab.DefaultPageSize, pageMsg)
+			} else {
+				require(field+".cache_bytes", g.CacheBytes <= 0 || g.CacheBytes >= slab.DefaultPageSize, pageMsg)
+			}
+		}
+		require(field+".ttl", g.TTL >= 0, "must not be negative")
+		g.Source.validate(field+".source", check, require)
+	}
+	return errors.Join(errs...)
+}
+
+// 缓存项计入容量的方式
+const (
+	accountingPayload   = "payload"
+	accountingFootprint = "footprint"
+)
+
+// 缓存项的存储方式
+const (
+	storageLRU  = "lru"
+	storageSlab = "slab"
+)
+
+// 数据源类型
+const (
+	sourceHTTP = "http"
+	sourceDir  = "dir"
+	sourceSQL  = "sql"
+)
+
+func (a *AuthConfig) validate(cfg *Config, check func(string, error), require func(string, bool, string)) {
+	require("auth.cluster_secret", a.ClusterSecret == "" || a.ClusterSecretFile == "", "only one of cluster_secret and cluster_secret_file may be set")
+	require("auth.replay_window", a.ReplayWindow > 0, "must be positive")
+	if !a.enabled() {
+		require("auth.clients", len(a.Clients) == 0, "requires cluster_secret")
+		return
+	}
+	// Redis 和 memcached 协议没有认证，开启认证之后不能提供
+	require("resp_listen", cfg.RESPListen == "", "is not supported when auth is enabled")
+	require("memcache_listen", cfg.MemcacheListen == "", "is not supported when auth is enabled")
+	groups := map[string]bool{network.AllGroups: true}
+	for _, g := range cfg.Groups {
+		groups[g.Name] = true
+	}
+	seen := make(map[string]bool)
+	for i, client := range a.Clients {
+		field := fmt.Sprintf("auth.clients[%d]", i)
+		require(field+".name", client.Name != "", "is required")
+		require(field+".name", !seen[client.Name], fmt.Sprintf("duplicate client %q", client.Name))
+		seen[client.Name] = true
+		require(field+".token", (client.Token == "") != (client.TokenFile == ""), "exactly one of token and token_file must be set")
+		require(field+".groups", len(client.Groups) > 0, "is required")
+		names := make([]string, 0, len(client.Groups))
+		for name := range client.Groups {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			require(field+".groups", groups[name], fmt.Sprintf("unknown group %q", name))
+			_, err := network.ParsePermission(client.Groups[name]...)
+			check(field+".groups."+name, err)
+		}
+	}
+}
+
+func (src *SourceConfig) validate(field string, check func(string, error), require func(string, bool, string)) {
+	switch src.Type {
+	case sourceHTTP:
+		require(field+".url", src.URL != "", "is required")
+		if src.URL != "" {
+			check(field+".url", validURL(src.URL))
+			require(field+".url", strings.Contains(src.URL, "{key}"), "must contain {key}")
+		}
+	case sourceDir:
+		require(field+".dir", src.Dir != "", "is required")
+		require(field+".pattern", src.Pattern == "" || strings.Contains(src.Pattern, "{key}"), "must contain {key}")
+	case sourceSQL:
+		require(field+".driver", src.Driver != "", "is required")
+		if src.Driver != "" {
+			require(field+".driver", slices.Contains(sql.Drivers(), src.Driver),
+				fmt.Sprintf("driver %q is not linked into this binary (import it in a file of cmd/geecache-server and rebuild), available: %v", src.Driver, sql.Drivers()))
+		}
+		require(field+".query", src.Query != "", "is required")
+	default:
+		check(field+".type", fmt.Errorf("unknown source type %q, use http, dir or sql", src.Type))
+	}
+	require(field+".max_size", src.MaxSize >= 0, "must not be negative")
+	require(field+".timeout", src.Timeout > 0, "must be positive")
+}
+
+// PeerIDs 返回包括本节点在内的所有节点
+func (cfg *Config) PeerIDs() map[consistenthash.NodeID]consistenthash.Meta {
+	peers := map[consistenthash.NodeID]consistenthash.Meta{
+		consistenthash.NodeID(cfg.Self): {Zone: cfg.Zone, Rack: cfg.Rack},
+	}
+	for _, peer := range cfg.Peers {
+		if peer.ID != cfg.Self {
+			peers[consistenthash.NodeID(peer.ID)] = consistenthash.Meta{Zone: peer.Zone, Rack: peer.Rack}
+		}
+	}
+	return peers
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"geecache/network"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const yamlConfig = `
+self: http://localhost:8001
+peers:
+  - id: http://localhost:8002
+    zone: b
+transport:
+  chunk_size: 64KiB
+groups:
+  - name: yaml-scores
+    cache_bytes: 1MiB
+    ttl: 10m
+    source:
+      url: http://origin/{key}
+`
+
+const jsonConfig = `{
+	"self": "http://localhost:8001",
+	"peers": [{"id": "http://localhost:8002", "zone": "b"}],
+	"transport": {"chunk_size": 65536},
+	"groups": [{"name": "yaml-scores", "cache_bytes": "1MiB", "ttl": "10m", "source": {"url": "http://origin/{key}"}}]
+}`
+
+const tomlConfig = `
+self = "http://localhost:8001"
+
+[[peers]]
+id = "http://localhost:8002"
+zone = "b"
+
+[transport]
+chunk_size = "64KiB"
+
+[[groups]]
+name = "yaml-scores"
+cache_bytes = 1048576
+ttl = "10m"
+source = { url = "http://origin/{key}" }
+`
+
+func TestParseConfig(t *testing.T) {
+	want, err := ParseConfig([]byte(yamlConfig), ".yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want.Listen != "localhost:8001" || want.Ring.VirtualNodes != 50 || want.Transport.ChunkSize != 64<<10 ||
+		want.Groups[0].CacheBytes != 1<<20 || time.Duration(want.Groups[0].TTL) != 10*time.Minute ||
+		time.Duration(want.Groups[0].Source.Timeout) != 5*time.Second {
+		t.Fatalf("unexpected config %+v", want)
+	}
+	for ext, data := range map[string]string{".json": jsonConfig, ".toml": tomlConfig} {
+		got, err := ParseConfig([]byte(data), ext)
+		if err != nil {
+			t.Fatalf("%s: %v", ext, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: got %+v, want %+v", ext, got, want)
+		}
+	}
+
+	example, err := os.ReadFile("example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseConfig(example, ".yaml"); err != nil {
+		t.Fatalf("example.yaml: %v", err)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	_, err := ParseConfig([]byte(`
+self: localhost:8001
+peers:
+  - id: http://localhost:8002
+  - id: http://localhost:8002
+ring:
+  hash: md5
+  replication: -1
+groups:
+  - name: a
+    cache_bytes: 0
+    source:
+      url: http://origin/
+  - name: a
+    cache_bytes: 1KiB
+    source:
+      url: http://origin/{key}
+`), ".yaml")
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	for _, want := range []string{
+		"self: ",
+		"peers[1].id: duplicate peer",
+		"ring.hash: consistenthash: unknown hash function",
+		"ring.replication: must be positive",
+		"groups[0].cache_bytes: must be positive",
+		"groups[0].source.url: must contain {key}",
+		"groups[1].name: duplicate group",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %q in:\n%v", want, err)
+		}
+	}
+
+	for _, tc := range []struct{ data, ext, want string }{
+		{`{"self": "http://localhost:8001", "grups": []}`, ".json", `unknown field "grups"`},
+		{`ttl = `, ".toml", "toml"},
+		{`self: http://localhost:8001`, ".ini", "unknown config format"},
+		{`{"transport": {"read_timeout": 30}}`, ".json", "duration must be a string"},
+		{`{"groups": [{"cache_bytes": "1 TB"}]}`, ".json", "invalid size"},
+		{`{"groups": [{"source": {"type": "ftp"}}]}`, ".json", `groups[0].source.type: unknown source type "ftp"`},
+		{`{"groups": [{"source": {"type": "dir"}}]}`, ".json", "groups[0].source.dir: is required"},
+		{`{"groups": [{"source": {"type": "sql", "driver": "nosuchdb", "query": "q"}}]}`, ".json", `driver "nosuchdb" is not linked`},
+		{`{"memory": {"budget": "1GiB", "cgroup_fraction": 0.5}}`, ".json", "memory.cgroup_fraction: only one of budget and cgroup_fraction"},
+		{`{"memory": {"budget": "1GiB"}, "groups": [{"cache_bytes": "1MiB"}]}`, ".json", "groups[0].cache_bytes: must not be set when the memory budget is enabled"},
+		{`{"memory": {"budget": "1GiB"}, "groups": [{"min_bytes": "2MiB", "max_bytes": "1MiB"}]}`, ".json", "groups[0].max_bytes: must not be less than min_bytes"},
+		{`{"groups": [{"cache_bytes": "1MiB", "min_bytes": "1MiB"}]}`, ".json", "min_bytes and max_bytes require the memory budget"},
+		{`{"memory": {"accounting": "exact"}}`, ".json", `memory.accounting: unknown accounting "exact"`},
+		{`{"security": {"tls_client_auth": true}}`, ".json", "security.tls_client_auth: requires tls_ca_file"},
+		{`{"security": {"tls_ca_file": "ca.crt"}}`, ".json", "security.tls_ca_file: requires tls_cert_file"},
+		{`{"memory": {"storage": "arena"}}`, ".json", `memory.storage: unknown storage "arena"`},
+		{`{"memory": {"storage": "slab", "accounting": "footprint"}}`, ".json", "memory.accounting: footprint accounting is not supported by slab storage"},
+		{`{"memory": {"storage": "slab"}, "groups": [{"cache_bytes": "512KiB"}]}`, ".json", "groups[0].cache_bytes: must be at least 1048576 bytes (one page) with slab storage"},
+		{`{"memory": {"storage": "slab", "budget": "1GiB"}, "groups": [{}]}`, ".json", "groups[0].min_bytes: must be at least 1048576 bytes (one page) with slab storage"},
+		{`{"auth": {"clients": [{"name": "web", "token": "t"}]}}`, ".json", "auth.clients: requires cluster_secret"},
+		{`{"auth": {"cluster_secret": "s"}, "resp_listen": ":6379"}`, ".json", "resp_listen: is not supported when auth is enabled"},
+		{`{"auth": {"cluster_secret": "s", "clients": [{"name": "web", "groups": {"*": ["read"]}}]}}`, ".json", "auth.clients[0].token: exactly one of token and token_file"},
+		{`{"auth": {"cluster_secret": "s", "clients": [{"name": "web", "token": "t", "groups": {"scores": ["read"]}}]}}`, ".json", `auth.clients[0].groups: unknown group "scores"`},
+		{`{"auth": {"cluster_secret": "s", "clients": [{"name": "web", "token": "t", "groups": {"*": ["delete"]}}]}}`, ".json", `auth.clients[0].groups.*: unknown permission "delete"`},
+	} {
+		if _, err := ParseConfig([]byte(tc.data), tc.ext); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected error containing %q, got %v", tc.data, tc.want, err)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`
+self: http://localhost:8001
+peers:
+  - id: http://localhost:8002
+  - id: http://localhost:8003
+security:
+  admin_token: secret
+groups:
+  - name: reload-scores
+    cache_bytes: 1MiB
+    source:
+      url: http://origin/{key}
+`), ".yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := network.NewAdminServer(s.cache, "secret")
+	peers := func() []string {
+		req := httptest.NewRequest(http.MethodGet, "/admin/peers", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		var resp struct {
+			Peers []network.PeerInfo `json:"peers"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, p := range resp.Peers {
+			ids = append(ids, string(p.ID)+"/"+p.Zone)
+		}
+		slices.Sort(ids)
+		return ids
+	}
+	if got := peers(); !slices.Equal(got, []string{"http://localhost:8001/", "http://localhost:8002/", "http://localhost:8003/"}) {
+		t.Fatalf("unexpected peers %v", got)
+	}
+
+	next, err := ParseConfig([]byte(`
+self: http://localhost:8001
+api_listen: ":9999"
+peers:
+  - id: http://localhost:8002
+    zone: b
+  - id: http://localhost:8004
+security:
+  admin_token: secret
+groups:
+  - name: reload-scores
+    cache_bytes: 2MiB
+    ttl: 1m
+    source:
+      url: http://origin-2/{key}
+`), ".yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	restart := s.reload(next)
+	if got := peers(); !slices.Equal(got, []string{"http://localhost:8001/", "http://localhost:8002/b", "http://localhost:8004/"}) {
+		t.Fatalf("unexpected peers after reload %v", got)
+	}
+	g := s.groups["reload-scores"]
+	if g.CacheBytes() != 2<<20 || g.TTL() != time.Minute {
+		t.Fatalf("group not reconfigured: cache_bytes %d, ttl %v", g.CacheBytes(), g.TTL())
+	}
+	if !slices.Equal(restart, []string{"api_listen"}) {
+		t.Fatalf("expected api_listen to require a restart, got %v", restart)
+	}
+	// 再次加载相同的配置时只有 api_listen 仍然需要重启
+	if restart := s.reload(next); !slices.Equal(restart, []string{"api_listen"}) {
+		t.Fatalf("unexpected restart fields %v", restart)
+	}
+}
+
+func TestLoadAuthConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := dir + "/" + name
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write("cluster-secret", "s3cret\n")
+	write("ops-token", "ops-token\n")
+	path := write("geecache.yaml", `
+self: http://localhost:8001
+auth:
+  cluster_secret_file: `+dir+`/cluster-secret
+  clients:
+    - name: ops
+      token_file: `+dir+`/ops-token
+      groups:
+        "*": [admin]
+    - name: web
+      token: web-token
+      groups:
+        auth-scores: [read]
+groups:
+  - name: auth-scores
+    cache_bytes: 1MiB
+    source:
+      url: http://origin/{key}
+`)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Auth.ClusterSecret != "s3cret" || cfg.Auth.Clients[0].Token != "ops-token" || time.Duration(cfg.Auth.ReplayWindow) != 30*time.Second {
+		t.Fatalf("unexpected auth config %+v", cfg.Auth)
+	}
+	s, err := newServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 运维接口没有 admin_token 时使用 auth 中的客户端
+	admin := network.NewAdminServer(s.cache, "")
+	for token, want := range map[string]int{"": http.StatusUnauthorized, "web-token": http.StatusForbidden, "ops-token": http.StatusOK} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/peers", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("token %q: got %d, want %d", token, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// geecache-server 是按照配置文件运行的 geecache 节点：
+//
+//	geecache-server -config /etc/geecache/config.yaml
+//
+// 收到 SIGHUP 时重新读取配置文件，应用节点列表以及 group 的 cache_bytes、ttl 和 source 的变化，
+// 其他字段的变化只打印警告，重启之后才会生效。收到 SIGINT 或 SIGTERM 时停止服务并保存快照。
+//
+// 程序本身不链接任何 database/sql 驱动。使用 sql 数据源时，在本目录中添加一个导入驱动的文件
+// 然后重新编译，例如 drivers.go：
+//
+//	package main
+//
+//	import _ "github.com/lib/pq"
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	var configPath string
+	var check bool
+	flag.StringVar(&configPath, "config", "", "Path of the configuration file (.yaml, .json or .toml)")
+	flag.BoolVar(&check, "check", false, "Validate the configuration file and exit")
+	flag.Parse()
+	if configPath == "" {
+		fmt.Fprintln(os.Stderr, "geecache-server: -config is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
+	if check {
+		fmt.Println("configuration ok")
+		return
+	}
+
+	s, err := newServer(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	errCh := s.start()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	for {
+		select {
+		case err := <-errCh:
+			s.shutdown()
+			log.Fatal(err)
+		case sig := <-sigCh:
+			if sig != syscall.SIGHUP {
+				log.Printf("received %v, shutting down", sig)
+				s.shutdown()
+				return
+			}
+			newCfg, err := LoadConfig(configPath)
+			if err != nil {
+				log.Printf("reload: keeping the current configuration:\n%v", err)
+				continue
+			}
+			s.reload(newCfg)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"geecache"
+	"geecache/consistenthash"
+	"geecache/network"
+	"geecache/source"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// server 是按照配置运行的一个节点
+type server struct {
+	// 当前生效的配置
+	cfg   *Config
+	cache *network.CacheServer
+	// 每个 server 使用独立的注册表，同一个进程内可以运行多个 server
+	registry *geecache.Registry
+	groups   map[string]*geecache.Group
+	// 每个 group 当前的数据源，被替换或者退出时关闭其中的 io.Closer（例如 sql 数据源的连接池）
+	sources map[string]geecache.Getter
+	// 所有 group 共享的内存预算，没有配置时为 nil
+	budget *geecache.Budget
+	// 节点间通信的 TLS 参数，没有配置证书时为 nil
+	tls *network.TLSConfig
+	// 请求的认证和授权，没有开启 auth 时为 nil
+	auth *network.Auth
+
+	// peerServer 处理节点间的请求和运维接口，apiServers 处理客户端的请求
+	peerServer  *http.Server
+	apiServers  []*http.Server
+	resp        *network.RESPServer
+	memcache    *network.MemcacheServer
+	snapshotter *geecache.Snapshotter
+}
+
+// newServer 按照 cfg 创建 group 和 CacheServer，不监听任何地址
+func newServer(cfg *Config) (*server, error) {
+	s := &server{cfg: cfg, registry: geecache.NewRegistry(), groups: make(map[string]*geecache.Group), sources: make(map[string]geecache.Getter)}
+	if sec := cfg.Security; sec.TLSCertFile != "" {
+		tlsCfg, err := network.LoadTLSConfig(sec.TLSCertFile, sec.TLSKeyFile, sec.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("security: %w", err)
+		}
+		tlsCfg.RequireClientCert = sec.TLSClientAuth
+		tlsCfg.AllowedIdentities = sec.TLSAllowedIdentities
+		s.tls = tlsCfg
+	}
+	if cfg.Auth.enabled() {
+		auth, err := newAuth(cfg.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("auth: %w", err)
+		}
+		s.auth = auth
+	}
+	if cfg.Memory.enabled() {
+		budgetOpts := []geecache.BudgetOption{geecache.WithRebalanceInterval(time.Duration(cfg.Memory.RebalanceInterval))}
+		if cfg.Memory.CgroupFraction > 0 {
+			b, err := geecache.NewBudgetFromCgroup(cfg.Memory.CgroupFraction, budgetOpts...)
+			if err != nil {
+				return nil, fmt.Errorf("memory.cgroup_fraction: %w", err)
+			}
+			s.budget = b
+		} else {
+			s.budget = geecache.NewBudget(int64(cfg.Memory.Budget), budgetOpts...)
+		}
+		log.Printf("memory budget: %d bytes shared by %d groups", s.budget.Total(), len(cfg.Groups))
+	}
+	var groups []*geecache.Group
+	for _, gc := range cfg.Groups {
+		getter, err := newSource(gc.Source)
+		if err != nil {
+			return nil, fmt.Errorf("group %s: %w", gc.Name, err)
+		}
+		opts := []geecache.GroupOption{geecache.WithTTL(time.Duration(gc.TTL))}
+		if s.budget != nil {
+			opts = append(opts, geecache.WithBudget(s.budget, int64(gc.MinBytes), int64(gc.MaxBytes)))
+		}
+		if cfg.Memory.Accounting == accountingFootprint {
+			opts = append(opts, geecache.WithFootprintAccounting())
+		}
+		if cfg.Memory.Storage == storageSlab {
+			opts = append(opts, geecache.WithSlabStore())
+		}
+		g, err := s.registry.NewGroup(gc.Name, int64(gc.CacheBytes), getter, opts...)
+		if err != nil {
+			return nil, err
+		}
+		s.groups[gc.Name] = g
+		s.sources[gc.Name] = getter
+		groups = append(groups, g)
+	}
+
+	// 配置已经检查过，哈希函数一定存在
+	hash, _ := consistenthash.HashByName(cfg.Ring.Hash)
+	cacheOpts := []network.Option{
+		network.WithGroups(groups...),
+		network.WithHash(hash),
+		network.WithVirtualNodes(cfg.Ring.VirtualNodes),
+		network.WithReplication(cfg.Ring.Replication),
+		network.WithHotKeySpread(cfg.Ring.HotKeyThreshold),
+		network.WithLocality(consistenthash.Meta{Zone: cfg.Zone, Rack: cfg.Rack}),
+		network.WithTransferRate(max(cfg.Transport.TransferRate, 0)),
+		network.WithChunkSize(int(cfg.Transport.ChunkSize)),
+		network.WithMaxValueSize(int64(cfg.Transport.MaxValueSize)),
+		network.WithShutdownHandoff(cfg.Transport.ShutdownHandoff),
+	}
+	if s.tls != nil {
+		cacheOpts = append(cacheOpts, network.WithTLS(s.tls))
+	}
+	if s.auth != nil {
+		cacheOpts = append(cacheOpts, network.WithAuth(s.auth))
+	}
+	s.cache = network.NewCacheServer(cfg.Self, cacheOpts...)
+	for id, meta := range cfg.PeerIDs() {
+		s.cache.AddPeer(id, meta)
+	}
+	for _, g := range groups {
+		g.RegisterPeerPicker(s.cache)
+	}
+	return s, nil
+}
+
+// newAuth 按照配置创建 network.Auth
+func newAuth(cfg AuthConfig) (*network.Auth, error) {
+	var clients []network.Client
+	for _, cc := range cfg.Clients {
+		client := network.Client{Name: cc.Name, Token: cc.Token, Groups: make(map[string]network.Permission, len(cc.Groups))}
+		for group, names := range cc.Groups {
+			perm, err := network.ParsePermission(names...)
+			if err != nil {
+				return nil, fmt.Errorf("client %s: %w", cc.Name, err)
+			}
+			client.Groups[group] = perm
+		}
+		clients = append(clients, client)
+	}
+	return network.NewAuth(
+		network.WithClusterSecret([]byte(cfg.ClusterSecret)),
+		network.WithReplayWindow(time.Duration(cfg.ReplayWindow)),
+		network.WithClients(clients...),
+	), nil
+}
+
+// newSource 按照配置创建 group 的数据源
+func newSource(cfg SourceConfig) (geecache.Getter, error) {
+	opts := []source.Option{source.WithTimeout(time.Duration(cfg.Timeout)), source.WithMaxSize(int64(cfg.MaxSize))}
+	switch cfg.Type {
+	case sourceDir:
+		if cfg.Pattern != "" {
+			opts = append(opts, source.WithPathPattern(cfg.Pattern))
+		}
+		return source.NewDir(cfg.Dir, opts...), nil
+	case sourceSQL:
+		db, err := sql.Open(cfg.Driver, cfg.DSN)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Column != "" {
+			opts = append(opts, source.WithColumn(cfg.Column))
+		}
+		return source.NewSQL(db, cfg.Query, opts...), nil
+	default:
+		for k, v := range cfg.Headers {
+			opts = append(opts, source.WithHeader(k, v))
+		}
+		return source.NewHTTP(cfg.URL, opts...)
+	}
+}
+
+// closeSource 关闭不再使用的数据源，sql 数据源会关闭它的连接池。
+// 正在执行的查询会先完成，替换之前已经开始的加载可能因此失败，下次 Get 时使用新的数据源。
+func closeSource(group string, getter geecache.Getter) {
+	if c, ok := getter.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Printf("group %s: closing source: %v", group, err)
+		}
+	}
+}
+
+func (s *server) newHTTPServer(addr string, handler http.Handler) *http.Server {
+	t := s.cfg.Transport
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      handler,
+		ReadTimeout:  time.Duration(t.ReadTimeout),
+		WriteTimeout: time.Duration(t.WriteTimeout),
+		IdleTimeout:  time.Duration(t.IdleTimeout),
+	}
+	return srv
+}
+
+// start 在后台监听所有配置的地址，返回的 channel 接收监听出错时的错误，shutdown 引起的退出不算错误
+func (s *server) start() <-chan error {
+	cfg := s.cfg
+	errCh := make(chan error, 4)
+	report := func(err error) {
+		if err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+			errCh <- err
+		}
+	}
+	serveHTTP := func(srv *http.Server) {
+		if srv.TLSConfig != nil {
+			report(srv.ListenAndServeTLS("", ""))
+		} else if cfg.Security.TLSCertFile != "" {
+			report(srv.ListenAndServeTLS(cfg.Security.TLSCertFile, cfg.Security.TLSKeyFile))
+		} else {
+			report(srv.ListenAndServe())
+		}
+	}
+
+	peerMux := http.NewServeMux()
+	peerMux.Handle("/_geecache/", s.cache)
+	if cfg.Security.AdminToken != "" || s.auth != nil {
+		peerMux.Handle("/admin/", network.NewAdminServer(s.cache, cfg.Security.AdminToken))
+	}
+	s.peerServer = s.newHTTPServer(cfg.Listen, peerMux)
+	if s.tls != nil {
+		// 节点间的接口按照 tls_client_auth 验证客户端证书，客户端的接口不要求客户端证书
+		s.peerServer.TLSConfig = s.tls.ServerConfig()
+	}
+	go serveHTTP(s.peerServer)
+	log.Printf("geecache %s is listening on %s", cfg.Self, cfg.Listen)
+
+	var groups []*geecache.Group
+	for _, gc := range cfg.Groups {
+		groups = append(groups, s.groups[gc.Name])
+	}
+	if cfg.APIListen != "" {
+		apiMux := http.NewServeMux()
+		apiOpts := []network.APIOption{network.WithAPIGroups(groups...)}
+		if s.auth != nil {
+			apiOpts = append(apiOpts, network.WithAPIAuth(s.auth))
+		}
+		apiMux.Handle("/v1/", network.NewAPIServer(apiOpts...))
+		srv := s.newHTTPServer(cfg.APIListen, apiMux)
+		s.apiServers = append(s.apiServers, srv)
+		go serveHTTP(srv)
+		log.Println("api server is listening on", cfg.APIListen)
+	}
+	if cfg.RESPListen != "" {
+		s.resp = network.NewRESPServer(groups...)
+		go func() { report(s.resp.ListenAndServe(cfg.RESPListen)) }()
+		log.Println("resp server is listening on", cfg.RESPListen)
+	}
+	if cfg.MemcacheListen != "" {
+		s.memcache = network.NewMemcacheServer(groups...)
+		go func() { report(s.memcache.ListenAndServe(cfg.MemcacheListen)) }()
+		log.Println("memcache server is listening on", cfg.MemcacheListen)
+	}
+	if cfg.Snapshot.Dir != "" {
+		s.snapshotter = s.registry.NewSnapshotter(cfg.Snapshot.Dir, time.Duration(cfg.Snapshot.Interval))
+		if err := s.snapshotter.LoadAll(); err != nil {
+			log.Println("restore snapshot:", err)
+		}
+		s.snapshotter.Start()
+	}
+	return errCh
+}
+
+// shutdown 平滑地退出集群：先停止接受客户端的请求，再迁移热点 key 并通知其他节点，
+// 然后停止节点间的服务，等待正在进行的加载完成，最后保存快照
+func (s *server) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.cfg.Transport.ShutdownTimeout))
+	defer cancel()
+	for _, srv := range s.apiServers {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("shutdown %s: %v", srv.Addr, err)
+		}
+	}
+	if s.resp != nil {
+		s.resp.Close()
+	}
+	if s.memcache != nil {
+		s.memcache.Close()
+	}
+	if err := s.cache.Shutdown(ctx); err != nil {
+		log.Println("leave cluster:", err)
+	}
+	if s.peerServer != nil {
+		if err := s.peerServer.Shutdown(ctx); err != nil {
+			log.Printf("shutdown %s: %v", s.peerServer.Addr, err)
+		}
+	}
+	for name, g := range s.groups {
+		if err := g.Shutdown(ctx); err != nil {
+			log.Printf("shutdown group %s: %v", name, err)
+		}
+	}
+	for name, getter := range s.sources {
+		closeSource(name, getter)
+	}
+	if s.budget != nil {
+		s.budget.Close()
+	}
+	if s.snapshotter != nil {
+		s.snapshotter.Stop()
+		if err := s.snapshotter.SaveAll(); err != nil {
+			log.Println("save snapshot:", err)
+		}
+	}
+}
+
+// reload 应用 cfg 中可以在运行时修改的部分：节点列表，group 的 cache_bytes、ttl 和 source。
+// 其他字段的变化只打印警告。返回需要重启才能生效的字段。
+func (s *server) reload(cfg *Config) []string {
+	applied := *s.cfg
+	old, next := s.cfg.PeerIDs(), cfg.PeerIDs()
+	for id, meta := range next {
+		// 本节点的位置信息需要重启才能修改
+		if id == consistenthash.NodeID(s.cfg.Self) {
+			continue
+		}
+		if oldMeta, ok := old[id]; !ok || oldMeta != meta {
+			log.Printf("reload: adding peer %s", id)
+			s.cache.AddPeer(id, meta)
+		}
+	}
+	for id := range old {
+		if _, ok := next[id]; !ok && id != consistenthash.NodeID(s.cfg.Self) {
+			log.Printf("reload: removing peer %s", id)
+			s.cache.DelPeeker(id)
+		}
+	}
+	applied.Peers = cfg.Peers
+
+	applied.Groups = slices.Clone(s.cfg.Groups)
+	nextGroups := make(map[string]GroupConfig)
+	for _, gc := range cfg.Groups {
+		nextGroups[gc.Name] = gc
+	}
+	for i, gc := range applied.Groups {
+		n, ok := nextGroups[gc.Name]
+		if !ok {
+			continue
+		}
+		g := s.groups[gc.Name]
+		if n.CacheBytes != gc.CacheBytes {
+			log.Printf("reload: group %s cache_bytes %d -> %d", gc.Name, gc.CacheBytes, n.CacheBytes)
+			g.SetCacheBytes(int64(n.CacheBytes))
+			applied.Groups[i].CacheBytes = n.CacheBytes
+		}
+		if n.TTL != gc.TTL {
+			log.Printf("reload: group %s ttl %v -> %v", gc.Name, time.Duration(gc.TTL), time.Duration(n.TTL))
+			g.SetTTL(time.Duration(n.TTL))
+			applied.Groups[i].TTL = n.TTL
+		}
+		if !reflect.DeepEqual(n.Source, gc.Source) {
+			getter, err := newSource(n.Source)
+			if err != nil {
+				log.Printf("reload: group %s: keeping the current source: %v", gc.Name, err)
+				continue
+			}
+			log.Printf("reload: group %s source changed", gc.Name)
+			g.SetGetter(getter)
+			closeSource(gc.Name, s.sources[gc.Name])
+			s.sources[gc.Name] = getter
+			applied.Groups[i].Source = n.Source
+		}
+	}
+
+	restart := restartRequired(&applied, cfg)
+	if len(restart) > 0 {
+		log.Printf("reload: changes to %s require a restart", strings.Join(restart, ", "))
+	}
+	s.cfg = &applied
+	return restart
+}
+
+// restartRequired 返回 applied 和 cfg 中不同的、不能在运行时修改的字段
+func restartRequired(applied, cfg *Config) []string {
+	var fields []string
+	a, b := reflect.ValueOf(*applied), reflect.ValueOf(*cfg)
+	for i := range a.NumField() {
+		if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			name, _, _ := strings.Cut(a.Type().Field(i).Tag.Get("json"), ",")
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
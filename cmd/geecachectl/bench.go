@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// benchResult 是 bench 的输出
+type benchResult struct {
+	Requests int64         `json:"requests"`
+	Errors   int64         `json:"errors"`
+	Duration time.Duration `json:"duration_ns"`
+	// 每秒完成的请求数
+	Throughput float64       `json:"throughput"`
+	P50        time.Duration `json:"p50_ns"`
+	P90        time.Duration `json:"p90_ns"`
+	P99        time.Duration `json:"p99_ns"`
+	Max        time.Duration `json:"max_ns"`
+	// 最后一个错误，便于排查
+	LastError string `json:"last_error,omitempty"`
+}
+
+// cmdBench 用 -c 个并发的客户端直接向 key 的 owner 发送请求，持续 -d 时间或者发送 -n 个请求
+func cmdBench(c *cluster, out *printer, args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	concurrency := flags.Int("c", 16, "Number of concurrent clients")
+	requests := flags.Int64("n", 0, "Total number of requests, 0 to run for -d")
+	duration := flags.Duration("d", 10*time.Second, "How long to run when -n is 0")
+	keys := flags.Int("keys", 1000, "Number of distinct keys")
+	prefix := flags.String("prefix", "bench-", "Prefix of the keys")
+	writes := flags.Float64("writes", 0, "Fraction of requests that are writes, between 0 and 1")
+	size := flags.Int("size", 128, "Size of the written values in bytes")
+	preload := flags.Bool("preload", true, "Write every key before the benchmark so that reads hit the cache")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if *concurrency <= 0 || *keys <= 0 || *requests < 0 || *size < 0 || *writes < 0 || *writes > 1 {
+		return errUsage
+	}
+
+	value := bytes.Repeat([]byte("x"), *size)
+	key := func(i int) string { return *prefix + strconv.Itoa(i) }
+	if *preload {
+		for i := range *keys {
+			if err := c.set(c.holders(key(i))[0], key(i), value); err != nil {
+				return fmt.Errorf("preloading %s: %w", key(i), err)
+			}
+		}
+	}
+
+	var sent, errs atomic.Int64
+	var lastErr atomic.Value
+	deadline := time.Now().Add(*duration)
+	latencies := make([][]time.Duration, *concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := range *concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if *requests > 0 {
+					if sent.Add(1) > *requests {
+						return
+					}
+				} else if time.Now().After(deadline) {
+					return
+				}
+				k := key(rand.IntN(*keys))
+				owner := c.holders(k)[0]
+				begin := time.Now()
+				var err error
+				if rand.Float64() < *writes {
+					err = c.set(owner, k, value)
+				} else {
+					_, _, err = c.get(owner, k)
+				}
+				latencies[w] = append(latencies[w], time.Since(begin))
+				if err != nil {
+					errs.Add(1)
+					lastErr.Store(err.Error())
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	all := slices.Concat(latencies...)
+	slices.Sort(all)
+	res := benchResult{Requests: int64(len(all)), Errors: errs.Load(), Duration: elapsed}
+	if len(all) > 0 {
+		percentile := func(p float64) time.Duration { return all[int(float64(len(all)-1)*p)] }
+		res.Throughput = float64(len(all)) / elapsed.Seconds()
+		res.P50, res.P90, res.P99, res.Max = percentile(0.5), percentile(0.9), percentile(0.99), all[len(all)-1]
+	}
+	if v := lastErr.Load(); v != nil {
+		res.LastError = v.(string)
+	}
+	rows := [][]string{
+		{"requests", strconv.FormatInt(res.Requests, 10)},
+		{"errors", strconv.FormatInt(res.Errors, 10)},
+		{"duration", res.Duration.Round(time.Millisecond).String()},
+		{"throughput", strconv.FormatFloat(res.Throughput, 'f', 0, 64) + " req/s"},
+		{"p50", res.P50.String()},
+		{"p90", res.P90.String()},
+		{"p99", res.P99.String()},
+		{"max", res.Max.String()},
+	}
+	if res.LastError != "" {
+		rows = append(rows, []string{"last error", res.LastError})
+	}
+	return out.print(res, []string{"METRIC", "VALUE"}, rows)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"geecache/compress"
+	"geecache/consistenthash"
+	"geecache/network"
+	pb "geecache/proto"
+	"geecache/util"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// cluster 通过节点间通讯的协议（/_geecache/）和运维接口（/admin/）访问集群
+type cluster struct {
+	cfg    *config
+	client *http.Client
+	// 与节点使用相同参数构造的哈希环，用来计算 key 的归属节点
+	ring *consistenthash.NodeMap
+}
+
+func newCluster(cfg *config) (*cluster, error) {
+	hash, err := consistenthash.HashByName(cfg.hash)
+	if err != nil {
+		return nil, err
+	}
+	c := &cluster{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		ring:   consistenthash.New(cfg.vnodes, hash),
+	}
+	if cfg.caFile != "" || cfg.certFile != "" || cfg.keyFile != "" {
+		tlsCfg, err := network.LoadTLSConfig(cfg.certFile, cfg.keyFile, cfg.caFile)
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsCfg.ClientConfig()
+		c.client.Transport = transport
+	}
+	c.ring.AddNodes(cfg.peers...)
+	if cfg.token != "" {
+		// 副本的位置与节点的可用区和机架有关，能访问运维接口时使用节点上的成员信息
+		if err := c.syncRing(); err != nil {
+			fmt.Fprintln(os.Stderr, "geecachectl: using -peers as the ring:", err)
+		}
+	}
+	return c, nil
+}
+
+// syncRing 用第一个能访问的节点上的成员信息（包括可用区和机架）重建哈希环
+func (c *cluster) syncRing() error {
+	var errs []string
+	for _, node := range c.cfg.peers {
+		peers, err := c.peers(node)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		hash, _ := consistenthash.HashByName(c.cfg.hash)
+		ring := consistenthash.New(c.cfg.vnodes, hash)
+		for _, peer := range peers {
+			ring.SetMeta(peer.ID, consistenthash.Meta{Zone: peer.Zone, Rack: peer.Rack})
+			ring.AddNodes(peer.ID)
+		}
+		c.ring = ring
+		return nil
+	}
+	return fmt.Errorf("no node reachable: %s", strings.Join(errs, "; "))
+}
+
+// holders 返回保存 key 的节点，owner 在前
+func (c *cluster) holders(key string) []consistenthash.NodeID {
+	return c.ring.GetNodes(key, c.cfg.replication)
+}
+
+// keyURL 返回节点上 key 的地址，与 CacheServer 的路由一致
+func (c *cluster) keyURL(node consistenthash.NodeID, key string) (string, error) {
+	return url.JoinPath(string(node), c.cfg.basePath, url.QueryEscape(c.cfg.group), url.QueryEscape(key))
+}
+
+func (c *cluster) do(method, url string, body io.Reader, header http.Header) ([]byte, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	// 集群开启 auth 时，节点间的接口也接受客户端的 bearer token
+	if c.cfg.token != "" && req.Header.Get("Authorization") == "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %v", err)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, bytes.TrimSpace(data))
+	}
+	return data, nil
+}
+
+// get 从 node 读取 key，返回解压后的值和缓存中使用的压缩算法
+func (c *cluster) get(node consistenthash.NodeID, key string) (util.ByteView, string, error) {
+	url, err := c.keyURL(node, key)
+	if err != nil {
+		return util.ByteView{}, "", err
+	}
+	// 显式设置 Accept-Encoding 后，http.Transport 不会自动解压，节点原样返回压缩过的值
+	header := http.Header{"Accept-Encoding": {strings.Join(compress.Names(), ", ")}}
+	data, err := c.do(http.MethodGet, url, nil, header)
+	if err != nil {
+		return util.ByteView{}, "", err
+	}
+	var resp pb.Response
+	if err := proto.Unmarshal(data, &resp); err != nil {
+		return util.ByteView{}, "", fmt.Errorf("decoding response body: %v", err)
+	}
+	view, err := compress.Decode(util.NewByteView(resp.GetValue(), resp.GetEncoding()))
+	return view, resp.GetEncoding(), err
+}
+
+// set 把 key 写入 node 的缓存，node 再复制给 key 的其他持有者。不会写入数据源。
+func (c *cluster) set(node consistenthash.NodeID, key string, value []byte) error {
+	url, err := c.keyURL(node, key)
+	if err != nil {
+		return err
+	}
+	body, err := proto.Marshal(&pb.SetRequest{Group: c.cfg.group, Key: key, Value: value})
+	if err != nil {
+		return err
+	}
+	_, err = c.do(http.MethodPut, url, bytes.NewReader(body), http.Header{"Content-Type": {"application/octet-stream"}})
+	return err
+}
+
+// evict 从 node 的缓存中删除 key
+func (c *cluster) evict(node consistenthash.NodeID, key string) error {
+	url, err := c.keyURL(node, key)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(http.MethodDelete, url, nil, nil)
+	return err
+}
+
+// admin 请求 node 的运维接口，把响应解码到 v
+func (c *cluster) admin(node consistenthash.NodeID, path string, v any) error {
+	if c.cfg.token == "" {
+		return fmt.Errorf("the admin API needs a token, use -token or GEECACHE_ADMIN_TOKEN")
+	}
+	url, err := url.JoinPath(string(node), path)
+	if err != nil {
+		return err
+	}
+	data, err := c.do(http.MethodGet, url, nil, http.Header{"Authorization": {"Bearer " + c.cfg.token}})
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
+	}
+	return nil
+}
+
+// peers 返回 node 的哈希环上的节点及其拥有的哈希空间比例
+func (c *cluster) peers(node consistenthash.NodeID) ([]network.PeerInfo, error) {
+	var resp struct {
+		Peers []network.PeerInfo `json:"peers"`
+	}
+	err := c.admin(node, "/admin/ring", &resp)
+	return resp.Peers, err
+}
+
+// groups 返回 node 上的 group 及其统计信息
+func (c *cluster) groups(node consistenthash.NodeID) ([]network.GroupInfo, error) {
+	var resp struct {
+		Groups []network.GroupInfo `json:"groups"`
+	}
+	err := c.admin(node, "/admin/groups", &resp)
+	return resp.Groups, err
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"geecache/consistenthash"
+	"geecache/network"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// getResult 是 get 的 JSON 输出
+type getResult struct {
+	Group string                `json:"group"`
+	Key   string                `json:"key"`
+	Node  consistenthash.NodeID `json:"node"`
+	// 缓存中保存值使用的压缩算法，Value 总是解压后的值
+	Encoding string `json:"encoding,omitempty"`
+	Size     int    `json:"size"`
+	// 值是合法的 UTF-8 时输出为字符串，否则输出为 base64
+	Value       string `json:"value,omitempty"`
+	ValueBase64 []byte `json:"value_base64,omitempty"`
+}
+
+// cmdGet 依次从 key 的 owner 和副本读取，表格模式下直接输出原始的值
+func cmdGet(c *cluster, out *printer, key string) error {
+	var errs []error
+	for _, node := range c.holders(key) {
+		view, encoding, err := c.get(node, key)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !out.json {
+			_, err := view.WriteTo(out.w)
+			return err
+		}
+		res := getResult{Group: c.cfg.group, Key: key, Node: node, Encoding: encoding, Size: view.Size()}
+		if s := view.String(); utf8.ValidString(s) {
+			res.Value = s
+		} else {
+			res.ValueBase64 = view.ByteSlice()
+		}
+		return out.print(res, nil, nil)
+	}
+	return errors.Join(errs...)
+}
+
+// cmdSet 把值写入 key 的 owner，owner 不可用时写入副本。value 为 - 时从标准输入读取值。
+func cmdSet(c *cluster, out *printer, key, value string) error {
+	data := []byte(value)
+	if value == "-" {
+		var err error
+		if data, err = io.ReadAll(os.Stdin); err != nil {
+			return err
+		}
+	}
+	var errs []error
+	for _, node := range c.holders(key) {
+		if err := c.set(node, key, data); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		res := map[string]any{"group": c.cfg.group, "key": key, "node": node, "size": len(data)}
+		return out.print(res, []string{"KEY", "NODE", "SIZE"}, [][]string{{key, string(node), strconv.Itoa(len(data))}})
+	}
+	return errors.Join(errs...)
+}
+
+// evictResult 是 delete 在一个节点上的结果
+type evictResult struct {
+	Node  consistenthash.NodeID `json:"node"`
+	Error string                `json:"error,omitempty"`
+}
+
+// cmdDelete 从所有节点的缓存中删除 key。哈希环变化后旧的持有者上可能还有过期的值，所以不只删除当前的持有者。
+func cmdDelete(c *cluster, out *printer, key string) error {
+	var results []evictResult
+	var rows [][]string
+	failed := 0
+	for _, node := range c.ring.Nodes() {
+		res := evictResult{Node: node}
+		status := "evicted"
+		if err := c.evict(node, key); err != nil {
+			res.Error = err.Error()
+			status = res.Error
+			failed++
+		}
+		results = append(results, res)
+		rows = append(rows, []string{string(node), status})
+	}
+	if err := out.print(map[string]any{"group": c.cfg.group, "key": key, "nodes": results}, []string{"NODE", "RESULT"}, rows); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed on %d of %d nodes", failed, len(results))
+	}
+	return nil
+}
+
+// cmdOwner 用与节点相同的一致性哈希计算 key 的持有者
+func cmdOwner(c *cluster, out *printer, key string) error {
+	holders := c.holders(key)
+	if len(holders) == 0 {
+		return errors.New("the ring is empty")
+	}
+	var rows [][]string
+	for i, node := range holders {
+		role := "replica"
+		if i == 0 {
+			role = "owner"
+		}
+		meta := c.ring.Meta(node)
+		rows = append(rows, []string{key, string(node), role, meta.Zone, meta.Rack})
+	}
+	res := map[string]any{"key": key, "owner": holders[0], "holders": holders}
+	return out.print(res, []string{"KEY", "NODE", "ROLE", "ZONE", "RACK"}, rows)
+}
+
+// memberInfo 是 members 输出的一个节点
+type memberInfo struct {
+	network.PeerInfo
+	// 能否访问该节点的运维接口
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error,omitempty"`
+	// 哈希环上有该节点的节点
+	SeenBy []consistenthash.NodeID `json:"seen_by"`
+}
+
+// cmdMembers 询问每个节点的哈希环，包括从其他节点的哈希环上发现的节点，从而发现成员信息不一致的节点
+func cmdMembers(c *cluster, out *printer) error {
+	members := make(map[consistenthash.NodeID]*memberInfo)
+	member := func(id consistenthash.NodeID) *memberInfo {
+		if members[id] == nil {
+			members[id] = &memberInfo{PeerInfo: network.PeerInfo{ID: id}, SeenBy: []consistenthash.NodeID{}}
+		}
+		return members[id]
+	}
+	queue := slices.Clone(c.cfg.peers)
+	queried := make(map[consistenthash.NodeID]bool)
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if queried[node] {
+			continue
+		}
+		queried[node] = true
+		m := member(node)
+		peers, err := c.peers(node)
+		if err != nil {
+			m.Error = err.Error()
+			continue
+		}
+		m.Reachable = true
+		for _, peer := range peers {
+			pm := member(peer.ID)
+			pm.SeenBy = append(pm.SeenBy, node)
+			if pm.Ownership == 0 {
+				pm.Zone, pm.Rack, pm.Ownership = peer.Zone, peer.Rack, peer.Ownership
+			}
+			queue = append(queue, peer.ID)
+		}
+	}
+
+	ids := make([]consistenthash.NodeID, 0, len(members))
+	for id := range members {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	reachable := 0
+	for _, m := range members {
+		if m.Reachable {
+			reachable++
+		}
+	}
+	list := make([]*memberInfo, 0, len(ids))
+	var rows [][]string
+	for _, id := range ids {
+		m := members[id]
+		list = append(list, m)
+		status := "up"
+		if !m.Reachable {
+			status = "down"
+		}
+		rows = append(rows, []string{
+			string(id), status, m.Zone, m.Rack,
+			strconv.FormatFloat(m.Ownership*100, 'f', 1, 64) + "%",
+			fmt.Sprintf("%d/%d", len(m.SeenBy), reachable),
+		})
+	}
+	if err := out.print(map[string]any{"members": list}, []string{"NODE", "STATUS", "ZONE", "RACK", "OWNERSHIP", "SEEN BY"}, rows); err != nil {
+		return err
+	}
+	for _, m := range list {
+		if len(m.SeenBy) != reachable {
+			return errors.New("nodes disagree on the membership")
+		}
+	}
+	return nil
+}
+
+// nodeStats 是 stats 输出的一个节点
+type nodeStats struct {
+	Node   consistenthash.NodeID `json:"node"`
+	Groups []network.GroupInfo   `json:"groups"`
+	Error  string                `json:"error,omitempty"`
+}
+
+// hitRate 返回命中率的百分比
+func hitRate(hits, gets int64) string {
+	if gets == 0 {
+		return "-"
+	}
+	return strconv.FormatFloat(float64(hits)*100/float64(gets), 'f', 1, 64) + "%"
+}
+
+func cmdStats(c *cluster, out *printer) error {
+	var list []nodeStats
+	var rows [][]string
+	for _, node := range c.ring.Nodes() {
+		groups, err := c.groups(node)
+		ns := nodeStats{Node: node, Groups: groups}
+		if err != nil {
+			ns.Error = err.Error()
+			rows = append(rows, []string{string(node), "error: " + ns.Error})
+		}
+		for _, g := range groups {
+			s := g.Stats
+			rows = append(rows, []string{
+				string(node), g.Name,
+				strconv.FormatInt(s.Items, 10), strconv.FormatInt(s.Bytes, 10), strconv.FormatInt(g.CacheBytes, 10),
+				strconv.FormatInt(s.Gets, 10), hitRate(s.CacheHits, s.Gets),
+				strconv.FormatInt(s.PeerLoads, 10), strconv.FormatInt(s.PeerErrors, 10),
+				strconv.FormatInt(s.LocalLoads, 10), strconv.FormatInt(s.LocalLoadErrs, 10),
+			})
+		}
+		list = append(list, ns)
+	}
+	header := strings.Fields("NODE GROUP ITEMS BYTES CACHE_BYTES GETS HIT_RATE PEER_LOADS PEER_ERRS LOCAL_LOADS LOCAL_ERRS")
+	return out.print(map[string]any{"nodes": list}, header, rows)
+}
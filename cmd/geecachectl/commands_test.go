@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"geecache"
+	"geecache/consistenthash"
+	"geecache/network"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const testToken = "admin-token"
+
+// testNode 是一个挂载了节点间协议和运维接口的 geecache 节点
+type testNode struct {
+	ts     *httptest.Server
+	server *network.CacheServer
+	group  *geecache.Group
+}
+
+func (n *testNode) id() consistenthash.NodeID {
+	return consistenthash.NodeID(n.ts.URL)
+}
+
+// newTestCluster 启动 size 个节点，节点 i 位于可用区 zone-(i%2)，使用与 geecache-server 默认配置相同的哈希环
+func newTestCluster(t *testing.T, size, replication int) []*testNode {
+	nodes := make([]*testNode, size)
+	for i := range nodes {
+		n := &testNode{}
+		mux := http.NewServeMux()
+		n.ts = httptest.NewServer(mux)
+		t.Cleanup(n.ts.Close)
+		g, err := geecache.NewRegistry().NewGroup("scores", 8<<20, geecache.GetterFunc(func(key string) ([]byte, error) {
+			return []byte("value-" + key), nil
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.group = g
+		n.server = network.NewCacheServer(n.ts.URL,
+			network.WithGroups(g),
+			network.WithHash(consistenthash.XXHash64),
+			network.WithReplication(replication),
+			network.WithLocality(consistenthash.Meta{Zone: "zone-" + strconv.Itoa(i%2)}),
+		)
+		g.RegisterPeerPicker(n.server)
+		mux.Handle("/_geecache/", n.server)
+		mux.Handle("/admin/", network.NewAdminServer(n.server, testToken))
+		nodes[i] = n
+	}
+	for _, n := range nodes {
+		for i, peer := range nodes {
+			n.server.AddPeer(peer.id(), consistenthash.Meta{Zone: "zone-" + strconv.Itoa(i%2)})
+		}
+	}
+	return nodes
+}
+
+func newTestConfig(nodes []*testNode, replication int, token string) *config {
+	cfg := &config{
+		group:       "scores",
+		output:      "json",
+		token:       token,
+		basePath:    "/_geecache/",
+		hash:        consistenthash.HashXXHash64,
+		vnodes:      50,
+		replication: replication,
+	}
+	for _, n := range nodes {
+		cfg.peers = append(cfg.peers, n.id())
+	}
+	return cfg
+}
+
+// execute 在 cfg 描述的集群上运行一个命令，返回命令的输出
+func execute(t *testing.T, cfg *config, cmd func(c *cluster, out *printer) error) (string, error) {
+	t.Helper()
+	c, err := newCluster(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = cmd(c, newPrinter(&buf, cfg.output))
+	return buf.String(), err
+}
+
+func decode[T any](t *testing.T, data string) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal([]byte(data), &v); err != nil {
+		t.Fatalf("decoding %q: %v", data, err)
+	}
+	return v
+}
+
+func TestSetGetDelete(t *testing.T) {
+	nodes := newTestCluster(t, 3, 1)
+	cfg := newTestConfig(nodes, 1, "")
+	c, err := newCluster(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const key = "Tom"
+	owner := c.holders(key)[0]
+
+	out, err := execute(t, cfg, func(c *cluster, out *printer) error { return cmdSet(c, out, key, "630") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := decode[map[string]any](t, out)
+	if set["node"] != string(owner) || set["size"] != float64(3) {
+		t.Fatalf("unexpected set output %s", out)
+	}
+	// 只写入 owner 的缓存
+	for _, n := range nodes {
+		if want := n.id() == owner; n.group.Contains(key) != want {
+			t.Fatalf("node %s holding %s: %v, want %v", n.id(), key, !want, want)
+		}
+	}
+
+	// 表格模式下 get 输出原始的值
+	cfg.output = "table"
+	out, err = execute(t, cfg, func(c *cluster, out *printer) error { return cmdGet(c, out, key) })
+	if err != nil || out != "630" {
+		t.Fatalf("get %s: %q, %v", key, out, err)
+	}
+	cfg.output = "json"
+	out, err = execute(t, cfg, func(c *cluster, out *printer) error { return cmdGet(c, out, key) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := decode[getResult](t, out); res.Group != "scores" || res.Key != key || res.Node != owner || res.Size != 3 || res.Value != "630" {
+		t.Fatalf("unexpected get output %s", out)
+	}
+
+	// 不是合法 UTF-8 的值输出为 base64
+	binary := "\xff\x00\xfe"
+	if _, err := execute(t, cfg, func(c *cluster, out *printer) error { return cmdSet(c, out, "bin", binary) }); err != nil {
+		t.Fatal(err)
+	}
+	out, err = execute(t, cfg, func(c *cluster, out *printer) error { return cmdGet(c, out, "bin") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := decode[getResult](t, out); res.Value != "" || string(res.ValueBase64) != binary || !strings.Contains(out, `"value_base64": "/wD+"`) {
+		t.Fatalf("unexpected get output %s", out)
+	}
+
+	// delete 从所有节点删除
+	out, err = execute(t, cfg, func(c *cluster, out *printer) error { return cmdDelete(c, out, key) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	del := decode[struct {
+		Key   string        `json:"key"`
+		Nodes []evictResult `json:"nodes"`
+	}](t, out)
+	if del.Key != key || len(del.Nodes) != len(nodes) {
+		t.Fatalf("unexpected delete output %s", out)
+	}
+	for _, res := range del.Nodes {
+		if res.Error != "" {
+			t.Fatalf("evicting from %s: %s", res.Node, res.Error)
+		}
+	}
+	for _, n := range nodes {
+		if n.group.Contains(key) {
+			t.Fatalf("node %s still holds %s after delete", n.id(), key)
+		}
+	}
+
+	// 节点不可用时 delete 报告失败的节点
+	nodes[2].ts.Close()
+	out, err = execute(t, cfg, func(c *cluster, out *printer) error { return cmdDelete(c, out, key) })
+	if err == nil || !strings.Contains(err.Error(), "failed on 1 of 3 nodes") {
+		t.Fatalf("expected one failed node, got %v", err)
+	}
+	if !strings.Contains(out, `"error"`) {
+		t.Fatalf("delete output should include the error: %s", out)
+	}
+}
+
+func TestOwner(t *testing.T) {
+	nodes := newTestCluster(t, 4, 2)
+	for _, token := range []string{"", testToken} {
+		// 有 token 时从节点同步可用区，副本的位置与 CacheServer 一致；没有 token 时至少 owner 一致
+		cfg := newTestConfig(nodes, 2, token)
+		for i := range 50 {
+			key := "key-" + strconv.Itoa(i)
+			out, err := execute(t, cfg, func(c *cluster, out *printer) error { return cmdOwner(c, out, key) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := decode[struct {
+				Owner   consistenthash.NodeID   `json:"owner"`
+				Holders []consistenthash.NodeID `json:"holders"`
+			}](t, out)
+			if len(res.Holders) != 2 || res.Holders[0] != res.Owner {
+				t.Fatalf("unexpected owner output %s", out)
+			}
+
+			ring := ringKey(t, nodes[0], key)
+			if res.Owner != ring.Owner {
+				t.Fatalf("owner of %s: geecachectl says %s, the node says %s", key, res.Owner, ring.Owner)
+			}
+			if token == "" {
+				continue
+			}
+			if !slices.Equal(res.Holders, ring.Holders) {
+				t.Fatalf("holders of %s: geecachectl says %v, the node says %v", key, res.Holders, ring.Holders)
+			}
+			// CacheServer 只在不是持有者时把请求转发给其他节点
+			for _, n := range nodes {
+				if holds := n.server.PickPeers(key) == nil; holds != slices.Contains(res.Holders, n.id()) {
+					t.Fatalf("node %s holding %s: %v, geecachectl says %v", n.id(), key, holds, res.Holders)
+				}
+			}
+		}
+	}
+
+	// 表格输出
+	cfg := newTestConfig(nodes, 2, testToken)
+	cfg.output = "table"
+	out, err := execute(t, cfg, func(c *cluster, out *printer) error { return cmdOwner(c, out, "Tom") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || strings.Join(strings.Fields(lines[0]), " ") != "KEY NODE ROLE ZONE RACK" ||
+		!strings.Contains(lines[1], "owner") || !strings.Contains(lines[2], "replica") || !strings.Contains(lines[1], "zone-") {
+		t.Fatalf("unexpected table output:\n%s", out)
+	}
+}
+
+// ringKey 通过运维接口询问节点 n 的哈希环上 key 的持有者
+func ringKey(t *testing.T, n *testNode, key string) (res struct {
+	Owner   consistenthash.NodeID   `json:"owner"`
+	Holders []consistenthash.NodeID `json:"holders"`
+}) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, n.ts.URL+"/admin/ring?key="+url.QueryEscape(key), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Key *struct {
+			Owner   consistenthash.NodeID   `json:"owner"`
+			Holders []consistenthash.NodeID `json:"holders"`
+		} `json:"key"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Key == nil {
+		t.Fatalf("GET /admin/ring?key=%s: %s, %v", key, resp.Status, err)
+	}
+	return *body.Key
+}
+
+func TestMembers(t *testing.T) {
+	nodes := newTestCluster(t, 3, 1)
+	cfg := newTestConfig(nodes, 1, testToken)
+	type members struct {
+		Members []memberInfo `json:"members"`
+	}
+
+	out, err := execute(t, cfg, cmdMembers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := decode[members](t, out)
+	if len(res.Members) != len(nodes) {
+		t.Fatalf("expected %d members, got %s", len(nodes), out)
+	}
+	var ownership float64
+	for _, m := range res.Members {
+		if !m.Reachable || len(m.SeenBy) != len(nodes) || !strings.HasPrefix(m.Zone, "zone-") {
+			t.Fatalf("unexpected member %+v", m)
+		}
+		ownership += m.Ownership
+	}
+	if ownership < 0.99 || ownership > 1.01 {
+		t.Fatalf("ownership should add up to 1, got %v", ownership)
+	}
+
+	// 只有一个节点知道的成员，以及无法访问的节点
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	nodes[0].server.AddPeers(consistenthash.NodeID(down.URL))
+	out, err = execute(t, cfg, cmdMembers)
+	if err == nil || !strings.Contains(err.Error(), "disagree") {
+		t.Fatalf("expected a membership disagreement, got %v", err)
+	}
+	res = decode[members](t, out)
+	if len(res.Members) != len(nodes)+1 {
+		t.Fatalf("expected %d members, got %s", len(nodes)+1, out)
+	}
+	for _, m := range res.Members {
+		if m.ID != consistenthash.NodeID(down.URL) {
+			continue
+		}
+		if m.Reachable || m.Error == "" || !slices.Equal(m.SeenBy, []consistenthash.NodeID{nodes[0].id()}) {
+			t.Fatalf("unexpected member %+v", m)
+		}
+	}
+
+	// 没有 token 时不能访问运维接口
+	cfg.token = ""
+	out, _ = execute(t, cfg, cmdMembers)
+	for _, m := range decode[members](t, out).Members {
+		if m.Reachable || !strings.Contains(m.Error, "needs a token") {
+			t.Fatalf("unexpected member without a token %+v", m)
+		}
+	}
+}
+
+func TestBench(t *testing.T) {
+	nodes := newTestCluster(t, 2, 1)
+	cfg := newTestConfig(nodes, 1, "")
+	for _, args := range [][]string{
+		{"-size", "-1"},
+		{"-n", "-1"},
+		{"-c", "0"},
+		{"-keys", "0"},
+		{"-writes", "2"},
+	} {
+		if _, err := execute(t, cfg, func(c *cluster, out *printer) error { return cmdBench(c, out, args) }); !errors.Is(err, errUsage) {
+			t.Fatalf("bench %v: expected errUsage, got %v", args, err)
+		}
+	}
+
+	out, err := execute(t, cfg, func(c *cluster, out *printer) error {
+		return cmdBench(c, out, []string{"-n", "50", "-c", "4", "-keys", "10", "-writes", "0.5", "-size", "0"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := decode[benchResult](t, out); res.Requests != 50 || res.Errors != 0 || res.Max < res.P50 {
+		t.Fatalf("unexpected bench output %s", out)
+	}
+}
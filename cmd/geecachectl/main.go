@@ -0,0 +1,140 @@
+// geecachectl 是 geecache 集群的命令行工具：读写 key、查看 key 的归属节点、集群成员和统计信息，以及压测集群。
+//
+//	geecachectl -peers http://localhost:8001,http://localhost:8002 get Tom
+//	geecachectl -peers ... set Tom 630
+//	echo -n 630 | geecachectl -peers ... set Tom -
+//	geecachectl -peers ... delete Tom
+//	geecachectl -peers ... owner Tom
+//	geecachectl -peers ... -token $GEECACHE_ADMIN_TOKEN members
+//	geecachectl -peers ... -token $GEECACHE_ADMIN_TOKEN stats
+//	geecachectl -peers ... bench -c 32 -d 10s
+//	geecachectl -peers https://10.0.0.2:8001 -ca ca.crt -cert ctl.crt -key ctl.key get Tom
+//
+// -o json 以 JSON 格式输出结果。members 和 stats 使用节点的运维接口（/admin/），需要 -token。
+// 集群开启 auth 时，-token 也可以是 auth.clients 中的 token，所有请求都会带上它：get 需要 read 权限，
+// set 和 delete 需要 write 权限，members 和 stats 需要 "*" 的 admin 权限。
+// 集群开启 mTLS 时用 -cert 和 -key 指定客户端证书，证书的身份需要在节点的 tls_allowed_identities 中。
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"geecache/consistenthash"
+	"os"
+	"strings"
+)
+
+const usage = `usage: geecachectl [flags] <command> [args]
+
+commands:
+  get <key>            read a key from its owner, falling back to the replicas
+  set <key> <value|->  write a key to its owner, - reads the value from stdin
+  delete <key>         evict a key from every node holding it
+  owner <key>          show the nodes holding a key
+  members              show the cluster membership as seen by each node
+  stats                show per-node, per-group statistics
+  bench [flags]        benchmark reads (and writes) against the cluster
+
+flags:
+`
+
+// config 是所有命令共用的参数
+type config struct {
+	peers       []consistenthash.NodeID
+	group       string
+	output      string
+	token       string
+	basePath    string
+	hash        string
+	vnodes      int
+	replication int
+	// 验证节点证书的 CA，以及开启 mTLS 时的客户端证书
+	caFile   string
+	certFile string
+	keyFile  string
+}
+
+func main() {
+	var cfg config
+	var peers string
+	flags := flag.NewFlagSet("geecachectl", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&peers, "peers", os.Getenv("GEECACHE_PEERS"), "Comma separated node URLs of the cluster, e.g. http://localhost:8001,http://localhost:8002")
+	flags.StringVar(&cfg.group, "group", "scores", "Group to operate on")
+	flags.StringVar(&cfg.output, "o", "table", "Output format: table or json")
+	flags.StringVar(&cfg.token, "token", os.Getenv("GEECACHE_ADMIN_TOKEN"), "Bearer token of the admin API or an auth client, needed by members and stats")
+	flags.StringVar(&cfg.basePath, "base-path", "/_geecache/", "Base path of the peer protocol")
+	// 以下三个参数必须与集群的配置相同，否则计算出的归属节点是错的
+	flags.StringVar(&cfg.hash, "hash", consistenthash.HashXXHash64, "Hash function of the ring, ring.hash of geecache-server (a CacheServer without WithHash uses crc32)")
+	flags.IntVar(&cfg.vnodes, "vnodes", 50, "Virtual nodes per node on the ring")
+	flags.IntVar(&cfg.replication, "replication", 1, "Number of nodes holding each key")
+	flags.StringVar(&cfg.caFile, "ca", "", "CA certificate used to verify the nodes, defaults to the system roots")
+	flags.StringVar(&cfg.certFile, "cert", "", "Client certificate for clusters with mutual TLS")
+	flags.StringVar(&cfg.keyFile, "key", "", "Private key of the client certificate")
+	flags.Parse(os.Args[1:])
+
+	for _, peer := range strings.Split(peers, ",") {
+		if peer = strings.TrimSpace(peer); peer != "" {
+			cfg.peers = append(cfg.peers, consistenthash.NodeID(peer))
+		}
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err := run(&cfg, flags.Arg(0), flags.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "geecachectl:", err)
+		os.Exit(1)
+	}
+}
+
+// errUsage 表示命令行参数有误
+var errUsage = errors.New("invalid arguments, see geecachectl -h")
+
+func run(cfg *config, cmd string, args []string) error {
+	if cfg.output != "table" && cfg.output != "json" {
+		return fmt.Errorf("unknown output format %q", cfg.output)
+	}
+	if len(cfg.peers) == 0 {
+		return errors.New("no peers given, use -peers or GEECACHE_PEERS")
+	}
+	c, err := newCluster(cfg)
+	if err != nil {
+		return err
+	}
+	out := newPrinter(os.Stdout, cfg.output)
+	switch cmd {
+	case "get":
+		if len(args) != 1 {
+			return errUsage
+		}
+		return cmdGet(c, out, args[0])
+	case "set":
+		if len(args) != 2 {
+			return errUsage
+		}
+		return cmdSet(c, out, args[0], args[1])
+	case "delete", "del":
+		if len(args) != 1 {
+			return errUsage
+		}
+		return cmdDelete(c, out, args[0])
+	case "owner":
+		if len(args) != 1 {
+			return errUsage
+		}
+		return cmdOwner(c, out, args[0])
+	case "members":
+		return cmdMembers(c, out)
+	case "stats":
+		return cmdStats(c, out)
+	case "bench":
+		return cmdBench(c, out, args)
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
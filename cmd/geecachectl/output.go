@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// printer 按 -o 指定的格式输出结果
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+func newPrinter(w io.Writer, format string) *printer {
+	return &printer{w: w, json: format == "json"}
+}
+
+// print 在 JSON 模式下输出 v，否则输出以 header 为表头的表格
+func (p *printer) print(v any, header []string, rows [][]string) error {
+	if p.json {
+		enc := json.NewEncoder(p.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	io.WriteString(tw, strings.Join(header, "\t")+"\n")
+	for _, row := range rows {
+		io.WriteString(tw, strings.Join(row, "\t")+"\n")
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,158 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"geecache/util"
+	"io"
+	"slices"
+	"sync"
+)
+
+// Compressor compresses values stored in the cache and sent between peers.
+// Name 同时也是 HTTP Content-Encoding 中使用的名称，节点间根据它协商是否直接传输压缩后的数据。
+// 可以实现这个接口并调用 Register 来接入 snappy、zstd 等第三方压缩算法。
+type Compressor interface {
+	Name() string
+	Compress(data []byte) ([]byte, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	// Gzip 使用默认压缩级别的 gzip
+	Gzip Compressor = NewGzip(gzip.DefaultCompression)
+	// Deflate 即 HTTP 中的 deflate 编码，也就是 zlib 格式（RFC 1950）
+	Deflate Compressor = NewDeflate(zlib.DefaultCompression)
+)
+
+var (
+	mtx         sync.RWMutex
+	compressors = map[string]Compressor{
+		Gzip.Name():    Gzip,
+		Deflate.Name(): Deflate,
+	}
+)
+
+// Register makes a compressor available by name. Registering a compressor
+// with the same name as an existing one replaces it.
+func Register(c Compressor) {
+	mtx.Lock()
+	defer mtx.Unlock()
+	compressors[c.Name()] = c
+}
+
+// Lookup returns the compressor registered with name.
+func Lookup(name string) (Compressor, bool) {
+	mtx.RLock()
+	defer mtx.RUnlock()
+	c, ok := compressors[name]
+	return c, ok
+}
+
+// Names returns the names of all registered compressors, sorted.
+func Names() []string {
+	mtx.RLock()
+	defer mtx.RUnlock()
+	names := make([]string, 0, len(compressors))
+	for name := range compressors {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// NewReader 返回一个读取 view 解压后内容的 io.ReadCloser
+func NewReader(view util.ByteView) (io.ReadCloser, error) {
+	r := view.Reader()
+	if view.Encoding == "" {
+		return io.NopCloser(r), nil
+	}
+	c, ok := Lookup(view.Encoding)
+	if !ok {
+		return nil, fmt.Errorf("compress: unknown encoding %q", view.Encoding)
+	}
+	return c.NewReader(r)
+}
+
+// Decode 返回 view 解压后的内容，没有压缩的 view 原样返回
+func Decode(view util.ByteView) (util.ByteView, error) {
+	if view.Encoding == "" {
+		return view, nil
+	}
+	rc, err := NewReader(view)
+	if err != nil {
+		return util.ByteView{}, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return util.ByteView{}, fmt.Errorf("compress: decoding %s value: %v", view.Encoding, err)
+	}
+	return util.NewByteView(data, ""), nil
+}
+
+type gzipCompressor struct {
+	level int
+}
+
+// NewGzip 返回使用指定压缩级别（见 compress/gzip）的 gzip 压缩器
+func NewGzip(level int) Compressor {
+	return gzipCompressor{level}
+}
+
+func (c gzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (c gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c gzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+type deflateCompressor struct {
+	level int
+}
+
+// NewDeflate 返回使用指定压缩级别（见 compress/zlib）的 deflate 压缩器
+func NewDeflate(level int) Compressor {
+	return deflateCompressor{level}
+}
+
+func (c deflateCompressor) Name() string {
+	return "deflate"
+}
+
+func (c deflateCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := zlib.NewWriterLevel(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c deflateCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
@@ -0,0 +1,48 @@
+package compress
+
+import (
+	"bytes"
+	"geecache/util"
+	"io"
+	"strings"
+	"testing"
+)
+
+type identity struct{}
+
+func (identity) Name() string                                 { return "identity-test" }
+func (identity) Compress(data []byte) ([]byte, error)         { return bytes.Clone(data), nil }
+func (identity) NewReader(r io.Reader) (io.ReadCloser, error) { return io.NopCloser(r), nil }
+
+func TestRoundTrip(t *testing.T) {
+	data := []byte(strings.Repeat(`{"name":"Tom","score":630},`, 100))
+	for _, c := range []Compressor{Gzip, Deflate, NewGzip(9)} {
+		compressed, err := c.Compress(data)
+		if err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		if len(compressed) >= len(data)/4 {
+			t.Errorf("%s: expected at least 4:1 compression, got %d -> %d", c.Name(), len(data), len(compressed))
+		}
+		view, err := Decode(util.NewByteView(compressed, c.Name()))
+		if err != nil || !view.EqualBytes(data) {
+			t.Fatalf("%s: round trip failed: %v", c.Name(), err)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	if _, ok := Lookup("identity-test"); ok {
+		t.Fatalf("unexpected compressor")
+	}
+	Register(identity{})
+	if _, ok := Lookup("identity-test"); !ok {
+		t.Fatalf("registered compressor not found")
+	}
+	if names := Names(); !strings.Contains(strings.Join(names, ","), "identity-test") {
+		t.Fatalf("Names() = %v", names)
+	}
+	if _, err := Decode(util.NewByteView([]byte("x"), "unknown")); err == nil {
+		t.Fatalf("expected error for unknown encoding")
+	}
+}
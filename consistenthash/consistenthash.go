@@ -1,7 +1,8 @@
 package consistenthash
 
 import (
-	"hash/crc32"
+	"cmp"
+	"math"
 	"slices"
 	"sort"
 	"strconv"
@@ -9,37 +10,72 @@ import (
 	"sync"
 )
 
-type Hash func(data []byte) uint32
-
 type NodeID string
 
+// Meta 描述真实节点所在的物理位置，用于把副本分散到不同的可用区/机架
+type Meta struct {
+	// 可用区
+	Zone string
+	// 机架
+	Rack string
+}
+
+// vnode 是哈希环上的一个虚拟节点
+type vnode struct {
+	hash uint64
+	node NodeID
+}
+
+// compareVNode 先按哈希值排序，哈希值相同时按真实节点名称排序，保证冲突时的顺序是确定的（与添加顺序无关）
+func compareVNode(a, b vnode) int {
+	if c := cmp.Compare(a.hash, b.hash); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.node, b.node)
+}
+
 // NodeMap contains all hashed keys
 type NodeMap struct {
 	sync.Mutex
-	// 哈希函数
+	// 哈希函数，默认是 CRC32（兼容旧版本的哈希环）
 	hasher Hash
 	// 虚拟节点倍数（一个真实节点对应replicas个虚拟节点）
 	replicas int
-	// 虚拟节点哈希环（有序）
-	ring []uint32
-	// 虚拟节点和真实节点的映射表 <虚拟节点哈希值, 真实节点名称>
-	nodeMap map[uint32]NodeID
+	// 虚拟节点哈希环（按 compareVNode 有序）。
+	// 不同虚拟节点的哈希值可能冲突，所以这里直接保存 <虚拟节点哈希值, 真实节点名称>，
+	// 而不是用 map[uint64]NodeID，否则冲突的虚拟节点会互相覆盖，DelNode 时还会误删其他节点的虚拟节点。
+	ring []vnode
+	// 已添加的真实节点
+	nodes map[NodeID]struct{}
+	// 真实节点的位置信息，可以在添加节点之前设置
+	meta map[NodeID]Meta
 }
 
 func New(replicas int, fn Hash) *NodeMap {
 	m := &NodeMap{
 		hasher:   fn,
 		replicas: replicas,
-		ring:     make([]uint32, 0),
-		nodeMap:  make(map[uint32]NodeID),
+		ring:     make([]vnode, 0),
+		nodes:    make(map[NodeID]struct{}),
+		meta:     make(map[NodeID]Meta),
 	}
 	if m.hasher == nil {
-		m.hasher = crc32.ChecksumIEEE
+		m.hasher = CRC32
 	}
 	return m
 }
 
-// AddNodes adds some nodes to the hash.
+// virtualHash 计算真实节点 node 的第 i 个虚拟节点的哈希值
+func (m *NodeMap) virtualHash(builder *strings.Builder, node NodeID, i int) uint64 {
+	builder.Reset()
+	builder.WriteString(string(node))
+	builder.WriteString("-")
+	builder.WriteString(strconv.Itoa(i))
+	return m.hasher([]byte(builder.String()))
+}
+
+// AddNodes adds some nodes to the hash. Adding a node that is already
+// present is a no-op.
 // NOTE 可能会造成部分key的哈希值变化，导致哈希值变化的这部分数据会缓存不命中。这需要分布式一致性算法来解决。
 func (m *NodeMap) AddNodes(nodes ...NodeID) {
 	if len(nodes) == 0 {
@@ -49,40 +85,97 @@ func (m *NodeMap) AddNodes(nodes ...NodeID) {
 	defer m.Unlock()
 	var builder strings.Builder
 	for _, node := range nodes {
+		if _, ok := m.nodes[node]; ok {
+			continue
+		}
+		m.nodes[node] = struct{}{}
 		for i := range m.replicas {
-			builder.WriteString(string(node))
-			builder.WriteString("-")
-			builder.WriteString(strconv.Itoa(i))
-			hash := m.hasher([]byte(builder.String()))
-			m.nodeMap[hash] = node
-			m.ring = append(m.ring, hash)
-			builder.Reset()
+			m.ring = append(m.ring, vnode{m.virtualHash(&builder, node, i), node})
 		}
 	}
-	slices.Sort(m.ring)
+	slices.SortFunc(m.ring, compareVNode)
+	// 同一个真实节点的两个虚拟节点也可能冲突，只保留一个，环上不出现完全相同的条目
+	m.ring = slices.CompactFunc(m.ring, func(a, b vnode) bool {
+		return compareVNode(a, b) == 0
+	})
 }
 
-// DelNode removes a node from the hash. It will remove all the virtual nodes of the node.
+// DelNode removes a node from the hash. It will remove all the virtual nodes
+// of the node and forget its location.
 func (m *NodeMap) DelNode(node NodeID) {
 	m.Lock()
 	defer m.Unlock()
-	virtualNodes := make(map[uint32]struct{}, m.replicas)
-	var builder strings.Builder
-	for i := range m.replicas {
-		builder.WriteString(string(node))
-		builder.WriteString("-")
-		builder.WriteString(strconv.Itoa(i))
-		hash := m.hasher([]byte(builder.String()))
-		delete(m.nodeMap, hash)
-		virtualNodes[hash] = struct{}{}
-	}
-	m.ring = slices.DeleteFunc(m.ring, func(i uint32) bool {
-		_, ok := virtualNodes[i]
-		return ok
+	if _, ok := m.nodes[node]; !ok {
+		return
+	}
+	delete(m.nodes, node)
+	// 节点重新加入时需要重新设置位置信息，否则被删除的节点的 meta 会一直留在 map 中
+	delete(m.meta, node)
+	// 按真实节点名称删除，哈希冲突时不会误删其他节点的虚拟节点
+	m.ring = slices.DeleteFunc(m.ring, func(v vnode) bool {
+		return v.node == node
 	})
 }
 
+// SetMeta records where node is located. It may be called before or after
+// the node is added, and affects the placement returned by GetNodes.
+func (m *NodeMap) SetMeta(node NodeID, meta Meta) {
+	m.Lock()
+	defer m.Unlock()
+	m.meta[node] = meta
+}
+
+// Meta returns the location of node, or the zero Meta if it is unknown.
+func (m *NodeMap) Meta(node NodeID) Meta {
+	m.Lock()
+	defer m.Unlock()
+	return m.meta[node]
+}
+
+// HasNode reports whether node has been added to the hash.
+func (m *NodeMap) HasNode(node NodeID) bool {
+	m.Lock()
+	defer m.Unlock()
+	_, ok := m.nodes[node]
+	return ok
+}
+
+// Nodes returns all the real nodes in the hash, sorted by name.
+func (m *NodeMap) Nodes() []NodeID {
+	m.Lock()
+	defer m.Unlock()
+	nodes := make([]NodeID, 0, len(m.nodes))
+	for node := range m.nodes {
+		nodes = append(nodes, node)
+	}
+	slices.Sort(nodes)
+	return nodes
+}
+
+// Ownership returns the fraction of the hash space owned by each node,
+// i.e. the expected share of keys routed to it by GetNode.
+func (m *NodeMap) Ownership() map[NodeID]float64 {
+	m.Lock()
+	defer m.Unlock()
+	shares := make(map[NodeID]float64, len(m.nodes))
+	if len(m.ring) == 0 {
+		return shares
+	}
+	prev := m.ring[len(m.ring)-1].hash
+	for _, v := range m.ring {
+		// 虚拟节点拥有它和前一个虚拟节点之间的弧，无符号减法自然处理了从最后一个虚拟节点绕回第一个虚拟节点的那一段弧
+		shares[v.node] += float64(v.hash-prev) / math.Pow(2, 64)
+		prev = v.hash
+	}
+	if len(m.ring) == 1 {
+		// 只有一个虚拟节点时上面的减法结果为 0，整个环都属于它
+		shares[m.ring[0].node] = 1
+	}
+	return shares
+}
+
 // Get gets the closest node in the hash to the provided key.
+// 多个虚拟节点哈希值相同时，选择名称最小的真实节点。
 func (m *NodeMap) GetNode(key string) (node NodeID) {
 	m.Lock()
 	defer m.Unlock()
@@ -93,14 +186,82 @@ func (m *NodeMap) GetNode(key string) (node NodeID) {
 	hash := m.hasher([]byte(key))
 	// 找到环上最近的虚拟节点
 	idx := sort.Search(len(m.ring), func(i int) bool {
-		return m.ring[i] >= hash
+		return m.ring[i].hash >= hash
 	})
 	// 如果 idx == len(m.keys)，说明应选择 m.keys[0]，因为 m.keys 是一个环状结构，所以用取余数的方式来处理这种情况。
 	// 比较大的值会全塞到第一个节点，此时应该增大replicas的值
-	node = m.nodeMap[m.ring[idx%len(m.ring)]]
+	node = m.ring[idx%len(m.ring)].node
 	return
 }
 
+// GetNodes returns up to n distinct nodes for key, walking the ring clockwise
+// from the key's position. The first node is the one GetNode returns.
+// 用于多副本：key 同时保存在环上顺时针方向的前 n 个不同的真实节点上。
+// 如果设置了节点的位置信息，会优先选择不同可用区的节点，其次是不同机架的节点，一个可用区/机架故障时不会丢失 key 的所有副本。
+func (m *NodeMap) GetNodes(key string, n int) []NodeID {
+	m.Lock()
+	defer m.Unlock()
+	if len(m.ring) == 0 || n <= 0 {
+		return nil
+	}
+	n = min(n, len(m.nodes))
+
+	// 集群中一共有多少个不同的可用区和机架
+	zones, racks := make(map[string]struct{}), make(map[Meta]struct{})
+	for node := range m.nodes {
+		zones[m.meta[node].Zone] = struct{}{}
+		racks[m.meta[node]] = struct{}{}
+	}
+
+	hash := m.hasher([]byte(key))
+	idx := sort.Search(len(m.ring), func(i int) bool {
+		return m.ring[i].hash >= hash
+	})
+	// 沿着环收集候选节点，直到候选节点覆盖了足够多的可用区和机架
+	candidates := make([]NodeID, 0, n)
+	seenZones, seenRacks := make(map[string]struct{}), make(map[Meta]struct{})
+	for i := 0; i < len(m.ring); i++ {
+		if len(candidates) == len(m.nodes) ||
+			len(seenZones) >= min(n, len(zones)) && len(seenRacks) >= min(n, len(racks)) && len(candidates) >= n {
+			break
+		}
+		node := m.ring[(idx+i)%len(m.ring)].node
+		if slices.Contains(candidates, node) {
+			continue
+		}
+		candidates = append(candidates, node)
+		seenZones[m.meta[node].Zone] = struct{}{}
+		seenRacks[m.meta[node]] = struct{}{}
+	}
+
+	// 先每个可用区选一个节点，再每个机架选一个节点，最后按环上的顺序补齐
+	nodes := make([]NodeID, 0, n)
+	usedZones, usedRacks := make(map[string]struct{}), make(map[Meta]struct{})
+	pick := func(accept func(node NodeID) bool) {
+		for _, node := range candidates {
+			if len(nodes) == n {
+				return
+			}
+			if slices.Contains(nodes, node) || !accept(node) {
+				continue
+			}
+			nodes = append(nodes, node)
+			usedZones[m.meta[node].Zone] = struct{}{}
+			usedRacks[m.meta[node]] = struct{}{}
+		}
+	}
+	pick(func(node NodeID) bool {
+		_, used := usedZones[m.meta[node].Zone]
+		return !used
+	})
+	pick(func(node NodeID) bool {
+		_, used := usedRacks[m.meta[node]]
+		return !used
+	})
+	pick(func(NodeID) bool { return true })
+	return nodes
+}
+
 /*
 采用写时复制的版本：这里只有修改values即Add或者Remove的时候需要加锁，但是读取values如Get是不需要加锁的，保证了只会有一个并发单位正在修改。同时修改的过程并不是直接对values进行修改，而是复制了一份副本，修改完成后再原子store。同样的，Get是原子拷贝了values的副本，可以有多个并发单位操作自己看到的副本，但是这样会带来一定的数据一致性问题（比如某时刻有并发单位执行了remove或者add操作，修改了values，但是其它get操作不知道，拿到的是过时的副本）。这样的好处就是提高了并发量，同一时刻只能有一个并发单位执行修改操作，但是可以有多个单位执行读操作。为了保证一致性问题，最简单的操作就是不用写时复制，改成读写锁，但是这样并发性能会有所损失，更高级的可能就是采用一些一致性算法了吧。
 
@@ -1,20 +1,25 @@
 package consistenthash
 
 import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
 	"strconv"
 	"strings"
 	"testing"
+	"testing/quick"
 )
 
 func TestHashing(t *testing.T) {
-	hash := New(3, func(key []byte) uint32 {
+	hash := New(3, func(key []byte) uint64 {
 		nums := strings.SplitN(string(key), "-", 2)
 		var code = 0
 		for _, num := range nums {
 			i, _ := strconv.Atoi(num)
 			code = code*10 + i
 		}
-		return uint32(code)
+		return uint64(code)
 	})
 
 	// Given the above hash function, this will give replicas with "hashes":
@@ -74,3 +79,291 @@ func TestConsistency(t *testing.T) {
 		t.Errorf("Direct matches should always return the same entry")
 	}
 }
+
+// collidingHash 把哈希值压缩到很小的空间里，人为制造大量虚拟节点冲突
+func collidingHash(data []byte) uint64 {
+	return FNV1a(data) % 64
+}
+
+// checkRing 校验哈希环的不变式
+func checkRing(t *testing.T, m *NodeMap, want map[NodeID]struct{}) {
+	t.Helper()
+	if !slices.IsSortedFunc(m.ring, compareVNode) {
+		t.Fatalf("ring is not sorted")
+	}
+	for i := 1; i < len(m.ring); i++ {
+		if compareVNode(m.ring[i-1], m.ring[i]) == 0 {
+			t.Fatalf("duplicate ring entry %v", m.ring[i])
+		}
+	}
+	if len(m.nodes) != len(want) {
+		t.Fatalf("expected %d nodes, got %d", len(want), len(m.nodes))
+	}
+	// 每个真实节点在环上的条目恰好是它所有（去重后的）虚拟节点
+	counts := make(map[NodeID]int)
+	for _, v := range m.ring {
+		if _, ok := want[v.node]; !ok {
+			t.Fatalf("ring contains removed node %s", v.node)
+		}
+		counts[v.node]++
+	}
+	var builder strings.Builder
+	for node := range want {
+		hashes := make(map[uint64]struct{})
+		for i := range m.replicas {
+			hashes[m.virtualHash(&builder, node, i)] = struct{}{}
+		}
+		if counts[node] != len(hashes) {
+			t.Fatalf("node %s expected %d virtual nodes, got %d", node, len(hashes), counts[node])
+		}
+	}
+}
+
+func TestCollision(t *testing.T) {
+	hash := New(3, func(key []byte) uint64 { return 0 })
+	hash.AddNodes("b", "a")
+	hash.AddNodes("c")
+	// 所有虚拟节点都冲突，名称最小的节点胜出
+	if node := hash.GetNode("anything"); node != "a" {
+		t.Fatalf("expected a, got %s", node)
+	}
+	hash.DelNode("a")
+	if node := hash.GetNode("anything"); node != "b" {
+		t.Fatalf("expected b, got %s", node)
+	}
+	if len(hash.ring) != 2 {
+		t.Fatalf("expected 2 ring entries, got %d", len(hash.ring))
+	}
+}
+
+func TestAddNodesIdempotent(t *testing.T) {
+	hash := New(10, nil)
+	hash.AddNodes("a", "b")
+	before := slices.Clone(hash.ring)
+	hash.AddNodes("a", "b", "a")
+	if !slices.Equal(before, hash.ring) {
+		t.Fatalf("re-adding existing nodes changed the ring")
+	}
+	hash.DelNode("unknown")
+	if !slices.Equal(before, hash.ring) {
+		t.Fatalf("deleting unknown node changed the ring")
+	}
+}
+
+// TestRingProperties 随机添加/删除节点，校验哈希环的不变式，并且和按最终节点集合重新构建的环比较，结果必须与操作顺序无关
+func TestRingProperties(t *testing.T) {
+	for _, fn := range []Hash{nil, collidingHash} {
+		property := func(seed int64) bool {
+			rnd := rand.New(rand.NewSource(seed))
+			hash := New(1+rnd.Intn(8), fn)
+			live := make(map[NodeID]struct{})
+			for range 50 {
+				node := NodeID(fmt.Sprintf("node%d", rnd.Intn(12)))
+				if rnd.Intn(3) == 0 {
+					hash.DelNode(node)
+					delete(live, node)
+				} else {
+					hash.AddNodes(node)
+					live[node] = struct{}{}
+				}
+				checkRing(t, hash, live)
+			}
+
+			nodes := make([]NodeID, 0, len(live))
+			for node := range live {
+				nodes = append(nodes, node)
+			}
+			rnd.Shuffle(len(nodes), func(i, j int) { nodes[i], nodes[j] = nodes[j], nodes[i] })
+			fresh := New(hash.replicas, fn)
+			fresh.AddNodes(nodes...)
+			if !slices.Equal(hash.ring, fresh.ring) {
+				return false
+			}
+			for i := range 200 {
+				key := strconv.Itoa(i)
+				if hash.GetNode(key) != fresh.GetNode(key) {
+					return false
+				}
+			}
+			return true
+		}
+		if err := quick.Check(property, nil); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	for _, fn := range []Hash{nil, collidingHash} {
+		old := New(20, fn)
+		old.AddNodes("a", "b", "c")
+		next := old.Clone()
+		next.AddNodes("d")
+		next.DelNode("b")
+
+		plan := old.Diff(next)
+		if len(plan.Migrations) == 0 {
+			t.Fatalf("expected some migrations")
+		}
+		for i := range 5000 {
+			key := strconv.Itoa(i)
+			from, to, moved := plan.Move(key)
+			wantFrom, wantTo := old.GetNode(key), next.GetNode(key)
+			if moved != (wantFrom != wantTo) {
+				t.Fatalf("key %s: moved = %v, old owner %s, new owner %s", key, moved, wantFrom, wantTo)
+			}
+			if moved && (from != wantFrom || to != wantTo) {
+				t.Fatalf("key %s: plan says %s -> %s, want %s -> %s", key, from, to, wantFrom, wantTo)
+			}
+		}
+
+		if plan := next.Diff(next.Clone()); len(plan.Migrations) != 0 {
+			t.Fatalf("diff of identical rings should be empty, got %v", plan.Migrations)
+		}
+	}
+}
+
+func TestGetNodes(t *testing.T) {
+	hash := New(20, nil)
+	hash.AddNodes("a", "b", "c", "d")
+	for i := range 1000 {
+		key := strconv.Itoa(i)
+		nodes := hash.GetNodes(key, 3)
+		if len(nodes) != 3 {
+			t.Fatalf("expected 3 nodes, got %v", nodes)
+		}
+		if nodes[0] != hash.GetNode(key) {
+			t.Fatalf("first replica %s is not the owner %s", nodes[0], hash.GetNode(key))
+		}
+		sorted := slices.Clone(nodes)
+		slices.Sort(sorted)
+		if len(slices.Compact(sorted)) != 3 {
+			t.Fatalf("replicas are not distinct: %v", nodes)
+		}
+		// 删除 owner 之后，原来的第二个副本成为新的 owner
+		next := hash.Clone()
+		next.DelNode(nodes[0])
+		if next.GetNode(key) != nodes[1] {
+			t.Fatalf("expected %s to take over key %s, got %s", nodes[1], key, next.GetNode(key))
+		}
+	}
+	if nodes := hash.GetNodes("x", 10); len(nodes) != 4 {
+		t.Fatalf("expected all 4 nodes, got %v", nodes)
+	}
+}
+
+func TestZoneAwarePlacement(t *testing.T) {
+	hash := New(20, nil)
+	for _, zone := range []string{"us-east-1a", "us-east-1b", "us-east-1c"} {
+		for _, rack := range []string{"r1", "r2"} {
+			for i := range 2 {
+				node := NodeID(fmt.Sprintf("%s-%s-%d", zone, rack, i))
+				hash.SetMeta(node, Meta{Zone: zone, Rack: rack})
+				hash.AddNodes(node)
+			}
+		}
+	}
+	for i := range 1000 {
+		key := strconv.Itoa(i)
+		nodes := hash.GetNodes(key, 5)
+		if nodes[0] != hash.GetNode(key) {
+			t.Fatalf("first replica %s is not the owner %s", nodes[0], hash.GetNode(key))
+		}
+		zones, racks := make(map[string]bool), make(map[Meta]bool)
+		for _, node := range nodes {
+			zones[hash.Meta(node).Zone] = true
+			racks[hash.Meta(node)] = true
+		}
+		// 5 个副本分布在全部 3 个可用区、5 个不同的机架上
+		if len(zones) != 3 || len(racks) != 5 {
+			t.Fatalf("key %s: replicas %v span %d zones and %d racks", key, nodes, len(zones), len(racks))
+		}
+	}
+
+	// 没有位置信息时，按环上的顺序选择
+	plain := New(20, nil)
+	plain.AddNodes(hash.Nodes()...)
+	for i := range 100 {
+		key := strconv.Itoa(i)
+		nodes := plain.GetNodes(key, 3)
+		if len(nodes) != 3 || nodes[0] != plain.GetNode(key) {
+			t.Fatalf("unexpected replicas %v", nodes)
+		}
+	}
+}
+
+func TestDelNodeMeta(t *testing.T) {
+	hash := New(20, nil)
+	for i := range 10 {
+		node := NodeID(strconv.Itoa(i))
+		hash.SetMeta(node, Meta{Zone: "zone-" + strconv.Itoa(i%3), Rack: "r1"})
+		hash.AddNodes(node)
+	}
+	for i := range 10 {
+		hash.DelNode(NodeID(strconv.Itoa(i)))
+	}
+	if len(hash.meta) != 0 {
+		t.Fatalf("DelNode should remove the location of the node, %d left", len(hash.meta))
+	}
+
+	// 重新加入且没有设置位置信息的节点没有位置
+	hash.AddNodes("1")
+	if meta := hash.Meta("1"); meta != (Meta{}) {
+		t.Fatalf("re-added node kept the old location %+v", meta)
+	}
+	// 删除不存在的节点不影响预先设置的位置信息
+	hash.SetMeta("pending", Meta{Zone: "a"})
+	hash.DelNode("pending")
+	if hash.Meta("pending").Zone != "a" {
+		t.Fatal("DelNode of an unknown node should keep its location")
+	}
+}
+
+func TestOwnership(t *testing.T) {
+	m := New(50, XXHash64)
+	if len(m.Ownership()) != 0 {
+		t.Fatalf("empty ring should have no owners")
+	}
+	m.AddNodes("a")
+	if share := m.Ownership()["a"]; math.Abs(share-1) > 1e-9 {
+		t.Fatalf("single node should own the whole ring, got %v", share)
+	}
+	m.AddNodes("b", "c")
+	var total float64
+	for _, share := range m.Ownership() {
+		total += share
+	}
+	if math.Abs(total-1) > 1e-9 {
+		t.Fatalf("shares should sum to 1, got %v", total)
+	}
+	one := New(1, XXHash64)
+	one.AddNodes("a")
+	if share := one.Ownership()["a"]; share != 1 {
+		t.Fatalf("single virtual node should own the whole ring, got %v", share)
+	}
+
+	// 每个哈希函数（包括只有 32 位的 CRC32）的比例都按整个环计算，与 GetNode 实际分配的 key 的比例一致
+	hashes := map[string]Hash{"default": nil}
+	for _, name := range []string{HashCRC32, HashFNV1a, HashXXHash64, HashMurmur3} {
+		hashes[name], _ = HashByName(name)
+	}
+	const numNodes, numKeys = 3, 30000
+	for name, fn := range hashes {
+		m := New(500, fn)
+		for i := range numNodes {
+			m.AddNodes(NodeID(fmt.Sprintf("http://10.0.0.%d:8001", i+1)))
+		}
+		routed := make(map[NodeID]int)
+		for i := range numKeys {
+			routed[m.GetNode("key-"+strconv.Itoa(i))]++
+		}
+		for node, share := range m.Ownership() {
+			if math.Abs(share*numNodes-1) > 0.15 {
+				t.Errorf("%s: %s owns %v of the ring, want about 1/%d", name, node, share, numNodes)
+			}
+			if got := float64(routed[node]) / numKeys; math.Abs(got-share) > 0.03 {
+				t.Errorf("%s: %s owns %v of the ring but receives %v of the keys", name, node, share, got)
+			}
+		}
+	}
+}
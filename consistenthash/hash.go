@@ -0,0 +1,198 @@
+package consistenthash
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math/bits"
+)
+
+// Hash maps bytes to a position on the 64-bit ring.
+// 哈希值应该分布在整个 uint64 范围内，Ownership 按 2^64 计算每个节点拥有的比例；
+// 只产生 32 位哈希值的函数需要把结果放到高位（见 CRC32），否则几乎整个环都属于一个节点。
+type Hash func(data []byte) uint64
+
+// 内置的哈希函数名称，可以在配置文件中用名称选择哈希函数
+const (
+	HashCRC32    = "crc32"
+	HashFNV1a    = "fnv1a"
+	HashXXHash64 = "xxhash64"
+	HashMurmur3  = "murmur3"
+)
+
+var hashes = map[string]Hash{
+	HashCRC32:    CRC32,
+	HashFNV1a:    FNV1a,
+	HashXXHash64: XXHash64,
+	HashMurmur3:  Murmur3,
+}
+
+// HashByName returns the built-in hash function with the given name.
+func HashByName(name string) (Hash, error) {
+	if fn, ok := hashes[name]; ok {
+		return fn, nil
+	}
+	return nil, fmt.Errorf("consistenthash: unknown hash function %q", name)
+}
+
+// CRC32 是 New 的默认哈希函数（为了兼容旧版本的哈希环），但短 key 的分布比较差，新集群应该选择其他哈希函数。
+// 32 位的校验和放在高 32 位，分散到整个 64 位的哈希环上；左移不改变哈希值之间的顺序，key 的归属与旧版本相同。
+func CRC32(data []byte) uint64 {
+	return uint64(crc32.ChecksumIEEE(data)) << 32
+}
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// FNV1a 计算 64 位 FNV-1a（与 hash/fnv.New64a 相同，但不需要分配内存），再经过 Murmur3 的 fmix64 混淆。
+// 原始的 FNV-1a 对只有最后几个字节不同的短 key（如 "key1"、"key2"）高位几乎不变，直接放到哈希环上会严重倾斜。
+func FNV1a(data []byte) uint64 {
+	h := uint64(fnvOffset64)
+	for _, c := range data {
+		h ^= uint64(c)
+		h *= fnvPrime64
+	}
+	return murmurFmix(h)
+}
+
+// 用变量而不是常量，避免 v1、v4 初始化时的常量溢出编译错误
+var (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	val = xxRound(0, val)
+	acc ^= val
+	return acc*xxPrime1 + xxPrime4
+}
+
+// XXHash64 实现 seed 为 0 的 xxHash64，速度快且分布均匀
+func XXHash64(data []byte) uint64 {
+	n := len(data)
+	var h uint64
+	if n >= 32 {
+		v1 := xxPrime1 + xxPrime2
+		v2 := xxPrime2
+		v3 := uint64(0)
+		v4 := -xxPrime1
+		for len(data) >= 32 {
+			v1 = xxRound(v1, binary.LittleEndian.Uint64(data[0:8]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint64(data[8:16]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint64(data[16:24]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint64(data[24:32]))
+			data = data[32:]
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMergeRound(h, v1)
+		h = xxMergeRound(h, v2)
+		h = xxMergeRound(h, v3)
+		h = xxMergeRound(h, v4)
+	} else {
+		h = xxPrime5
+	}
+	h += uint64(n)
+
+	for ; len(data) >= 8; data = data[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(data[:8]))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(data) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(data[:4])) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		data = data[4:]
+	}
+	for _, c := range data {
+		h ^= uint64(c) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+const (
+	murmurC1 uint64 = 0x87c37b91114253d5
+	murmurC2 uint64 = 0x4cf5ad432745937f
+)
+
+func murmurFmix(k uint64) uint64 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
+}
+
+// Murmur3 实现 seed 为 0 的 MurmurHash3 x64_128，返回 128 位结果的前 64 位
+func Murmur3(data []byte) uint64 {
+	n := len(data)
+	var h1, h2 uint64
+
+	for ; len(data) >= 16; data = data[16:] {
+		k1 := binary.LittleEndian.Uint64(data[0:8])
+		k2 := binary.LittleEndian.Uint64(data[8:16])
+
+		k1 *= murmurC1
+		k1 = bits.RotateLeft64(k1, 31)
+		k1 *= murmurC2
+		h1 ^= k1
+		h1 = bits.RotateLeft64(h1, 27)
+		h1 += h2
+		h1 = h1*5 + 0x52dce729
+
+		k2 *= murmurC2
+		k2 = bits.RotateLeft64(k2, 33)
+		k2 *= murmurC1
+		h2 ^= k2
+		h2 = bits.RotateLeft64(h2, 31)
+		h2 += h1
+		h2 = h2*5 + 0x38495ab5
+	}
+
+	// 处理剩余不足 16 字节的尾部
+	var k1, k2 uint64
+	for i := len(data) - 1; i >= 8; i-- {
+		k2 ^= uint64(data[i]) << (8 * (i - 8))
+	}
+	if len(data) > 8 {
+		k2 *= murmurC2
+		k2 = bits.RotateLeft64(k2, 33)
+		k2 *= murmurC1
+		h2 ^= k2
+	}
+	for i := min(len(data), 8) - 1; i >= 0; i-- {
+		k1 ^= uint64(data[i]) << (8 * i)
+	}
+	if len(data) > 0 {
+		k1 *= murmurC1
+		k1 = bits.RotateLeft64(k1, 31)
+		k1 *= murmurC2
+		h1 ^= k1
+	}
+
+	h1 ^= uint64(n)
+	h2 ^= uint64(n)
+	h1 += h2
+	h2 += h1
+	h1 = murmurFmix(h1)
+	h2 = murmurFmix(h2)
+	h1 += h2
+	return h1
+}
@@ -0,0 +1,134 @@
+package consistenthash
+
+import (
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"slices"
+	"testing"
+)
+
+func TestHashVectors(t *testing.T) {
+	testCases := []struct {
+		fn   Hash
+		in   string
+		want uint64
+	}{
+		{XXHash64, "", 0xef46db3751d8e999},
+		{XXHash64, "a", 0xd24ec4f1a98c6e5b},
+		{XXHash64, "abc", 0x44bc2cf5ad770999},
+		{XXHash64, "Nobody inspects the spammish repetition", 0xfbcea83c8a378bf1},
+		{Murmur3, "", 0},
+		{Murmur3, "hello", 0xcbd8a7b341bd9b02},
+		{Murmur3, "The quick brown fox jumps over the lazy dog", 0xe34bbc7bbc071b6c},
+	}
+	for _, tc := range testCases {
+		if got := tc.fn([]byte(tc.in)); got != tc.want {
+			t.Errorf("hash(%q) = %#x, want %#x", tc.in, got, tc.want)
+		}
+	}
+
+	for _, in := range []string{"", "a", "Tom", "http://localhost:8001-42"} {
+		h := fnv.New64a()
+		h.Write([]byte(in))
+		if got, want := FNV1a([]byte(in)), murmurFmix(h.Sum64()); got != want {
+			t.Errorf("FNV1a(%q) = %#x, want %#x", in, got, want)
+		}
+	}
+}
+
+// CRC32 把校验和放在高位之后，key 的归属与直接使用 32 位校验和的旧哈希环相同
+func TestCRC32Compatible(t *testing.T) {
+	legacy := New(50, func(data []byte) uint64 { return uint64(crc32.ChecksumIEEE(data)) })
+	ring := New(50, nil)
+	for i := range 5 {
+		node := NodeID(fmt.Sprintf("http://10.0.0.%d:8001", i+1))
+		legacy.AddNodes(node)
+		ring.AddNodes(node)
+	}
+	for i := range 1000 {
+		key := fmt.Sprintf("key-%d", i)
+		if got, want := ring.GetNodes(key, 3), legacy.GetNodes(key, 3); !slices.Equal(got, want) {
+			t.Fatalf("holders of %s: %v, the 32-bit ring says %v", key, got, want)
+		}
+	}
+}
+
+func TestHashByName(t *testing.T) {
+	for _, name := range []string{HashCRC32, HashFNV1a, HashXXHash64, HashMurmur3} {
+		if _, err := HashByName(name); err != nil {
+			t.Errorf("HashByName(%q): %v", name, err)
+		}
+	}
+	if _, err := HashByName("md5"); err == nil {
+		t.Errorf("expected error for unknown hash")
+	}
+}
+
+// chiSquare 计算观测值与期望值之间的卡方统计量
+func chiSquare(observed []int, expected []float64) float64 {
+	var x2 float64
+	for i := range observed {
+		d := float64(observed[i]) - expected[i]
+		x2 += d * d / expected[i]
+	}
+	return x2
+}
+
+func TestDistribution(t *testing.T) {
+	const (
+		numNodes = 10
+		numKeys  = 100000
+		// 自由度为 9 时 p=0.001 的卡方临界值
+		nodeCritical = 27.877
+		// 自由度为 63 时 p=0.001 的卡方临界值
+		bucketCritical = 103.442
+	)
+	for _, name := range []string{HashFNV1a, HashXXHash64, HashMurmur3} {
+		fn, _ := HashByName(name)
+		t.Run(name, func(t *testing.T) {
+			// 短 key 的哈希值高 6 位应该均匀分布
+			buckets := make([]int, 64)
+			for i := range numKeys {
+				buckets[fn([]byte(fmt.Sprintf("key%d", i)))>>58]++
+			}
+			expected := make([]float64, 64)
+			for i := range expected {
+				expected[i] = numKeys / 64.0
+			}
+			if x2 := chiSquare(buckets, expected); x2 > bucketCritical {
+				t.Errorf("bucket chi-square %.2f exceeds %.2f", x2, bucketCritical)
+			}
+
+			m := New(200, fn)
+			nodes := make([]NodeID, numNodes)
+			for i := range nodes {
+				nodes[i] = NodeID(fmt.Sprintf("http://10.0.0.%d:8001", i+1))
+			}
+			m.AddNodes(nodes...)
+
+			// 每个节点承担的弧长不能偏离平均值太多
+			shares := m.Ownership()
+			for _, node := range nodes {
+				if share := shares[node] * numNodes; share < 0.7 || share > 1.3 {
+					t.Errorf("node %s owns %.2fx its fair share of the ring", node, share)
+				}
+			}
+
+			// 在给定的哈希环上，key 落到各个节点的数量应该与弧长成正比
+			counts := make(map[NodeID]int)
+			for i := range numKeys {
+				counts[m.GetNode(fmt.Sprintf("user:%d", i))]++
+			}
+			observed := make([]int, numNodes)
+			expected = make([]float64, numNodes)
+			for i, node := range nodes {
+				observed[i] = counts[node]
+				expected[i] = shares[node] * numKeys
+			}
+			if x2 := chiSquare(observed, expected); x2 > nodeCritical {
+				t.Errorf("node share chi-square %.2f exceeds %.2f", x2, nodeCritical)
+			}
+		})
+	}
+}
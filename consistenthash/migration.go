@@ -0,0 +1,113 @@
+package consistenthash
+
+import (
+	"slices"
+	"sort"
+)
+
+// Migration 表示哈希值落在 [Start, End] 区间内的 key 的归属节点从 From 变成了 To
+type Migration struct {
+	Start, End uint64
+	From, To   NodeID
+}
+
+// MigrationPlan 描述两个哈希环之间所有归属发生变化的区间
+type MigrationPlan struct {
+	hasher Hash
+	// 按 Start 升序排列，区间之间互不重叠
+	Migrations []Migration
+}
+
+// Move reports which node owned key before and after the ring change.
+// moved is false if the owner did not change.
+func (p *MigrationPlan) Move(key string) (from, to NodeID, moved bool) {
+	hash := p.hasher([]byte(key))
+	idx := sort.Search(len(p.Migrations), func(i int) bool {
+		return p.Migrations[i].End >= hash
+	})
+	if idx < len(p.Migrations) && p.Migrations[idx].Start <= hash {
+		mg := p.Migrations[idx]
+		return mg.From, mg.To, true
+	}
+	return
+}
+
+// Clone returns a copy of the hash that is not affected by later changes to m.
+func (m *NodeMap) Clone() *NodeMap {
+	m.Lock()
+	defer m.Unlock()
+	c := &NodeMap{
+		hasher:   m.hasher,
+		replicas: m.replicas,
+		ring:     slices.Clone(m.ring),
+		nodes:    make(map[NodeID]struct{}, len(m.nodes)),
+		meta:     make(map[NodeID]Meta, len(m.meta)),
+	}
+	for node := range m.nodes {
+		c.nodes[node] = struct{}{}
+	}
+	for node, meta := range m.meta {
+		c.meta[node] = meta
+	}
+	return c
+}
+
+func (m *NodeMap) snapshot() []vnode {
+	m.Lock()
+	defer m.Unlock()
+	return slices.Clone(m.ring)
+}
+
+// owner 返回哈希值 hash 在环 ring 上的归属节点，与 GetNode 的规则相同
+func owner(ring []vnode, hash uint64) NodeID {
+	if len(ring) == 0 {
+		return ""
+	}
+	idx := sort.Search(len(ring), func(i int) bool {
+		return ring[i].hash >= hash
+	})
+	return ring[idx%len(ring)].node
+}
+
+// Diff computes which parts of the key space change owner when moving from
+// ring m to ring next. Both rings must use the same hash function.
+// 两个环上所有虚拟节点的哈希值把整个哈希空间切成若干区间，同一个区间内的 key 在两个环上的归属节点都不变，所以只需要逐个区间比较。
+func (m *NodeMap) Diff(next *NodeMap) *MigrationPlan {
+	oldRing, newRing := m.snapshot(), next.snapshot()
+	bounds := make([]uint64, 0, len(oldRing)+len(newRing))
+	for _, v := range oldRing {
+		bounds = append(bounds, v.hash)
+	}
+	for _, v := range newRing {
+		bounds = append(bounds, v.hash)
+	}
+	slices.Sort(bounds)
+	bounds = slices.Compact(bounds)
+
+	plan := &MigrationPlan{hasher: next.hasher}
+	add := func(start, end uint64) {
+		from, to := owner(oldRing, end), owner(newRing, end)
+		if from == to {
+			return
+		}
+		// 和上一个区间相邻且迁移方向相同，则合并
+		if n := len(plan.Migrations); n > 0 {
+			last := &plan.Migrations[n-1]
+			if last.End+1 == start && last.From == from && last.To == to {
+				last.End = end
+				return
+			}
+		}
+		plan.Migrations = append(plan.Migrations, Migration{start, end, from, to})
+	}
+	var start uint64
+	for _, b := range bounds {
+		add(start, b)
+		start = b + 1
+	}
+	// 最后一个虚拟节点之后的区间绕回到环的开头
+	if len(bounds) == 0 || bounds[len(bounds)-1] != ^uint64(0) {
+		add(start, ^uint64(0))
+	}
+	return plan
+}
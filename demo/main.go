@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"geecache"
@@ -8,6 +10,12 @@ import (
 	"geecache/network"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
 )
 
 var mockDB = map[string]string{
@@ -23,41 +31,77 @@ func createGroup() *geecache.Group {
 			if v, ok := mockDB[key]; ok {
 				return []byte(v), nil
 			}
-			return nil, fmt.Errorf("%s does not exist", key)
+			return nil, fmt.Errorf("%s: %w", key, geecache.ErrNotFound)
 		}))
 }
 
 // 来启动缓存服务器：创建 HTTPPool，添加节点信息，注册到 group 中，启动 HTTP 服务（共3个端口，8001/8002/8003），用户不感知。
-func startCacheServer(addr string, addrs []consistenthash.NodeID, gee *geecache.Group) {
-	cacheServer := network.NewCacheServer(addr)
+// adminToken 不为空时在 /admin/ 上提供运维接口。退出时依次调用 CacheServer 和 http.Server 的 Shutdown。
+func startCacheServer(addr string, addrs []consistenthash.NodeID, gee *geecache.Group, adminToken string) (*network.CacheServer, *http.Server) {
+	cacheServer := network.NewCacheServer(addr, network.WithShutdownHandoff(1000))
 	cacheServer.AddPeers(addrs...)
 	gee.RegisterPeerPicker(cacheServer)
+	mux := http.NewServeMux()
+	mux.Handle("/_geecache/", cacheServer)
+	if adminToken != "" {
+		mux.Handle("/admin/", network.NewAdminServer(cacheServer, adminToken))
+	}
+	srv := &http.Server{Addr: addr[7:], Handler: mux}
+	go func() {
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
 	log.Println("geecache is running at", addr)
-	log.Fatal(http.ListenAndServe(addr[7:], cacheServer))
+	return cacheServer, srv
 }
 
-// 用来启动一个 API 服务（端口 9999），与用户进行交互，用户感知
-func startAPIServer(apiAddr string, group *geecache.Group) {
-	http.Handle("/api", http.HandlerFunc(
-		func(w http.ResponseWriter, r *http.Request) {
-			key := r.URL.Query().Get("key")
-			view, err := group.Get(key)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set("Content-Type", "application/octet-stream")
-			w.Write(view.ByteSlice())
-		}))
+// 用来启动一个 API 服务（端口 9999），与用户进行交互，用户感知。
+// 例如 curl http://localhost:9999/v1/groups/scores/keys/Tom
+func startAPIServer(apiAddr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/v1/", network.NewAPIServer())
 	log.Println("fontend server is running at", apiAddr)
-	log.Fatal(http.ListenAndServe(apiAddr[7:], nil))
+	log.Fatal(http.ListenAndServe(apiAddr[7:], mux))
+}
+
+// 启动 Redis 协议的服务，可以用 redis-cli -p <port> GET Tom 访问
+func startRESPServer(addr string) {
+	log.Println("resp server is running at", addr)
+	log.Fatal(network.NewRESPServer().ListenAndServe(addr))
+}
+
+// 启动 memcached 协议的服务，使用 memcached 客户端的服务可以直接访问
+func startMemcacheServer(addr string) {
+	log.Println("memcache server is running at", addr)
+	log.Fatal(network.NewMemcacheServer().ListenAndServe(addr))
+}
+
+// 节点启动时从快照恢复本地缓存，之后定期保存快照，退出时再保存一次
+func startSnapshotter(dir string, interval time.Duration) *geecache.Snapshotter {
+	snapshotter := geecache.NewSnapshotter(dir, interval)
+	if err := snapshotter.LoadAll(); err != nil {
+		log.Println("restore snapshot:", err)
+	}
+	snapshotter.Start()
+	return snapshotter
 }
 
 func main() {
 	var port int
 	var api bool
+	var snapshotDir string
+	var snapshotInterval time.Duration
+	var respAddr string
+	var memcacheAddr string
+	var adminToken string
 	flag.IntVar(&port, "port", 8001, "Geecache server port")
 	flag.BoolVar(&api, "api", false, "Start a api server")
+	flag.StringVar(&snapshotDir, "snapshot-dir", "", "Directory to save cache snapshots in, empty to disable")
+	flag.DurationVar(&snapshotInterval, "snapshot-interval", time.Minute, "How often to save cache snapshots")
+	flag.StringVar(&respAddr, "resp", "", "Address to serve the Redis protocol on, e.g. :6379, empty to disable")
+	flag.StringVar(&memcacheAddr, "memcache", "", "Address to serve the memcached protocol on, e.g. :11211, empty to disable")
+	flag.StringVar(&adminToken, "admin-token", os.Getenv("GEECACHE_ADMIN_TOKEN"), "Bearer token of the admin API on /admin/, empty to disable")
 	flag.Parse()
 
 	apiAddr := "http://localhost:9999"
@@ -74,8 +118,40 @@ func main() {
 	}
 	// 创建缓存服务器中的db
 	group := createGroup()
+	var snapshotter *geecache.Snapshotter
+	if snapshotDir != "" {
+		snapshotter = startSnapshotter(filepath.Join(snapshotDir, strconv.Itoa(port)), snapshotInterval)
+	}
 	if api {
-		go startAPIServer(apiAddr, group)
+		go startAPIServer(apiAddr)
+	}
+	if respAddr != "" {
+		go startRESPServer(respAddr)
+	}
+	if memcacheAddr != "" {
+		go startMemcacheServer(memcacheAddr)
+	}
+	cacheServer, srv := startCacheServer(addrMap[port], addrs, group, adminToken)
+
+	// 收到 SIGINT 或 SIGTERM 时平滑退出：迁移热点 key 并通知其他节点，等待进行中的请求和加载完成，最后保存快照
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := cacheServer.Shutdown(ctx); err != nil {
+		log.Println("leave cluster:", err)
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Println("shutdown:", err)
+	}
+	if err := group.Shutdown(ctx); err != nil {
+		log.Println("shutdown group:", err)
+	}
+	if snapshotter != nil {
+		snapshotter.Stop()
+		if err := snapshotter.SaveAll(); err != nil {
+			log.Println("save snapshot:", err)
+		}
 	}
-	startCacheServer(addrMap[port], addrs, group)
 }
@@ -8,17 +8,17 @@ go build -o server -gcflags="all=-N -l"
 
 sleep 2
 echo ">>> start test"
-curl "http://localhost:9999/api?key=Tom" &
-curl "http://localhost:9999/api?key=Tom" &
-curl "http://localhost:9999/api?key=Tom" &
+curl "http://localhost:9999/v1/groups/scores/keys/Tom" &
+curl "http://localhost:9999/v1/groups/scores/keys/Tom" &
+curl "http://localhost:9999/v1/groups/scores/keys/Tom" &
 
-curl "http://localhost:9999/api?key=Sam" &
-curl "http://localhost:9999/api?key=Jack" &
-curl "http://localhost:9999/api?key=Amy" &
+curl "http://localhost:9999/v1/groups/scores/keys/Sam" &
+curl "http://localhost:9999/v1/groups/scores/keys/Jack" &
+curl "http://localhost:9999/v1/groups/scores/keys/Amy" &
 
-curl "http://localhost:9999/api?key=Tom" &
-curl "http://localhost:9999/api?key=Sam" &
-curl "http://localhost:9999/api?key=Jack" &
-curl "http://localhost:9999/api?key=Amy" &
+curl "http://localhost:9999/v1/groups/scores/keys/Tom" &
+curl "http://localhost:9999/v1/groups/scores/keys/Sam" &
+curl "http://localhost:9999/v1/groups/scores/keys/Jack" &
+curl "http://localhost:9999/v1/groups/scores/keys/Amy" &
 
 wait
\ No newline at end of file
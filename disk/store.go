@@ -0,0 +1,484 @@
+package disk
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"geecache/util"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+Store 是一个日志结构（log-structured）的磁盘存储，用作内存缓存的第二级。
+
+数据按写入顺序追加到若干个段文件（segment）中，内存里只保存 key 到记录位置的索引。
+段文件写满之后切换到新的段文件，最旧的段文件最先被淘汰，因此淘汰顺序是 FIFO。
+
+每条记录的格式（小端）：
+
+	crc     4 字节，后面所有字节的 CRC-32C
+	kind    1 字节，recordPut、recordPutExpiry 或 recordDelete
+	keyLen  2 字节
+	encLen  1 字节
+	valLen  4 字节
+	expiry  8 字节，只有 recordPutExpiry 有：过期时间的 Unix 纳秒时间戳
+	key, encoding, value
+
+不过期的值使用 recordPut，与旧版本写入的记录格式相同。
+
+删除一个 key 时追加一条 recordDelete 记录（墓碑），重启时按顺序重放所有段文件即可重建索引。
+*/
+
+const (
+	recordPut       = 1
+	recordDelete    = 2
+	recordPutExpiry = 3
+
+	headerSize = 12
+	expirySize = 8
+
+	defaultCompactRatio = 0.5
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errCorrupt = errors.New("disk: corrupt record")
+
+type segment struct {
+	id   int
+	f    *os.File
+	size int64
+	// 段文件中仍然有效的记录占用的字节数
+	live int64
+}
+
+// entry 是索引中一条有效记录的位置
+type entry struct {
+	seg  *segment
+	off  int64
+	size int64
+}
+
+// Store is a log-structured on-disk key-value store with an in-memory index.
+type Store struct {
+	mtx sync.Mutex
+	dir string
+	// 所有段文件占用的磁盘空间上限
+	maxBytes int64
+	// 单个段文件的大小上限
+	segmentSize int64
+	// 最旧的段文件中无效数据的比例超过 compactRatio 时整理该段文件
+	compactRatio float64
+
+	// 按从旧到新排列，最后一个是正在写入的段文件
+	segments  []*segment
+	index     map[string]*entry
+	diskBytes int64
+	liveBytes int64
+}
+
+// Option 用于配置 Store
+type Option func(*Store)
+
+// WithSegmentSize 设置单个段文件的大小上限，默认是 maxBytes 的 1/8
+func WithSegmentSize(n int64) Option {
+	return func(s *Store) {
+		s.segmentSize = n
+	}
+}
+
+// WithCompactRatio 设置触发整理的无效数据比例，默认 0.5
+func WithCompactRatio(ratio float64) Option {
+	return func(s *Store) {
+		s.compactRatio = ratio
+	}
+}
+
+// Open opens the store in dir, creating it if needed, and rebuilds the
+// index from the existing segment files. maxBytes limits the disk space
+// used by the store.
+// 上次崩溃时写了一半的记录会被截断丢弃。
+func Open(dir string, maxBytes int64, opts ...Option) (*Store, error) {
+	s := &Store{
+		dir:          dir,
+		maxBytes:     maxBytes,
+		segmentSize:  max(maxBytes/8, 1),
+		compactRatio: defaultCompactRatio,
+		index:        make(map[string]*entry),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	ids, err := s.segmentIDs()
+	if err != nil {
+		return nil, err
+	}
+	for i, id := range ids {
+		seg, err := s.openSegment(id)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		if err := s.replay(seg, i == len(ids)-1); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	if len(s.segments) == 0 {
+		if err := s.rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *Store) segmentPath(id int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%08d.log", id))
+}
+
+// segmentIDs 返回目录中已有的段文件编号，从旧到新排列
+func (s *Store) segmentIDs() ([]int, error) {
+	names, err := filepath.Glob(filepath.Join(s.dir, "*.log"))
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, name := range names {
+		id, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(name), ".log"))
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+func (s *Store) openSegment(id int) (*segment, error) {
+	f, err := os.OpenFile(s.segmentPath(id), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	seg := &segment{id: id, f: f, size: info.Size()}
+	s.segments = append(s.segments, seg)
+	s.diskBytes += seg.size
+	return seg, nil
+}
+
+// replay 按顺序读取段文件中的所有记录来重建索引。
+// 遇到损坏或不完整的记录时，丢弃该记录及其后面的内容：最后一个段文件直接截断，以便继续追加。
+func (s *Store) replay(seg *segment, last bool) error {
+	var off int64
+	for off < seg.size {
+		kind, key, _, _, size, err := readRecord(seg.f, off, seg.size)
+		if err != nil {
+			log.Printf("[Disk] segment %d is corrupt at offset %d: %v", seg.id, off, err)
+			break
+		}
+		s.apply(kind, key, &entry{seg, off, size})
+		off += size
+	}
+	if off == seg.size {
+		return nil
+	}
+	if last {
+		if err := seg.f.Truncate(off); err != nil {
+			return err
+		}
+	}
+	s.diskBytes -= seg.size - off
+	seg.size = off
+	return nil
+}
+
+// apply 把一条记录应用到索引上
+func (s *Store) apply(kind byte, key string, e *entry) {
+	if old, ok := s.index[key]; ok {
+		old.seg.live -= old.size
+		s.liveBytes -= old.size
+		delete(s.index, key)
+	}
+	if kind != recordDelete {
+		s.index[key] = e
+		e.seg.live += e.size
+		s.liveBytes += e.size
+	}
+}
+
+// readRecord 读取并校验 off 处的一条记录，end 是段文件的长度。
+// header 还没有经过校验，先检查记录没有超出段文件，避免损坏的长度字段导致分配巨大的内存。
+func readRecord(r io.ReaderAt, off, end int64) (kind byte, key string, value util.ByteView, expiry int64, size int64, err error) {
+	var header [headerSize]byte
+	if _, err = r.ReadAt(header[:], off); err != nil {
+		return
+	}
+	kind = header[4]
+	keyLen := int64(binary.LittleEndian.Uint16(header[5:7]))
+	encLen := int64(header[7])
+	valLen := int64(binary.LittleEndian.Uint32(header[8:12]))
+	var extLen int64
+	switch kind {
+	case recordPut, recordDelete:
+	case recordPutExpiry:
+		extLen = expirySize
+	default:
+		err = errCorrupt
+		return
+	}
+	size = headerSize + extLen + keyLen + encLen + valLen
+	if off+size > end {
+		err = errCorrupt
+		return
+	}
+	buf := make([]byte, size)
+	if _, err = r.ReadAt(buf, off); err != nil {
+		return
+	}
+	if crc32.Checksum(buf[4:], crcTable) != binary.LittleEndian.Uint32(buf[:4]) {
+		err = errCorrupt
+		return
+	}
+	body := buf[headerSize:]
+	if extLen > 0 {
+		expiry = int64(binary.LittleEndian.Uint64(body[:expirySize]))
+		body = body[expirySize:]
+	}
+	key = string(body[:keyLen])
+	value = util.NewByteView(body[keyLen+encLen:], string(body[keyLen:keyLen+encLen]))
+	return
+}
+
+// encodeRecord 编码一条记录，expiry 不为 0 的 recordPut 编码为 recordPutExpiry
+func encodeRecord(kind byte, key string, value util.ByteView, expiry int64) []byte {
+	if kind == recordPut && expiry != 0 {
+		kind = recordPutExpiry
+	}
+	buf := make([]byte, headerSize, headerSize+expirySize+len(key)+len(value.Encoding)+value.Size())
+	buf[4] = kind
+	binary.LittleEndian.PutUint16(buf[5:7], uint16(len(key)))
+	buf[7] = byte(len(value.Encoding))
+	binary.LittleEndian.PutUint32(buf[8:12], uint32(value.Size()))
+	if kind == recordPutExpiry {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(expiry))
+	}
+	buf = append(buf, key...)
+	buf = append(buf, value.Encoding...)
+	buf = value.AppendTo(buf)
+	binary.LittleEndian.PutUint32(buf[:4], crc32.Checksum(buf[4:], crcTable))
+	return buf
+}
+
+func (s *Store) active() *segment {
+	return s.segments[len(s.segments)-1]
+}
+
+// rotate 创建一个新的段文件用于写入
+func (s *Store) rotate() error {
+	id := 1
+	if len(s.segments) > 0 {
+		id = s.active().id + 1
+	}
+	_, err := s.openSegment(id)
+	return err
+}
+
+// appendRecord 把记录追加到正在写入的段文件，写满时切换到新的段文件
+func (s *Store) appendRecord(kind byte, key string, value util.ByteView, expiry int64) (*entry, error) {
+	buf := encodeRecord(kind, key, value, expiry)
+	if seg := s.active(); seg.size > 0 && seg.size+int64(len(buf)) > s.segmentSize {
+		if err := s.rotate(); err != nil {
+			return nil, err
+		}
+	}
+	seg := s.active()
+	if _, err := seg.f.WriteAt(buf, seg.size); err != nil {
+		return nil, err
+	}
+	e := &entry{seg, seg.size, int64(len(buf))}
+	seg.size += e.size
+	s.diskBytes += e.size
+	return e, nil
+}
+
+// Get returns the value stored for key and the expiry passed to Put.
+func (s *Store) Get(key string) (value util.ByteView, expiry int64, ok bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	e, ok := s.index[key]
+	if !ok {
+		return util.ByteView{}, 0, false
+	}
+	_, _, value, expiry, _, err := readRecord(e.seg.f, e.off, e.seg.size)
+	if err != nil {
+		// 记录在磁盘上损坏了，当作未命中
+		log.Printf("[Disk] read %s: %v", key, err)
+		s.apply(recordDelete, key, nil)
+		return util.ByteView{}, 0, false
+	}
+	return value, expiry, true
+}
+
+// Put stores value for key, evicting the oldest data if the store is full.
+// expiry 是值的过期时间（Unix 纳秒时间戳，0 表示不过期），Store 只负责保存，由调用者判断是否过期。
+// 单个记录超过 maxBytes 时直接丢弃。
+func (s *Store) Put(key string, value util.ByteView, expiry int64) error {
+	if len(key) > 1<<16-1 || len(value.Encoding) > 1<<8-1 || int64(value.Size()) > 1<<32-1 {
+		return fmt.Errorf("disk: entry %q is too large", key)
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if headerSize+expirySize+int64(len(key)+len(value.Encoding)+value.Size()) > s.maxBytes {
+		return nil
+	}
+	e, err := s.appendRecord(recordPut, key, value, expiry)
+	if err != nil {
+		return err
+	}
+	s.apply(recordPut, key, e)
+	return s.maintain()
+}
+
+// Delete removes key from the store.
+func (s *Store) Delete(key string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if _, ok := s.index[key]; !ok {
+		return nil
+	}
+	// 写入墓碑，重启后重放时不会让 key 复活
+	if _, err := s.appendRecord(recordDelete, key, util.ByteView{}, 0); err != nil {
+		return err
+	}
+	s.apply(recordDelete, key, nil)
+	return s.maintain()
+}
+
+// maintain 整理或淘汰最旧的段文件，直到占用的磁盘空间不超过 maxBytes
+func (s *Store) maintain() error {
+	for len(s.segments) > 1 {
+		oldest := s.segments[0]
+		switch {
+		case s.diskBytes > s.maxBytes:
+			// 超过容量，直接丢弃最旧的段文件，其中的记录全部淘汰
+			for key, e := range s.index {
+				if e.seg == oldest {
+					s.apply(recordDelete, key, nil)
+				}
+			}
+		case float64(oldest.size-oldest.live) > s.compactRatio*float64(oldest.size):
+			if err := s.compact(oldest); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		if err := s.dropOldest(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compact 把最旧段文件中仍然有效的记录重新追加到最新的段文件。
+// 只整理最旧的段文件，这样其中的墓碑可以直接丢弃：不存在更旧的记录需要被它覆盖。
+func (s *Store) compact(seg *segment) error {
+	for key, e := range s.index {
+		if e.seg != seg {
+			continue
+		}
+		_, _, value, expiry, _, err := readRecord(seg.f, e.off, seg.size)
+		if err != nil {
+			s.apply(recordDelete, key, nil)
+			continue
+		}
+		ne, err := s.appendRecord(recordPut, key, value, expiry)
+		if err != nil {
+			return err
+		}
+		s.apply(recordPut, key, ne)
+	}
+	return nil
+}
+
+func (s *Store) dropOldest() error {
+	oldest := s.segments[0]
+	s.segments = s.segments[1:]
+	s.diskBytes -= oldest.size
+	oldest.f.Close()
+	return os.Remove(s.segmentPath(oldest.id))
+}
+
+// Compact 整理所有无效数据比例过高的段文件
+func (s *Store) Compact() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	// 先切换到新的段文件，使所有旧的段文件都可以被整理
+	if s.active().size > 0 {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	return s.maintain()
+}
+
+// Purge 删除所有数据
+func (s *Store) Purge() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for len(s.segments) > 1 {
+		if err := s.dropOldest(); err != nil {
+			return err
+		}
+	}
+	// 最后一个段文件也删掉，换成新的空段文件
+	if err := s.rotate(); err != nil {
+		return err
+	}
+	if err := s.dropOldest(); err != nil {
+		return err
+	}
+	clear(s.index)
+	s.liveBytes = 0
+	return nil
+}
+
+// Len 返回有效的 key 个数
+func (s *Store) Len() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.index)
+}
+
+// DiskBytes 返回所有段文件占用的磁盘空间
+func (s *Store) DiskBytes() int64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.diskBytes
+}
+
+// Close closes all segment files.
+func (s *Store) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	var errs []error
+	for _, seg := range s.segments {
+		errs = append(errs, seg.f.Close())
+	}
+	s.segments = nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,227 @@
+package disk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"geecache/util"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func value(s string) util.ByteView {
+	return util.NewByteView([]byte(s), "")
+}
+
+func mustGet(t *testing.T, s *Store, key, want string) {
+	t.Helper()
+	v, _, ok := s.Get(key)
+	if !ok || v.String() != want {
+		t.Fatalf("Get(%q) = %q, %v; want %q", key, v.String(), ok, want)
+	}
+}
+
+func TestStore(t *testing.T) {
+	s, err := Open(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s.Put("Tom", value("630"), 0)
+	s.Put("Jack", util.NewByteView([]byte("589"), "gzip"), 0)
+	s.Put("Tom", value("631"), 0)
+	mustGet(t, s, "Tom", "631")
+	if v, _, _ := s.Get("Jack"); v.Encoding != "gzip" {
+		t.Errorf("encoding = %q, want gzip", v.Encoding)
+	}
+	s.Delete("Tom")
+	if _, _, ok := s.Get("Tom"); ok {
+		t.Errorf("Tom should be deleted")
+	}
+	if s.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", s.Len())
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 1<<20, WithSegmentSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 10 {
+		s.Put(fmt.Sprintf("key%d", i), value(fmt.Sprintf("value%d", i)), 0)
+	}
+	s.Delete("key3")
+	s.Put("key5", value("new"), 0)
+	s.Close()
+
+	// 模拟崩溃时最后一条记录只写了一半
+	ids, _ := (&Store{dir: dir}).segmentIDs()
+	last := filepath.Join(dir, fmt.Sprintf("%08d.log", ids[len(ids)-1]))
+	f, err := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(encodeRecord(recordPut, "torn", value("torn value"), 0)[:15])
+	f.Close()
+
+	s, err = Open(dir, 1<<20, WithSegmentSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, _, ok := s.Get("key3"); ok {
+		t.Errorf("deleted key3 came back after recovery")
+	}
+	if _, _, ok := s.Get("torn"); ok {
+		t.Errorf("torn record should be discarded")
+	}
+	mustGet(t, s, "key5", "new")
+	mustGet(t, s, "key9", "value9")
+	// 截断之后可以继续追加
+	s.Put("after", value("crash"), 0)
+	mustGet(t, s, "after", "crash")
+	if info, _ := os.Stat(last); info.Size() > s.DiskBytes() {
+		t.Errorf("torn tail was not truncated")
+	}
+}
+
+func TestCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Put("key", value("value"), 0)
+	f, err := os.OpenFile(filepath.Join(dir, "00000001.log"), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte("X"), headerSize+3)
+	f.Close()
+	if _, _, ok := s.Get("key"); ok {
+		t.Errorf("corrupt record should be reported as a miss")
+	}
+}
+
+func TestCorruptLength(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put("key", value("value"), 0)
+	s.Close()
+
+	// 段文件末尾的 header 声称值有 4GiB，重放时应该当作损坏的尾部截断，而不是按这个长度分配内存
+	path := filepath.Join(dir, "00000001.log")
+	record := encodeRecord(recordPut, "huge", value("x"), 0)
+	binary.LittleEndian.PutUint32(record[8:12], math.MaxUint32)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(record)
+	f.Close()
+	if _, _, _, _, _, err := readRecord(bytes.NewReader(record), 0, int64(len(record))); err != errCorrupt {
+		t.Fatalf("readRecord with an oversized length: %v", err)
+	}
+
+	s, err = Open(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	mustGet(t, s, "key", "value")
+	if _, _, ok := s.Get("huge"); ok {
+		t.Errorf("record with a corrupt length should be discarded")
+	}
+}
+
+func TestBudgetAndCompaction(t *testing.T) {
+	const maxBytes = 4 << 10
+	s, err := Open(t.TempDir(), maxBytes, WithSegmentSize(1<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	val := value(string(make([]byte, 100)))
+	for i := range 200 {
+		s.Put(fmt.Sprintf("key%d", i), val, 0)
+		if s.DiskBytes() > maxBytes {
+			t.Fatalf("disk usage %d exceeds budget %d", s.DiskBytes(), maxBytes)
+		}
+	}
+	// 最旧的数据先被淘汰
+	if _, _, ok := s.Get("key0"); ok {
+		t.Errorf("key0 should be evicted")
+	}
+	mustGet(t, s, "key199", val.String())
+
+	// 删除大部分 key 之后，整理可以回收空间，剩下的 key 仍然可读
+	n := s.Len()
+	before := s.DiskBytes()
+	for i := range 195 {
+		s.Delete(fmt.Sprintf("key%d", i))
+	}
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if s.DiskBytes() >= before {
+		t.Errorf("compaction did not reclaim space: %d >= %d", s.DiskBytes(), before)
+	}
+	if s.Len() != min(n, 5) {
+		t.Errorf("Len() = %d, want %d", s.Len(), min(n, 5))
+	}
+	for i := 195; i < 200; i++ {
+		mustGet(t, s, fmt.Sprintf("key%d", i), val.String())
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 1<<20, WithSegmentSize(1<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expiry = 1700000000123456789
+	s.Put("ttl", value("630"), expiry)
+	s.Put("forever", value("589"), 0)
+	check := func(stage string) {
+		t.Helper()
+		if v, e, ok := s.Get("ttl"); !ok || v.String() != "630" || e != expiry {
+			t.Fatalf("%s: Get(ttl) = %q, %d, %v", stage, v.String(), e, ok)
+		}
+		if v, e, ok := s.Get("forever"); !ok || v.String() != "589" || e != 0 {
+			t.Fatalf("%s: Get(forever) = %q, %d, %v", stage, v.String(), e, ok)
+		}
+	}
+	check("after Put")
+
+	// 整理时保留过期时间
+	s.Put("garbage", value(string(make([]byte, 900))), 0)
+	s.Delete("garbage")
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	check("after Compact")
+
+	// 重启后保留过期时间
+	s.Close()
+	if s, err = Open(dir, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	check("after reopening")
+
+	// 不过期的值使用旧版本的 recordPut 格式
+	if kind := encodeRecord(recordPut, "k", value("v"), 0)[4]; kind != recordPut {
+		t.Fatalf("records without expiry should use recordPut, got kind %d", kind)
+	}
+}
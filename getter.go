@@ -1,5 +1,11 @@
 package geecache
 
+import "errors"
+
+// ErrNotFound 表示数据源中不存在 key。Getter 应该返回 ErrNotFound（或者用 %w 包装它），
+// 前端（如 APIServer）据此区分 key 不存在和加载失败。
+var ErrNotFound = errors.New("geecache: key not found")
+
 // A Getter loads data for a key when cache missed.
 type Getter interface {
 	Get(key string) ([]byte, error)
@@ -13,6 +19,26 @@ func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
+// A Setter writes data for a key back to the data source. See Group.Set.
+type Setter interface {
+	Set(key string, value []byte) error
+}
+
+// A SetterFunc implements Setter with a function.
+type SetterFunc func(key string, value []byte) error
+
+// Set implements Setter interface function
+func (f SetterFunc) Set(key string, value []byte) error {
+	return f(key, value)
+}
+
+// A BatchSetter is a Setter that can write several keys at once, e.g. in
+// one database transaction. Write-behind uses SetBatch when it's available.
+type BatchSetter interface {
+	Setter
+	SetBatch(entries map[string][]byte) error
+}
+
 /*
 定义函数类型 GetterFunc，并实现 Getter 接口的 Get 方法。
 函数类型实现某一个接口，称之为接口型函数，方便使用者在调用时既能够传入函数作为参数，也能够传入实现了该接口的结构体作为参数。
@@ -2,4 +2,8 @@ module geecache
 
 go 1.22.6
 
-require google.golang.org/protobuf v1.36.6
+require (
+	github.com/BurntSushi/toml v1.4.0
+	google.golang.org/protobuf v1.36.6
+	gopkg.in/yaml.v3 v3.0.1
+)
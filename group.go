@@ -1,12 +1,19 @@
 package geecache
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"geecache/compress"
+	"geecache/lru"
 	pb "geecache/proto"
 	"geecache/singleflight"
+	"geecache/slab"
 	"geecache/util"
+	"io"
 	"log"
 	"sync"
+	"time"
 )
 
 // Group 相当于redis里的db。
@@ -22,14 +29,100 @@ type Group struct {
 	// use singleflight.Batch to make sure that
 	// each key is only fetched once
 	loader *singleflight.Batch
+	// 大小不小于 compressThreshold 的值用 compressor 压缩后再保存到本地缓存
+	compressor        compress.Compressor
+	compressThreshold int
+	// Set 同步写入数据源（write-through）时使用 setter，异步写入（write-behind）时使用 writeBehind
+	setter      Setter
+	writeBehind *writeBehind
+	// 统计计数器，见 Stats
+	stats stats
+	// 共享的内存预算，为 nil 时本地缓存的容量是固定的 cacheBytes
+	budget *budgetMember
+
+	// 保护 closed 和 srcGetter：Shutdown 之后不再开始新的加载和写入
+	lifeMtx sync.RWMutex
+	closed  bool
+	// 正在进行的加载和写入
+	inflight sync.WaitGroup
+	// 所有加载结束、资源释放之后关闭，shutdownErr 是释放资源时的错误
+	shutdownOnce sync.Once
+	shutdownDone chan struct{}
+	shutdownErr  error
 }
 
-var (
-	mtx    sync.RWMutex
-	groups = make(map[string]*Group)
-)
+// GroupOption 用于配置 Group
+type GroupOption func(*Group)
+
+// WithCompression 使用 c 压缩不小于 threshold 字节的值。缓存中保存、节点间传输的都是压缩后的数据，
+// LRU 按压缩后的大小计算占用的内存；Get 返回的总是解压后的值。
+func WithCompression(c compress.Compressor, threshold int) GroupOption {
+	return func(g *Group) {
+		g.compressor = c
+		g.compressThreshold = threshold
+	}
+}
+
+// WithSecondTier 把 t 作为本地缓存的第二级存储：从内存中淘汰的项写入 t，内存缓存未命中时先查 t。
+// 过期时间随缓存项一起写入 t，在两级之间移动不会延长缓存项的存活时间。
+func WithSecondTier(t SecondTier) GroupOption {
+	return func(g *Group) {
+		g.localCache.l2 = t
+	}
+}
+
+// WithFootprintAccounting 按缓存项实际占用的内存（包括链表、map 等每项的固定开销）计算 cacheBytes，
+// 见 lru.AccountFootprint。默认只计算 key 和值的长度，大量小缓存项实际占用的内存会是 cacheBytes 的数倍。
+func WithFootprintAccounting() GroupOption {
+	return func(g *Group) {
+		g.localCache.accounting = lru.AccountFootprint
+	}
+}
+
+// WithSlabStore 把本地缓存的项保存在 slab.Cache 预先分配的大块内存中，而不是每项一个对象的 list+map LRU，
+// 缓存项很多时能大幅减少 GC 扫描的时间，见 slab 包的基准测试。cacheBytes 按 page 向下取整，
+// 不支持 WithFootprintAccounting，超过一个 page 的缓存项不保存在内存中（有第二级存储时直接写入第二级存储）。
+// 第一次写入时的 cacheBytes 小于 page 时，page 缩小到 cacheBytes，之后调大容量时 page 的大小不变，
+// 所以使用共享内存预算时应该给 group 设置不小于 page 的 minBytes。
+func WithSlabStore(opts ...slab.Option) GroupOption {
+	return func(g *Group) {
+		g.localCache.newStore = newSlabStore(opts...)
+	}
+}
+
+// WithTTL 让本地缓存中的项在写入 ttl 之后过期，过期的项会重新从对端节点或数据源加载。
+// 每个节点独立计算过期时间，同一个 key 在不同节点上的过期时间可能不同。
+func WithTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.localCache.ttl = ttl
+	}
+}
+
+// WithWriteThrough 让 Group.Set 先同步地把值写入 s，成功之后再更新缓存
+func WithWriteThrough(s Setter) GroupOption {
+	return func(g *Group) {
+		g.setter = s
+		g.writeBehind = nil
+	}
+}
+
+// WithWriteBehind 让 Group.Set 先更新缓存，再由后台队列批量地把值写入 s，失败时重试。
+// s 实现了 BatchSetter 时一批 key 只调用一次 SetBatch。
+func WithWriteBehind(s Setter, cfg WriteBehindConfig) GroupOption {
+	return func(g *Group) {
+		g.setter = nil
+		g.writeBehind = newWriteBehind(s, cfg)
+	}
+}
 
-func NewGroup(name string, cacheBytes int64, srcGetter Getter) *Group {
+// ErrClosed 表示 group 已经 Shutdown，不能再加载或写入值
+var ErrClosed = errors.New("geecache: group is shut down")
+
+// errNoPeer 表示 key 的 owner 就是本节点，不需要从远程节点加载
+var errNoPeer = errors.New("no peer for key")
+
+// newGroup 创建一个不属于任何注册表的 group
+func newGroup(name string, cacheBytes int64, srcGetter Getter, opts []GroupOption) *Group {
 	if srcGetter == nil {
 		panic("nil Getter")
 	}
@@ -39,19 +132,19 @@ func NewGroup(name string, cacheBytes int64, srcGetter Getter) *Group {
 		localCache: &Cache{cacheBytes: cacheBytes},
 		loader:     singleflight.NewBatch(),
 	}
-	mtx.Lock()
-	defer mtx.Unlock()
-	groups[name] = g
+	for _, opt := range opts {
+		opt(g)
+	}
+	if g.budget != nil {
+		g.budget.group = g
+		g.budget.budget.join(g.budget)
+	}
 	return g
 }
 
-// GetGroup returns the named group previously created with NewGroup, or
-// nil if there's no such group.
-func GetGroup(name string) *Group {
-	mtx.RLock()
-	g := groups[name]
-	mtx.RUnlock()
-	return g
+// Name returns the name of the group.
+func (g *Group) Name() string {
+	return g.name
 }
 
 func (g *Group) RegisterPeerPicker(picker PeerPicker) {
@@ -62,25 +155,56 @@ func (g *Group) RegisterPeerPicker(picker PeerPicker) {
 }
 
 func (g *Group) Get(key string) (util.ByteView, error) {
+	val, err := g.GetEncoded(key)
+	if err != nil {
+		return util.ByteView{}, err
+	}
+	return compress.Decode(val)
+}
+
+// GetEncoded 与 Get 相同，但返回的是缓存中保存的形式，可能是压缩过的（见 util.ByteView.Encoding）。
+// 用于把值原样发送给其他节点，避免先解压再压缩。
+func (g *Group) GetEncoded(key string) (util.ByteView, error) {
+	g.stats.gets.Add(1)
 	// 先从本地缓存中取值
 	if val, ok := g.localCache.Get(key); ok {
+		g.stats.cacheHits.Add(1)
 		log.Printf("%s 命中本地缓存!", key)
 		return val, nil
 	}
+	if !g.acquire() {
+		return util.ByteView{}, ErrClosed
+	}
+	defer g.inflight.Done()
+	g.stats.loads.Add(1)
 	// 本地缓存未命中，继续尝试远程缓存
 	// each key is only fetched once (either locally 打到数据库 or remotely 打到对端)
 	// regardless of the number of concurrent callers.
 	// 这个 CallOnce 只有在缓存没有命中的时候才会执行，并不会影响缓存的本身的性能，缓存没命中的时候必然要等待获取数据，要么等其他节点返回，要么等锁。
 	val, err := g.loader.Call(key, func() (interface{}, error) {
+		g.stats.loadsDeduped.Add(1)
 		if g.peerPicker != nil {
 			if val, err := g.getFromPeer(key); err == nil {
+				g.stats.peerLoads.Add(1)
 				log.Printf("%s 命中远程缓存!", key)
 				return val, nil
+			} else if !errors.Is(err, errNoPeer) {
+				g.stats.peerErrors.Add(1)
 			}
 		}
 		log.Printf("%s 未命中任何缓存,直接读数据源!", key)
 		// 远程缓存也没命中，则直接从数据源取
-		return g.getFromSouce(key)
+		val, err := g.getFromSouce(key)
+		if err != nil {
+			g.stats.localLoadErrs.Add(1)
+		} else {
+			g.stats.localLoads.Add(1)
+			// 从数据源加载后，同时填充 key 的其他副本
+			if picker, ok := g.peerPicker.(ReplicaPicker); ok {
+				picker.Replicate(g.name, key, val)
+			}
+		}
+		return val, err
 	})
 	if err == nil {
 		return val.(util.ByteView), err
@@ -88,20 +212,88 @@ func (g *Group) Get(key string) (util.ByteView, error) {
 	return util.ByteView{}, err
 }
 
+// GetReader 以 io.Reader 的方式读取 key 对应的值，调用者负责关闭返回的 io.ReadCloser。
+// 本地缓存未命中时，如果对端节点支持流式传输（PeerStreamer），值直接从网络流式读出，不会在本节点完整地缓存一份，适合很大的值。
+func (g *Group) GetReader(key string) (io.ReadCloser, error) {
+	if val, ok := g.localCache.Get(key); ok {
+		log.Printf("%s 命中本地缓存!", key)
+		return compress.NewReader(val)
+	}
+	if g.peerPicker != nil {
+		for _, peer := range g.pickPeers(key) {
+			streamer, ok := peer.(PeerStreamer)
+			if !ok {
+				continue
+			}
+			rc, err := streamer.GetStream(&pb.Request{Group: g.name, Key: key})
+			if err == nil {
+				log.Printf("%s 命中远程缓存!", key)
+				return rc, nil
+			}
+			log.Printf("%s 读取远程缓存失败: %v", key, err)
+		}
+	}
+	val, err := g.GetEncoded(key)
+	if err != nil {
+		return nil, err
+	}
+	return compress.NewReader(val)
+}
+
 func (g *Group) getFromSouce(key string) (util.ByteView, error) {
-	bytes, err := g.srcGetter.Get(key)
+	bytes, err := g.getter().Get(key)
 	if err != nil {
 		return util.ByteView{}, err
 	}
-	value := util.ByteView{B: util.CloneBytes(bytes)} // 这里bytes是切片，所以不会深拷贝，所以这里手动深拷贝来防止底层数据源修改了数据导致util.ByteView中持有的数据也被修改
+	value := g.encode(key, util.CloneBytes(bytes)) // 这里bytes是切片，所以不会深拷贝，所以这里手动深拷贝来防止底层数据源修改了数据导致util.ByteView中持有的数据也被修改
 	g.populateCache(key, value)
-	return value, err
+	return value, nil
+}
+
+// encode 按 group 的压缩设置把 bytes 转换成缓存中保存的形式
+func (g *Group) encode(key string, bytes []byte) util.ByteView {
+	if g.compressor != nil && len(bytes) >= g.compressThreshold {
+		// 压缩后没有变小的值（比如图片）不压缩
+		if data, err := g.compressor.Compress(bytes); err != nil {
+			log.Printf("%s 压缩失败: %v", key, err)
+		} else if len(data) < len(bytes) {
+			return util.NewByteView(data, g.compressor.Name())
+		}
+	}
+	return util.NewByteView(bytes, "")
+}
+
+// pickPeers 返回保存 key 的远程节点，本节点就是 owner 时返回 nil
+func (g *Group) pickPeers(key string) []PeerGetter {
+	if picker, ok := g.peerPicker.(ReplicaPicker); ok {
+		return picker.PickPeers(key)
+	}
+	if peer := g.peerPicker.PickPeer(key); peer != nil {
+		return []PeerGetter{peer}
+	}
+	return nil
 }
 
 func (g *Group) getFromPeer(key string) (util.ByteView, error) {
-	peer := g.peerPicker.PickPeer(key)
+	picker, ok := g.peerPicker.(ReplicaPicker)
+	if !ok {
+		return g.getFromOnePeer(key, g.peerPicker.PickPeer(key))
+	}
+	// 依次尝试 key 的各个副本，owner 挂掉时可以从其他副本读取
+	err := fmt.Errorf("%w: %s", errNoPeer, key)
+	for _, peer := range picker.PickPeers(key) {
+		var val util.ByteView
+		if val, err = g.getFromOnePeer(key, peer); err == nil {
+			return val, nil
+		}
+		log.Printf("%s 读取副本失败: %v", key, err)
+	}
+	return util.ByteView{}, err
+}
+
+func (g *Group) getFromOnePeer(key string, peer PeerGetter) (util.ByteView, error) {
 	if peer == nil {
-		return util.ByteView{}, fmt.Errorf("no peer for key: %s", key)
+		return util.ByteView{}, fmt.Errorf("%w: %s", errNoPeer, key)
 	}
 	var resp pb.Response
 	err := peer.Get(&pb.Request{
@@ -113,10 +305,189 @@ func (g *Group) getFromPeer(key string) (util.ByteView, error) {
 	}
 	// 对于远程节点，不应该更新其远程缓存。因为分布式缓存的目的是不同key缓存在不同的节点上，增加总的吞吐量。如果大家转发请求后，都再备份一次，每台机器上都缓存了相同的数据，就失去意义了。每个节点缓存1G数据，理论上10个节点总共可以缓存10G不同的数据。
 	// 当然对于热点数据，每个节点拿到值后，本机备份一次是有价值的，增加热点数据的吞吐量。groupcache 的原生实现中，有1/10的概率会在本机存一次。这样10个节点，理论上可以缓存9G不同的数据，算是一种取舍。
-	return util.ByteView{B: util.CloneBytes(resp.Value)}, err // 这里bytes是切片，所以不会深拷贝，所以这里手动深拷贝来防止底层数据源修改了数据导致util.ByteView中持有的数据也被修改
+	return util.NewByteView(util.CloneBytes(resp.Value), resp.Encoding), err // 这里bytes是切片，所以不会深拷贝，所以这里手动深拷贝来防止底层数据源修改了数据导致util.ByteView中持有的数据也被修改
+}
+
+// Set 更新 key 的值，用于业务写完数据库之后立即刷新缓存，而不是等缓存未命中时再从数据源加载。
+// 值被发送给 key 的 owner 节点（本节点就是 owner 时直接写入本地缓存），再由 owner 复制到其他副本。
+// 配置了 WithWriteThrough 时先同步写入数据源，失败时不更新缓存；
+// 配置了 WithWriteBehind 时即使更新缓存失败，值也会异步写入数据源。
+func (g *Group) Set(key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	if !g.acquire() {
+		return ErrClosed
+	}
+	defer g.inflight.Done()
+	g.stats.sets.Add(1)
+	value = util.CloneBytes(value)
+	if g.setter != nil {
+		if err := g.setter.Set(key, value); err != nil {
+			return err
+		}
+	}
+	err := g.setCache(key, g.encode(key, value))
+	if g.writeBehind != nil {
+		g.writeBehind.enqueue(key, value)
+	}
+	return err
+}
+
+// setCache 把 value 写入 key 的 owner 的缓存
+func (g *Group) setCache(key string, value util.ByteView) error {
+	if g.peerPicker != nil {
+		if peers := g.pickPeers(key); len(peers) > 0 {
+			// owner 挂掉时依次尝试其他副本
+			err := fmt.Errorf("no peer accepts writes for key: %s", key)
+			for _, peer := range peers {
+				setter, ok := peer.(PeerSetter)
+				if !ok {
+					continue
+				}
+				if err = setter.Set(&pb.SetRequest{
+					Group:    g.name,
+					Key:      key,
+					Value:    value.ByteSlice(),
+					Encoding: value.Encoding,
+				}, &pb.SetResponse{}); err == nil {
+					return nil
+				}
+				log.Printf("%s 写入远程缓存失败: %v", key, err)
+			}
+			return err
+		}
+	}
+	g.populateCache(key, value)
+	if picker, ok := g.peerPicker.(ReplicaPicker); ok {
+		picker.Replicate(g.name, key, value)
+	}
+	return nil
+}
+
+// Contains 判断本节点的缓存（包括第二级存储）中是否有 key，不会从其他节点或者数据源加载
+func (g *Group) Contains(key string) bool {
+	_, ok := g.localCache.Get(key)
+	return ok
+}
+
+// Evict 从本节点的缓存中删除 key，返回 key 是否在内存缓存中。不会通知其他节点，也不会修改数据源。
+func (g *Group) Evict(key string) bool {
+	return g.localCache.Remove(key)
+}
+
+// Delete 让 key 在整个集群中失效：从本节点和所有持有 key 的节点的缓存中删除，之后的 Get 会重新从数据源加载。
+// 不会删除数据源中的数据。
+func (g *Group) Delete(key string) error {
+	g.stats.deletes.Add(1)
+	g.Evict(key)
+	if inv, ok := g.peerPicker.(Invalidator); ok {
+		return inv.Invalidate(g.name, key)
+	}
+	return nil
+}
+
+// Purge 清空本节点的缓存，不会通知其他节点
+func (g *Group) Purge() error {
+	return g.localCache.Purge()
+}
+
+// CacheBytes 返回本地缓存的容量上限
+func (g *Group) CacheBytes() int64 {
+	return g.localCache.CacheBytes()
+}
+
+// SetCacheBytes 在运行时修改本地缓存的容量上限，缩小时立即淘汰超出的部分
+func (g *Group) SetCacheBytes(n int64) {
+	g.localCache.SetCacheBytes(n)
+}
+
+// SetGetter 在运行时更换数据源，之后的加载使用 getter，已经缓存的值不受影响
+func (g *Group) SetGetter(getter Getter) {
+	if getter == nil {
+		panic("nil Getter")
+	}
+	g.lifeMtx.Lock()
+	defer g.lifeMtx.Unlock()
+	g.srcGetter = getter
+}
+
+func (g *Group) getter() Getter {
+	g.lifeMtx.RLock()
+	defer g.lifeMtx.RUnlock()
+	return g.srcGetter
+}
+
+// TTL 返回本地缓存中的项的存活时间，0 表示不过期
+func (g *Group) TTL() time.Duration {
+	return g.localCache.TTL()
+}
+
+// SetTTL 在运行时修改之后写入的项的存活时间，<= 0 表示不过期
+func (g *Group) SetTTL(ttl time.Duration) {
+	g.localCache.SetTTL(ttl)
+}
+
+// Flush 把 write-behind 队列中的值立即写入数据源，没有配置 WithWriteBehind 时什么也不做
+func (g *Group) Flush() error {
+	if g.writeBehind == nil {
+		return nil
+	}
+	return g.writeBehind.flush()
 }
 
 // 更新本地缓存
 func (g *Group) populateCache(key string, value util.ByteView) {
 	g.localCache.Put(key, value)
 }
+
+// Populate 直接把 key 写入本地缓存，不经过对端节点和数据源。用于接收其他节点迁移过来的数据。
+// value 可以是压缩过的，压缩算法必须已经在 compress 中注册。
+func (g *Group) Populate(key string, value util.ByteView) {
+	g.populateCache(key, value)
+}
+
+// Range 遍历本地缓存中的所有 key，fn 返回 false 时停止遍历。value 是缓存中保存的形式，可能是压缩过的。
+func (g *Group) Range(fn func(key string, value util.ByteView) bool) {
+	g.localCache.Range(fn)
+}
+
+// acquire 登记一个加载或写入，group 已经关闭时返回 false。返回 true 时调用者结束后必须调用 g.inflight.Done()
+func (g *Group) acquire() bool {
+	g.lifeMtx.RLock()
+	defer g.lifeMtx.RUnlock()
+	if g.closed {
+		return false
+	}
+	g.inflight.Add(1)
+	return true
+}
+
+// Shutdown 关闭 group：不再开始新的加载和写入（本地缓存命中的 Get 仍然可用，其他情况返回 ErrClosed），
+// 等待正在进行的加载完成，把 write-behind 队列写入数据源，最后停止 singleflight 的协程。
+// ctx 到期时返回 ctx.Err()，剩下的工作在后台继续完成。可以多次调用。
+func (g *Group) Shutdown(ctx context.Context) error {
+	g.lifeMtx.Lock()
+	g.closed = true
+	g.lifeMtx.Unlock()
+	g.shutdownOnce.Do(func() {
+		g.shutdownDone = make(chan struct{})
+		go func() {
+			defer close(g.shutdownDone)
+			g.inflight.Wait()
+			if g.writeBehind != nil {
+				g.shutdownErr = g.writeBehind.close()
+			}
+			g.loader.Close()
+			if g.budget != nil {
+				g.budget.budget.leave(g.budget)
+			}
+		}()
+	})
+	select {
+	case <-g.shutdownDone:
+		return g.shutdownErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
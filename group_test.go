@@ -1,12 +1,31 @@
 package geecache
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"geecache/compress"
+	"geecache/disk"
+	"geecache/lru"
+	"geecache/slab"
+	"geecache/util"
+	"io"
 	"log"
+	"math/rand"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
+// newTestGroup 在全局注册表中创建 group，测试结束时删除，同一个测试可以多次运行
+func newTestGroup(t *testing.T, name string, cacheBytes int64, getter Getter, opts ...GroupOption) *Group {
+	t.Cleanup(func() { DeleteGroup(context.Background(), name) })
+	return NewGroup(name, cacheBytes, getter, opts...)
+}
+
 func TestGetter(t *testing.T) {
 	var f = GetterFunc(func(key string) ([]byte, error) {
 		return []byte(key), nil
@@ -26,7 +45,7 @@ func TestGroup(t *testing.T) {
 	}
 	t.Run("Get", func(t *testing.T) {
 		loadCounts := make(map[string]int, len(db))
-		gee := NewGroup("scores", 2<<10, GetterFunc(
+		gee := newTestGroup(t, "scores", 2<<10, GetterFunc(
 			func(key string) ([]byte, error) {
 				log.Println("[SlowDB] search key", key)
 				if v, ok := db[key]; ok {
@@ -53,3 +72,503 @@ func TestGroup(t *testing.T) {
 		}
 	})
 }
+
+func TestCompression(t *testing.T) {
+	blob := strings.Repeat(`{"name":"Tom","score":630},`, 400)
+	gee := newTestGroup(t, "json", 1<<20, GetterFunc(func(key string) ([]byte, error) {
+		if key == "small" {
+			return []byte("630"), nil
+		}
+		return []byte(blob), nil
+	}), WithCompression(compress.Gzip, 64))
+
+	for range 2 {
+		view, err := gee.Get("blob")
+		if err != nil || view.String() != blob || view.Encoding != "" {
+			t.Fatalf("expected the decompressed blob, got %d bytes encoded %q: %v", view.Size(), view.Encoding, err)
+		}
+	}
+	stored, err := gee.GetEncoded("blob")
+	if err != nil || stored.Encoding != "gzip" {
+		t.Fatalf("expected blob to be stored gzip-compressed, got %q: %v", stored.Encoding, err)
+	}
+	// LRU 按压缩后的大小计算占用的内存
+	if used := gee.localCache.Bytes(); used >= int64(len(blob))/4 {
+		t.Fatalf("expected compressed accounting, cache uses %d bytes for a %d byte value", used, len(blob))
+	}
+
+	// 小于阈值的值不压缩
+	if view, err := gee.GetEncoded("small"); err != nil || view.Encoding != "" || view.String() != "630" {
+		t.Fatalf("small value should be stored uncompressed, got %q: %v", view.Encoding, err)
+	}
+	rc, err := gee.GetReader("blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if data, err := io.ReadAll(rc); err != nil || string(data) != blob {
+		t.Fatalf("GetReader should decompress: %v", err)
+	}
+}
+
+func TestSecondTier(t *testing.T) {
+	dir := t.TempDir()
+	store, err := disk.Open(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loads := make(map[string]int)
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads[key]++
+		return []byte(strings.Repeat(key, 10)), nil
+	})
+	// 内存里只放得下两三个值
+	gee := newTestGroup(t, "tiered", 100, getter, WithSecondTier(store))
+
+	keys := []string{"Tom", "Jack", "Sam", "Alice", "Bob"}
+	for _, k := range keys {
+		if _, err := gee.Get(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.Len() == 0 {
+		t.Fatalf("evicted values should be written to the second tier")
+	}
+	// 被淘汰的值从第二级存储读回，不再访问数据源
+	for _, k := range keys {
+		if view, err := gee.Get(k); err != nil || view.String() != strings.Repeat(k, 10) {
+			t.Fatalf("Get(%s) = %q, %v", k, view.String(), err)
+		}
+		if loads[k] != 1 {
+			t.Fatalf("%s loaded %d times from the source", k, loads[k])
+		}
+	}
+
+	// 重启后第二级存储中的数据仍然可用
+	if err := DeleteGroup(context.Background(), "tiered"); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	store, err = disk.Open(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	gee = newTestGroup(t, "tiered", 100, getter, WithSecondTier(store))
+	for _, k := range keys[:2] {
+		if _, err := gee.Get(k); err != nil || loads[k] != 1 {
+			t.Fatalf("%s should be recovered from disk, loaded %d times: %v", k, loads[k], err)
+		}
+	}
+}
+
+// 在内存和第二级存储之间移动的项保留原来的过期时间
+func TestSecondTierTTL(t *testing.T) {
+	store, err := disk.Open(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	loads := make(map[string]int)
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads[key]++
+		return []byte(strings.Repeat(key, 10) + strconv.Itoa(loads[key])), nil
+	})
+	now := time.Unix(1000, 0)
+	// 内存里只放得下两三个值
+	gee := newTestGroup(t, "tiered-ttl", 100, getter, WithTTL(time.Minute), WithSecondTier(store))
+	gee.localCache.now = func() time.Time { return now }
+
+	keys := []string{"Tom", "Jack", "Sam", "Alice", "Bob"}
+	// 每隔 20s 把所有 key 读一遍，key 反复在内存和磁盘之间移动
+	for range 3 {
+		for _, k := range keys {
+			if _, err := gee.Get(k); err != nil {
+				t.Fatal(err)
+			}
+		}
+		now = now.Add(20 * time.Second)
+	}
+	for _, k := range keys {
+		if loads[k] != 1 {
+			t.Fatalf("%s loaded %d times before expiring", k, loads[k])
+		}
+	}
+	// 写入一分钟之后，不管在内存还是磁盘中都已经过期
+	for _, k := range keys {
+		if v, err := gee.Get(k); err != nil || v.String() != strings.Repeat(k, 10)+"2" {
+			t.Fatalf("Get(%s) = %q, %v, want a reloaded value", k, v.String(), err)
+		}
+	}
+}
+
+// pausingTier 在 Get 读到值之后暂停，直到 resume 被关闭，测试可以在这期间并发写入
+type pausingTier struct {
+	SecondTier
+	read   chan struct{}
+	resume chan struct{}
+}
+
+func (p *pausingTier) Get(key string) (util.ByteView, int64, bool) {
+	v, expiry, ok := p.SecondTier.Get(key)
+	if p.read != nil {
+		p.read <- struct{}{}
+		<-p.resume
+	}
+	return v, expiry, ok
+}
+
+// 从第二级存储读出旧值之后、提升回内存之前，并发的 Put 写入的新值不能被旧值覆盖
+func TestSecondTierPromoteRace(t *testing.T) {
+	store, err := disk.Open(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	tier := &pausingTier{SecondTier: store}
+	c := &Cache{cacheBytes: 100, l2: tier}
+	c.Put("Tom", util.NewByteView([]byte("old"), ""))
+	// 写入其他 key，把 Tom 挤到第二级存储
+	for i := 0; ; i++ {
+		if _, _, ok := store.Get("Tom"); ok {
+			break
+		}
+		c.Put("filler"+strconv.Itoa(i), util.NewByteView(make([]byte, 20), ""))
+	}
+
+	tier.read, tier.resume = make(chan struct{}), make(chan struct{})
+	got := make(chan string)
+	go func() {
+		v, _ := c.Get("Tom")
+		got <- v.String()
+	}()
+	<-tier.read
+	c.Put("Tom", util.NewByteView([]byte("new"), ""))
+	tier.read = nil
+	close(tier.resume)
+	if v := <-got; v != "new" {
+		t.Fatalf("concurrent Get returned %q, want the value written by Put", v)
+	}
+	if v, ok := c.Get("Tom"); !ok || v.String() != "new" {
+		t.Fatalf("Get(Tom) = %q, %v: the stale value from the second tier overwrote the new one", v.String(), ok)
+	}
+	if _, _, ok := store.Get("Tom"); ok {
+		t.Fatalf("Tom should only be kept in memory")
+	}
+}
+
+// batchDB 是一个支持批量写入的测试数据源，前 failures 次写入会失败
+type batchDB struct {
+	mtx      sync.Mutex
+	data     map[string]string
+	batches  int
+	failures int
+}
+
+func (db *batchDB) Get(key string) ([]byte, error) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	if v, ok := db.data[key]; ok {
+		return []byte(v), nil
+	}
+	return nil, fmt.Errorf("%s not exist", key)
+}
+
+func (db *batchDB) Set(key string, value []byte) error {
+	return db.SetBatch(map[string][]byte{key: value})
+}
+
+func (db *batchDB) SetBatch(entries map[string][]byte) error {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	if db.failures > 0 {
+		db.failures--
+		return fmt.Errorf("database unavailable")
+	}
+	db.batches++
+	for k, v := range entries {
+		db.data[k] = string(v)
+	}
+	return nil
+}
+
+func TestSet(t *testing.T) {
+	t.Run("WriteThrough", func(t *testing.T) {
+		db := &batchDB{data: make(map[string]string)}
+		gee := newTestGroup(t, "write-through", 2<<10, db, WithWriteThrough(db))
+		if err := gee.Set("Tom", []byte("630")); err != nil {
+			t.Fatal(err)
+		}
+		if db.data["Tom"] != "630" {
+			t.Fatalf("value should be written to the source synchronously")
+		}
+		if view, ok := gee.localCache.Get("Tom"); !ok || view.String() != "630" {
+			t.Fatalf("value should be cached")
+		}
+
+		// 写入数据源失败时不更新缓存
+		db.failures = 1
+		if err := gee.Set("Jack", []byte("589")); err == nil {
+			t.Fatalf("expected error from the source")
+		}
+		if _, ok := gee.localCache.Get("Jack"); ok {
+			t.Fatalf("failed write should not be cached")
+		}
+	})
+
+	t.Run("WriteBehind", func(t *testing.T) {
+		db := &batchDB{data: make(map[string]string), failures: 2}
+		gee := newTestGroup(t, "write-behind", 2<<10, db, WithWriteBehind(db, WriteBehindConfig{
+			FlushInterval: time.Hour,
+			RetryBackoff:  time.Millisecond,
+		}))
+		for i := range 10 {
+			if err := gee.Set("Tom", []byte(strconv.Itoa(i))); err != nil {
+				t.Fatal(err)
+			}
+			gee.Set("Sam"+strconv.Itoa(i), []byte("567"))
+		}
+		// 缓存立即可见，数据源还没有写入
+		if view, err := gee.Get("Tom"); err != nil || view.String() != "9" {
+			t.Fatalf("Get(Tom) = %q, %v", view.String(), err)
+		}
+		db.mtx.Lock()
+		if len(db.data) != 0 {
+			t.Fatalf("write-behind should not write synchronously")
+		}
+		db.mtx.Unlock()
+
+		// 失败两次后重试成功，所有 key 在一个批次中写入，同一个 key 只写入最后的值
+		if err := gee.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if db.batches != 1 || len(db.data) != 11 || db.data["Tom"] != "9" {
+			t.Fatalf("expected one batch of 11 keys with Tom=9, got %d batches: %v", db.batches, db.data)
+		}
+
+		db.failures = 100
+		gee.Set("Jack", []byte("589"))
+		if err := gee.Flush(); err == nil {
+			t.Fatalf("expected error after running out of retries")
+		}
+	})
+
+	// 逐个写入时，后面的 key 写入成功不会掩盖前面 key 的错误
+	t.Run("WriteBehindPartialFailure", func(t *testing.T) {
+		errBroken := errors.New("broken row")
+		written := make(map[string]string)
+		var mtx sync.Mutex
+		setter := SetterFunc(func(key string, value []byte) error {
+			if key == "bad" {
+				return errBroken
+			}
+			mtx.Lock()
+			defer mtx.Unlock()
+			written[key] = string(value)
+			return nil
+		})
+		gee := newTestGroup(t, "write-behind-partial", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+			return nil, ErrNotFound
+		}), WithWriteBehind(setter, WriteBehindConfig{FlushInterval: time.Hour, RetryBackoff: time.Millisecond}))
+		gee.Set("bad", []byte("0"))
+		for i := range 20 {
+			gee.Set("key"+strconv.Itoa(i), []byte("1"))
+		}
+		err := gee.Flush()
+		if !errors.Is(err, errBroken) || !strings.Contains(err.Error(), "dropped 1 keys") || !strings.Contains(err.Error(), "set bad") {
+			t.Fatalf("expected the error of the dropped key, got %v", err)
+		}
+		mtx.Lock()
+		defer mtx.Unlock()
+		if len(written) != 20 {
+			t.Fatalf("expected the other 20 keys to be written, got %d", len(written))
+		}
+	})
+}
+
+func TestTTL(t *testing.T) {
+	now := time.Unix(1000, 0)
+	loads := 0
+	gee := newTestGroup(t, "ttl", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(key + strconv.Itoa(loads)), nil
+	}), WithTTL(time.Minute))
+	gee.localCache.now = func() time.Time { return now }
+
+	if v, _ := gee.Get("Tom"); v.String() != "Tom1" {
+		t.Fatalf("unexpected value %q", v)
+	}
+	now = now.Add(59 * time.Second)
+	if v, _ := gee.Get("Tom"); v.String() != "Tom1" || loads != 1 {
+		t.Fatalf("value should be cached before it expires")
+	}
+	now = now.Add(time.Second)
+	if v, _ := gee.Get("Tom"); v.String() != "Tom2" || loads != 2 {
+		t.Fatalf("expired value should be reloaded, got %q", v)
+	}
+
+	// 已经在缓存中的项保持原来的过期时间
+	gee.SetTTL(0)
+	now = now.Add(time.Minute)
+	if v, _ := gee.Get("Tom"); v.String() != "Tom3" {
+		t.Fatalf("expired value should be reloaded, got %q", v)
+	}
+	now = now.Add(24 * time.Hour)
+	if v, _ := gee.Get("Tom"); v.String() != "Tom3" || gee.TTL() != 0 {
+		t.Fatalf("value should never expire without a ttl")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	db := &batchDB{data: make(map[string]string)}
+	gee := newTestGroup(t, "shutdown", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		close(started)
+		<-release
+		return []byte("630"), nil
+	}), WithWriteBehind(db, WriteBehindConfig{FlushInterval: time.Hour}))
+	if err := gee.Set("Jack", []byte("589")); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := make(chan error, 1)
+	go func() {
+		view, err := gee.Get("Tom")
+		if err == nil && view.String() != "630" {
+			err = fmt.Errorf("Get(Tom) = %q", view.String())
+		}
+		loaded <- err
+	}()
+	<-started
+
+	// 加载还没有结束，Shutdown 等待到 ctx 超时
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := gee.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected Shutdown to wait for the in-flight load, got %v", err)
+	}
+	// 关闭之后不再开始新的加载和写入
+	if _, err := gee.Get("Sam"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Get after Shutdown: %v", err)
+	}
+	if err := gee.Set("Sam", []byte("567")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Set after Shutdown: %v", err)
+	}
+
+	close(release)
+	if err := <-loaded; err != nil {
+		t.Fatalf("in-flight load should complete: %v", err)
+	}
+	if err := gee.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// write-behind 队列在关闭时写入数据源，缓存中已有的值仍然可以读取
+	if db.data["Jack"] != "589" {
+		t.Fatalf("write-behind queue should be flushed on Shutdown: %v", db.data)
+	}
+	if view, err := gee.Get("Tom"); err != nil || view.String() != "630" {
+		t.Fatalf("cached Get after Shutdown = %q, %v", view.String(), err)
+	}
+}
+
+func TestFootprintAccounting(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte("1"), nil
+	})
+	payload := newTestGroup(t, "payload", 4<<10, getter)
+	footprint := newTestGroup(t, "footprint", 4<<10, getter, WithFootprintAccounting())
+	for i := range 1000 {
+		payload.Get(strconv.Itoa(i))
+		footprint.Get(strconv.Itoa(i))
+	}
+	// 每项只有几个字节的 payload，但是固定开销有一百多字节
+	if n := payload.localCache.Len(); n != 1000 {
+		t.Fatalf("payload accounting should keep all %d small entries, got %d", 1000, n)
+	}
+	if n := footprint.localCache.Len(); n == 0 || n > (4<<10)/lru.EntryOverhead {
+		t.Fatalf("footprint accounting kept %d entries in %d bytes", n, 4<<10)
+	}
+	if b := footprint.localCache.Bytes(); b > 4<<10 {
+		t.Fatalf("footprint accounting exceeded cacheBytes: %d", b)
+	}
+}
+
+func TestSlabStore(t *testing.T) {
+	now := time.Unix(1000, 0)
+	loads := make(map[string]int)
+	blob := strings.Repeat(`{"name":"Tom","score":630},`, 400)
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads[key]++
+		if key == "blob" {
+			return []byte(blob), nil
+		}
+		return []byte(key + strconv.Itoa(loads[key])), nil
+	})
+	store, err := disk.Open(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	gee := newTestGroup(t, "slab", 16<<10, getter, WithSlabStore(slab.WithPageSize(1<<10)),
+		WithCompression(compress.Gzip, 64), WithTTL(time.Minute), WithSecondTier(store))
+	gee.localCache.now = func() time.Time { return now }
+
+	for range 2 {
+		if v, err := gee.Get("Tom"); err != nil || v.String() != "Tom1" || loads["Tom"] != 1 {
+			t.Fatalf("Get(Tom) = %q, %v, loaded %d times", v.String(), err, loads["Tom"])
+		}
+		// 压缩后的值连同 Encoding 一起保存在 slab 中
+		if v, err := gee.Get("blob"); err != nil || v.String() != blob || loads["blob"] != 1 {
+			t.Fatalf("Get(blob) = %d bytes, %v, loaded %d times", v.Size(), err, loads["blob"])
+		}
+	}
+	if v, err := gee.GetEncoded("blob"); err != nil || v.Encoding != "gzip" {
+		t.Fatalf("blob should be cached gzip-compressed, got %q: %v", v.Encoding, err)
+	}
+
+	// 过期时间保存在 slab 中
+	now = now.Add(time.Minute)
+	if v, _ := gee.Get("Tom"); v.String() != "Tom2" {
+		t.Fatalf("expired value should be reloaded, got %q", v)
+	}
+
+	// 超过一个 page 的值不放在内存中，直接写入第二级存储。随机数据压缩后不会变小
+	data := make([]byte, 2<<10)
+	rand.New(rand.NewSource(1)).Read(data)
+	big := string(data)
+	gee.Set("big", data)
+	if _, ok := gee.localCache.store.get("big"); ok {
+		t.Fatalf("values larger than a page should not be kept in the slab")
+	}
+	if v, err := gee.Get("big"); err != nil || v.String() != big || loads["big"] != 0 {
+		t.Fatalf("Get(big) = %d bytes, %v, loaded %d times", v.Size(), err, loads["big"])
+	}
+
+	// 遍历时跳过已经过期的 blob
+	var keys []string
+	gee.localCache.Range(func(key string, value util.ByteView) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if len(keys) != 1 || keys[0] != "Tom" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+}
+
+func TestSlabStoreSmallCache(t *testing.T) {
+	// cacheBytes 小于默认的 1MiB page 时仍然能缓存
+	loads := 0
+	gee := newTestGroup(t, "slab-small", 512<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(key), nil
+	}), WithSlabStore())
+	for range 2 {
+		if v, err := gee.Get("Tom"); err != nil || v.String() != "Tom" {
+			t.Fatalf("Get(Tom) = %q, %v", v.String(), err)
+		}
+	}
+	if loads != 1 || gee.Stats().Items != 1 {
+		t.Fatalf("expected Tom to be cached, loaded %d times, %d items", loads, gee.Stats().Items)
+	}
+}
@@ -0,0 +1,59 @@
+package lru
+
+import (
+	"container/list"
+	"unsafe"
+)
+
+// Accounting 决定缓存项按多少字节计入 maxBytes
+type Accounting int
+
+const (
+	// AccountPayload 只计算 key 和值的长度（len(key) + Value.Size()），这是默认的方式。
+	// 小的缓存项的实际内存占用可能是这个值的好几倍。
+	AccountPayload Accounting = iota
+	// AccountFootprint 计算 key 和值的实际内存占用（值实现了 Footprinter 时使用 Footprint），
+	// 再加上每个缓存项在链表、map 和 Entry 上的固定开销 EntryOverhead
+	AccountFootprint
+)
+
+// Footprinter 由能够报告自己实际内存占用的 Value 实现，AccountFootprint 模式下代替 Size 使用。
+// 例如 []byte 类型的值应该包括切片头和底层数组的容量，而不只是长度。
+type Footprinter interface {
+	Footprint() int
+}
+
+// EntryOverhead 是 AccountFootprint 模式下每个缓存项的固定开销的估计值（64 位平台上约 130 字节）：
+// list.Element、Entry、map 中的一个槽位（按平均一半的装载率计算）和 key 的字符串头。
+var EntryOverhead = roundUp(int(unsafe.Sizeof(list.Element{}))) +
+	roundUp(int(unsafe.Sizeof(Entry{}))) +
+	2*int(unsafe.Sizeof("")+unsafe.Sizeof((*list.Element)(nil))+1)
+
+// roundUp 把 n 向上取整到 16 字节，近似 Go 内存分配器的小对象规格
+func roundUp(n int) int {
+	return (n + 15) &^ 15
+}
+
+// Option 用于配置 Cache
+type Option func(*Cache)
+
+// WithAccounting 设置缓存项的计算方式，默认是 AccountPayload
+func WithAccounting(a Accounting) Option {
+	return func(l *Cache) {
+		l.accounting = a
+	}
+}
+
+// size 返回 key 和 value 组成的缓存项按 l.accounting 计算的字节数
+func (l *Cache) size(key string, value Value) int64 {
+	if l.accounting != AccountFootprint {
+		return int64(len(key)) + int64(value.Size())
+	}
+	n := EntryOverhead + roundUp(len(key))
+	if f, ok := value.(Footprinter); ok {
+		n += f.Footprint()
+	} else {
+		n += value.Size()
+	}
+	return int64(n)
+}
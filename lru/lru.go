@@ -14,6 +14,8 @@ type Cache struct {
 	ll        *list.List // 队头最新,队尾最旧
 	cache     map[string]*list.Element
 	OnEvicted func(key string, value Value)
+	// 缓存项计入 nbytes 的方式，见 Accounting
+	accounting Accounting
 }
 
 type Entry struct {
@@ -21,18 +23,23 @@ type Entry struct {
 	Value Value
 }
 
+// Size 返回缓存项按 AccountPayload 计算的字节数
 func (e *Entry) Size() int64 {
 	return int64(len(e.Key)) + int64(e.Value.Size())
 }
 
-func New(maxBytes int64, onEvicted func(key string, value Value)) *Cache {
-	return &Cache{
+func New(maxBytes int64, onEvicted func(key string, value Value), opts ...Option) *Cache {
+	l := &Cache{
 		maxBytes:  maxBytes,
 		nbytes:    0,
 		ll:        list.New(),
 		cache:     make(map[string]*list.Element),
 		OnEvicted: onEvicted,
 	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
 }
 
 func (l *Cache) touch(ele *list.Element) {
@@ -43,7 +50,7 @@ func (l *Cache) evict() {
 	val := l.ll.Remove(l.ll.Back())
 	kv := val.(*Entry)
 	delete(l.cache, kv.Key)
-	l.nbytes -= int64(len(kv.Key)) + int64(kv.Value.Size())
+	l.nbytes -= l.size(kv.Key, kv.Value)
 	if l.OnEvicted != nil {
 		l.OnEvicted(kv.Key, kv.Value)
 	}
@@ -60,18 +67,58 @@ func (l *Cache) Get(key string) (value Value, ok bool) {
 func (l *Cache) Put(key string, value Value) {
 	if ele, ok := l.cache[key]; ok {
 		kv := ele.Value.(*Entry)
+		l.nbytes += l.size(key, value) - l.size(key, kv.Value)
 		kv.Value = value
-		l.nbytes += int64(value.Size()) - int64(kv.Value.Size())
 		l.touch(ele)
 	} else {
 		l.cache[key] = l.ll.PushFront(&Entry{key, value})
-		l.nbytes += int64(len(key)) + int64(value.Size())
+		l.nbytes += l.size(key, value)
 	}
 	for l.nbytes > l.maxBytes {
 		l.evict()
 	}
 }
 
+// Remove 删除 key，返回 key 是否存在。主动删除的项不会触发 OnEvicted。
+func (l *Cache) Remove(key string) bool {
+	ele, ok := l.cache[key]
+	if !ok {
+		return false
+	}
+	kv := l.ll.Remove(ele).(*Entry)
+	delete(l.cache, key)
+	l.nbytes -= l.size(kv.Key, kv.Value)
+	return true
+}
+
+// SetMaxBytes 修改容量上限，超出上限的部分立即淘汰（会触发 OnEvicted）
+func (l *Cache) SetMaxBytes(maxBytes int64) {
+	l.maxBytes = maxBytes
+	for l.nbytes > l.maxBytes && l.ll.Len() > 0 {
+		l.evict()
+	}
+}
+
+// MaxBytes 返回容量上限
+func (l *Cache) MaxBytes() int64 {
+	return l.maxBytes
+}
+
 func (l *Cache) Len() int {
 	return l.ll.Len()
 }
+
+// Bytes 返回缓存当前占用的字节数
+func (l *Cache) Bytes() int64 {
+	return l.nbytes
+}
+
+// Range 从最新到最旧依次遍历缓存项，fn 返回 false 时停止遍历。遍历不会更新缓存项的新旧顺序。
+func (l *Cache) Range(fn func(key string, value Value) bool) {
+	for ele := l.ll.Front(); ele != nil; ele = ele.Next() {
+		kv := ele.Value.(*Entry)
+		if !fn(kv.Key, kv.Value) {
+			return
+		}
+	}
+}
@@ -59,3 +59,45 @@ func TestOnEvicted(t *testing.T) {
 		t.Fatalf("LRUCache Size() failed")
 	}
 }
+
+func TestRemove(t *testing.T) {
+	evicted := false
+	lru := New(int64(100), func(string, Value) { evicted = true })
+	lru.Put("key1", String("1234"))
+	lru.Put("key2", String("5678"))
+	if !lru.Remove("key1") || lru.Remove("key1") {
+		t.Fatalf("Remove should report whether the key existed")
+	}
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 1 || lru.Bytes() != int64(len("key2")+4) {
+		t.Fatalf("key1 should be removed, len=%d bytes=%d", lru.Len(), lru.Bytes())
+	}
+	if evicted {
+		t.Fatalf("Remove should not call OnEvicted")
+	}
+}
+
+func TestAccounting(t *testing.T) {
+	payload := New(int64(100), nil)
+	footprint := New(int64(1000), nil, WithAccounting(AccountFootprint))
+	for _, l := range []*Cache{payload, footprint} {
+		l.Put("key1", String("1234"))
+		// 更新已有的 key 时按新旧值的差计算
+		l.Put("key1", String("123456"))
+	}
+	if payload.Bytes() != int64(len("key1")+6) {
+		t.Fatalf("payload accounting should count key and value, got %d", payload.Bytes())
+	}
+	if want := int64(EntryOverhead + 16 + 6); footprint.Bytes() != want {
+		t.Fatalf("footprint accounting should include the entry overhead, got %d, want %d", footprint.Bytes(), want)
+	}
+	// 实现了 Footprinter 的值按 Footprint 计算
+	footprint.Put("key2", bytesValue(make([]byte, 4, 64)))
+	if footprint.Len() != 2 || footprint.Bytes() != int64(2*EntryOverhead+2*16+6+24+64) {
+		t.Fatalf("unexpected footprint %d with %d entries", footprint.Bytes(), footprint.Len())
+	}
+	footprint.Remove("key1")
+	footprint.Remove("key2")
+	if footprint.Bytes() != 0 {
+		t.Fatalf("bytes should drop to 0 after Remove, got %d", footprint.Bytes())
+	}
+}
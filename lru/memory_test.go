@@ -0,0 +1,61 @@
+package lru
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+	"unsafe"
+)
+
+// bytesValue 是测试用的 []byte 值，Footprint 包括装箱到 Value 接口时分配的切片头
+type bytesValue []byte
+
+func (b bytesValue) Size() int {
+	return len(b)
+}
+
+func (b bytesValue) Footprint() int {
+	return int(unsafe.Sizeof(b)) + cap(b)
+}
+
+// heapInUse 在 GC 之后返回堆上存活对象占用的字节数
+func heapInUse() int64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return int64(ms.HeapAlloc)
+}
+
+// measure 向 accounting 模式的缓存写入 n 个 valueSize 字节的值，返回缓存报告的字节数和实际增加的堆内存
+func measure(accounting Accounting, n, valueSize int) (reported, actual int64) {
+	before := heapInUse()
+	l := New(1<<40, nil, WithAccounting(accounting))
+	for i := range n {
+		l.Put("key-"+strconv.Itoa(i), bytesValue(make([]byte, valueSize)))
+	}
+	actual = heapInUse() - before
+	reported = l.Bytes()
+	runtime.KeepAlive(l)
+	return reported, actual
+}
+
+// TestMemoryAccounting 比较缓存报告的字节数和 runtime.MemStats 中实际增加的堆内存
+func TestMemoryAccounting(t *testing.T) {
+	if testing.Short() {
+		t.Skip("measuring heap usage is slow")
+	}
+	for _, valueSize := range []int{0, 16, 100, 1000, 10000} {
+		n := min(100000, (64<<20)/(valueSize+200))
+		t.Run(strconv.Itoa(valueSize), func(t *testing.T) {
+			reported, actual := measure(AccountFootprint, n, valueSize)
+			ratio := float64(reported) / float64(actual)
+			payload, _ := measure(AccountPayload, n, valueSize)
+			t.Logf("%d entries of %d bytes: heap %d, footprint %d (%.2f), payload %d (%.2f)",
+				n, valueSize, actual, reported, ratio, payload, float64(payload)/float64(actual))
+			// 估计值与实际内存的误差应该在 25% 以内
+			if ratio < 0.75 || ratio > 1.25 {
+				t.Errorf("footprint accounting is off: reported %d bytes, heap grew by %d bytes (ratio %.2f)", reported, actual, ratio)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package network
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"geecache"
+	"geecache/consistenthash"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+/*
+AdminServer 是 CacheServer 的运维接口，用于在不重启的情况下管理集群，应该挂载在 /admin/ 上。
+所有请求都必须带上 Authorization: Bearer <token>，token 是 NewAdminServer 的 token，
+或者是 CacheServer 的 Auth（见 WithAuth）中拥有 admin 权限的客户端：/admin/groups/{group}/ 下的操作需要该 group 的 admin 权限，
+其他操作需要 AllGroups 的 admin 权限。
+
+	GET    /admin/peers                             列出哈希环上的节点
+	POST   /admin/peers                             添加节点，请求体是 {"peers": [{"id": "http://10.0.0.2:8001", "zone": "a", "rack": "r1"}]}
+	DELETE /admin/peers?id=<peer>                   删除节点，该节点的 key 迁移给新的归属节点
+	GET    /admin/ring[?key=<key>]                  每个节点拥有的哈希空间比例，指定 key 时同时返回 key 的持有者
+	GET    /admin/groups                            列出 group 及其统计信息，以及被拒绝的请求数（配置了 WithAuth 时）
+	POST   /admin/groups/{group}/flush              清空本节点上 group 的缓存
+	DELETE /admin/groups/{group}/keys/{key}         从本节点的缓存中删除 key
+	PUT    /admin/groups/{group}/cache-bytes        修改 group 的缓存容量，请求体是 {"cache_bytes": 67108864}
+
+替换一个节点时，先在其他所有节点上 DELETE 该节点，它拥有的 key 会被迁移走，再 POST 新节点。
+*/
+
+// AdminServer is an http.Handler serving the admin API of a CacheServer.
+type AdminServer struct {
+	server *CacheServer
+	token  string
+	mux    *http.ServeMux
+}
+
+// NewAdminServer 创建 p 的运维接口，token 是拥有全部权限的 bearer token。
+// token 为空并且 p 没有配置 WithAuth 时拒绝所有请求。
+func NewAdminServer(p *CacheServer, token string) *AdminServer {
+	s := &AdminServer{server: p, token: token, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /admin/peers", s.listPeers)
+	s.mux.HandleFunc("POST /admin/peers", s.addPeers)
+	s.mux.HandleFunc("DELETE /admin/peers", s.delPeer)
+	s.mux.HandleFunc("GET /admin/ring", s.ring)
+	s.mux.HandleFunc("GET /admin/groups", s.listGroups)
+	s.mux.HandleFunc("POST /admin/groups/{group}/flush", s.withGroup(s.flushGroup))
+	s.mux.HandleFunc("DELETE /admin/groups/{group}/keys/{key...}", s.withGroup(s.evictKey))
+	s.mux.HandleFunc("PUT /admin/groups/{group}/cache-bytes", s.withGroup(s.resizeGroup))
+	s.mux.HandleFunc("/admin/", func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, http.StatusNotFound, "no such route: "+r.Method+" "+r.URL.Path)
+	})
+	return s
+}
+
+func (s *AdminServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	// 用常数时间比较，避免通过响应时间猜出 token
+	if ok && s.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1 {
+		s.mux.ServeHTTP(w, r)
+		return
+	}
+	if auth := s.server.auth; auth != nil {
+		group := adminGroup(r.URL.Path)
+		err := auth.authorizeToken(r, group, PermAdmin)
+		if err == nil {
+			s.mux.ServeHTTP(w, r)
+			return
+		}
+		if g := s.server.getGroup(group); g != nil {
+			g.RecordUnauthorized()
+		}
+		if authStatus(err) == http.StatusForbidden {
+			writeJSONError(w, http.StatusForbidden, err.Error())
+			return
+		}
+	}
+	w.Header().Set("WWW-Authenticate", `Bearer realm="geecache-admin"`)
+	writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+}
+
+// adminGroup 返回请求操作的 group，与具体 group 无关的操作返回 AllGroups
+func adminGroup(path string) string {
+	rest, ok := strings.CutPrefix(path, "/admin/groups/")
+	if !ok {
+		return AllGroups
+	}
+	group, _, ok := strings.Cut(rest, "/")
+	if !ok || group == "" {
+		return AllGroups
+	}
+	return group
+}
+
+// PeerInfo 描述哈希环上的一个节点
+type PeerInfo struct {
+	ID   consistenthash.NodeID `json:"id"`
+	Zone string                `json:"zone,omitempty"`
+	Rack string                `json:"rack,omitempty"`
+	// 是否是处理请求的节点本身
+	Self bool `json:"self,omitempty"`
+	// 节点拥有的哈希空间比例
+	Ownership float64 `json:"ownership,omitempty"`
+}
+
+func (s *AdminServer) peers() []PeerInfo {
+	p := s.server
+	p.RLock()
+	defer p.RUnlock()
+	var peers []PeerInfo
+	for _, id := range p.peers.Nodes() {
+		meta := p.peers.Meta(id)
+		peers = append(peers, PeerInfo{ID: id, Zone: meta.Zone, Rack: meta.Rack, Self: id == consistenthash.NodeID(p.selfURL)})
+	}
+	return peers
+}
+
+func (s *AdminServer) listPeers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"peers": s.peers()})
+}
+
+// validPeer 检查节点 ID 是否是 http(s) 地址
+func validPeer(id consistenthash.NodeID) bool {
+	u, err := url.Parse(string(id))
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func (s *AdminServer) addPeers(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Peers []PeerInfo `json:"peers"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, defaultMaxBodySize)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "decoding request body: "+err.Error())
+		return
+	}
+	if len(req.Peers) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "no peers given")
+		return
+	}
+	for _, peer := range req.Peers {
+		if !validPeer(peer.ID) {
+			writeJSONError(w, http.StatusBadRequest, "invalid peer id: "+string(peer.ID))
+			return
+		}
+	}
+	for _, peer := range req.Peers {
+		s.server.AddPeer(peer.ID, consistenthash.Meta{Zone: peer.Zone, Rack: peer.Rack})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"peers": s.peers()})
+}
+
+func (s *AdminServer) delPeer(w http.ResponseWriter, r *http.Request) {
+	id := consistenthash.NodeID(r.URL.Query().Get("id"))
+	switch {
+	case id == "":
+		writeJSONError(w, http.StatusBadRequest, "id is required")
+		return
+	case id == consistenthash.NodeID(s.server.selfURL):
+		writeJSONError(w, http.StatusBadRequest, "cannot remove self")
+		return
+	case !s.server.peers.HasNode(id):
+		writeJSONError(w, http.StatusNotFound, "no such peer: "+string(id))
+		return
+	}
+	s.server.DelPeeker(id)
+	writeJSON(w, http.StatusOK, map[string]any{"peers": s.peers()})
+}
+
+func (s *AdminServer) ring(w http.ResponseWriter, r *http.Request) {
+	peers := s.peers()
+	ownership := s.server.peers.Ownership()
+	for i := range peers {
+		peers[i].Ownership = ownership[peers[i].ID]
+	}
+	resp := map[string]any{"peers": peers}
+	if key := r.URL.Query().Get("key"); key != "" {
+		p := s.server
+		p.RLock()
+		resp["key"] = map[string]any{
+			"key":     key,
+			"owner":   p.peers.GetNode(key),
+			"holders": p.peers.GetNodes(key, p.replication),
+		}
+		p.RUnlock()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// GroupInfo 描述一个 group
+type GroupInfo struct {
+	Name       string         `json:"name"`
+	CacheBytes int64          `json:"cache_bytes"`
+	Stats      geecache.Stats `json:"stats"`
+}
+
+func groupInfo(g *geecache.Group) GroupInfo {
+	return GroupInfo{Name: g.Name(), CacheBytes: g.CacheBytes(), Stats: g.Stats()}
+}
+
+func (s *AdminServer) listGroups(w http.ResponseWriter, r *http.Request) {
+	groups := []GroupInfo{}
+	for _, g := range s.server.listGroups() {
+		groups = append(groups, groupInfo(g))
+	}
+	resp := map[string]any{"groups": groups}
+	// 配置了 WithAuth 时同时返回被拒绝的请求数
+	if auth := s.server.auth; auth != nil {
+		resp["auth"] = auth.Stats()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *AdminServer) withGroup(h func(w http.ResponseWriter, r *http.Request, g *geecache.Group)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("group")
+		g := s.server.getGroup(name)
+		if g == nil {
+			writeJSONError(w, http.StatusNotFound, "no such group: "+name)
+			return
+		}
+		h(w, r, g)
+	}
+}
+
+func (s *AdminServer) flushGroup(w http.ResponseWriter, r *http.Request, g *geecache.Group) {
+	if err := g.Purge(); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *AdminServer) evictKey(w http.ResponseWriter, r *http.Request, g *geecache.Group) {
+	key, ok := pathKey(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"evicted": g.Evict(key)})
+}
+
+func (s *AdminServer) resizeGroup(w http.ResponseWriter, r *http.Request, g *geecache.Group) {
+	var req struct {
+		CacheBytes *int64 `json:"cache_bytes"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, defaultMaxBodySize)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "decoding request body: "+err.Error())
+		return
+	}
+	if req.CacheBytes == nil || *req.CacheBytes <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "cache_bytes must be positive")
+		return
+	}
+	g.SetCacheBytes(*req.CacheBytes)
+	writeJSON(w, http.StatusOK, groupInfo(g))
+}
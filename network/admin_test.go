@@ -0,0 +1,194 @@
+package network
+
+import (
+	"encoding/json"
+	"geecache/consistenthash"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAdminServer(t *testing.T) {
+	// 足够多的虚拟节点，两个节点拥有的比例都接近 1/2
+	a := newTestNode(t, WithTransferRate(0), WithVirtualNodes(1000))
+	b := newTestNode(t, WithTransferRate(0))
+	a.server.AddPeers(a.id())
+	ts := httptest.NewServer(NewAdminServer(a.server, "secret"))
+	defer ts.Close()
+
+	do := func(token, method, path, body string, v any) int {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, _ := io.ReadAll(res.Body)
+		if v != nil && res.StatusCode < 300 {
+			if err := json.Unmarshal(data, v); err != nil {
+				t.Fatalf("%s %s: %v: %s", method, path, err, data)
+			}
+		}
+		return res.StatusCode
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		if code := do(token, http.MethodGet, "/admin/peers", "", nil); code != http.StatusUnauthorized {
+			t.Fatalf("token %q: expect 401, got %d", token, code)
+		}
+	}
+	// 没有配置 token 时拒绝所有请求
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/admin/peers", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	NewAdminServer(a.server, "").ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("empty server token: expect 401, got %d", recorder.Code)
+	}
+
+	var peers struct {
+		Peers []PeerInfo `json:"peers"`
+	}
+	if code := do("secret", http.MethodGet, "/admin/peers", "", &peers); code != http.StatusOK {
+		t.Fatalf("list peers: %d", code)
+	}
+	if len(peers.Peers) != 1 || peers.Peers[0].ID != a.id() || !peers.Peers[0].Self {
+		t.Fatalf("unexpected peers %+v", peers.Peers)
+	}
+
+	if code := do("secret", http.MethodPost, "/admin/peers", `{"peers": [{"id": "localhost:8001"}]}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("invalid peer: expect 400, got %d", code)
+	}
+	body := `{"peers": [{"id": "` + string(b.id()) + `", "zone": "z1"}]}`
+	if code := do("secret", http.MethodPost, "/admin/peers", body, &peers); code != http.StatusOK {
+		t.Fatalf("add peer: %d", code)
+	}
+	if len(peers.Peers) != 2 || !a.server.peers.HasNode(b.id()) {
+		t.Fatalf("peer not added: %+v", peers.Peers)
+	}
+
+	var ring struct {
+		Peers []PeerInfo `json:"peers"`
+		Key   struct {
+			Owner   consistenthash.NodeID   `json:"owner"`
+			Holders []consistenthash.NodeID `json:"holders"`
+		} `json:"key"`
+	}
+	if code := do("secret", http.MethodGet, "/admin/ring?key=Tom", "", &ring); code != http.StatusOK {
+		t.Fatalf("ring: %d", code)
+	}
+	var total float64
+	for _, peer := range ring.Peers {
+		// 默认的 CRC32 也要按整个 64 位的环计算比例
+		if math.Abs(peer.Ownership*float64(len(ring.Peers))-1) > 0.15 {
+			t.Fatalf("%s owns %v of the ring, want about 1/%d", peer.ID, peer.Ownership, len(ring.Peers))
+		}
+		total += peer.Ownership
+	}
+	if math.Abs(total-1) > 1e-9 {
+		t.Fatalf("ownership sums to %v", total)
+	}
+	if owner := a.server.peers.GetNode("Tom"); ring.Key.Owner != owner || len(ring.Key.Holders) != 1 || ring.Key.Holders[0] != owner {
+		t.Fatalf("unexpected key placement %+v, owner %s", ring.Key, owner)
+	}
+
+	if code := do("secret", http.MethodDelete, "/admin/peers?id="+string(a.id()), "", nil); code != http.StatusBadRequest {
+		t.Fatalf("remove self: expect 400, got %d", code)
+	}
+	if code := do("secret", http.MethodDelete, "/admin/peers?id=http://10.0.0.9:8001", "", nil); code != http.StatusNotFound {
+		t.Fatalf("remove unknown peer: expect 404, got %d", code)
+	}
+	if code := do("secret", http.MethodDelete, "/admin/peers?id="+string(b.id()), "", &peers); code != http.StatusOK {
+		t.Fatalf("remove peer: %d", code)
+	}
+	if len(peers.Peers) != 1 || a.server.peers.HasNode(b.id()) {
+		t.Fatalf("peer not removed: %+v", peers.Peers)
+	}
+
+	// 只剩下自己，所有 key 都在本地加载
+	const numKeys = 100
+	for i := range numKeys {
+		if _, err := a.group.Get(strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var groups struct {
+		Groups []GroupInfo `json:"groups"`
+	}
+	if code := do("secret", http.MethodGet, "/admin/groups", "", &groups); code != http.StatusOK {
+		t.Fatalf("list groups: %d", code)
+	}
+	if len(groups.Groups) != 1 || groups.Groups[0].Name != "scores" || groups.Groups[0].Stats.Items != numKeys {
+		t.Fatalf("unexpected groups %+v", groups.Groups)
+	}
+
+	var evicted struct {
+		Evicted bool `json:"evicted"`
+	}
+	if code := do("secret", http.MethodDelete, "/admin/groups/scores/keys/0", "", &evicted); code != http.StatusOK || !evicted.Evicted {
+		t.Fatalf("evict key: %d %+v", code, evicted)
+	}
+	if code := do("secret", http.MethodDelete, "/admin/groups/scores/keys/0", "", &evicted); code != http.StatusOK || evicted.Evicted {
+		t.Fatalf("evict missing key: %d %+v", code, evicted)
+	}
+	if code := do("secret", http.MethodDelete, "/admin/groups/nope/keys/0", "", nil); code != http.StatusNotFound {
+		t.Fatalf("unknown group: expect 404, got %d", code)
+	}
+
+	// 缩小容量后多余的 key 被淘汰
+	if code := do("secret", http.MethodPut, "/admin/groups/scores/cache-bytes", `{"cache_bytes": 0}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("zero cache bytes: expect 400, got %d", code)
+	}
+	limit := int64(len(testValue("1")) * 10)
+	var info GroupInfo
+	if code := do("secret", http.MethodPut, "/admin/groups/scores/cache-bytes", `{"cache_bytes": `+strconv.FormatInt(limit, 10)+`}`, &info); code != http.StatusOK {
+		t.Fatalf("resize: %d", code)
+	}
+	if info.CacheBytes != limit || info.Stats.Bytes > limit || info.Stats.Items >= numKeys-1 {
+		t.Fatalf("cache not shrunk: %+v", info)
+	}
+
+	if code := do("secret", http.MethodPost, "/admin/groups/scores/flush", "", nil); code != http.StatusNoContent {
+		t.Fatalf("flush: %d", code)
+	}
+	if stats := a.group.Stats(); stats.Items != 0 || stats.Bytes != 0 {
+		t.Fatalf("cache not flushed: %+v", stats)
+	}
+}
+
+func TestAdminRingOwnership(t *testing.T) {
+	for _, name := range []string{consistenthash.HashCRC32, consistenthash.HashFNV1a, consistenthash.HashXXHash64, consistenthash.HashMurmur3} {
+		hash, err := consistenthash.HashByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := NewCacheServer("http://10.0.0.1:8001", WithHash(hash), WithVirtualNodes(500))
+		p.AddPeers("http://10.0.0.1:8001", "http://10.0.0.2:8001", "http://10.0.0.3:8001")
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/admin/ring", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		NewAdminServer(p, "secret").ServeHTTP(recorder, req)
+		var ring struct {
+			Peers []PeerInfo `json:"peers"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &ring); err != nil || len(ring.Peers) != 3 {
+			t.Fatalf("%s: unexpected ring %s, %v", name, recorder.Body, err)
+		}
+		for _, peer := range ring.Peers {
+			if math.Abs(peer.Ownership*3-1) > 0.15 {
+				t.Errorf("%s: %s owns %v of the ring, want about 1/3", name, peer.ID, peer.Ownership)
+			}
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package network
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"geecache"
+	"geecache/util"
+	"hash/fnv"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+APIServer 是面向客户端的 REST API，与节点间通讯的 CacheServer 相互独立，可以挂载在任意的 http.ServeMux 上：
+
+	GET    /v1/groups/{group}/keys/{key}  读取 key（读穿透），支持 ETag / If-None-Match
+	HEAD   /v1/groups/{group}/keys/{key}  判断 key 是否存在
+	PUT    /v1/groups/{group}/keys/{key}  写入 key，请求体就是值（见 geecache.Group.Set）
+	DELETE /v1/groups/{group}/keys/{key}  让 key 在整个集群中失效（见 geecache.Group.Delete）
+	POST   /v1/groups/{group}/keys        批量读取，请求体是 {"keys": ["k1", "k2"]}
+
+出错时返回 JSON：{"error": "...", "status": 404}。
+配置了 WithAPIAuth 时，请求必须带上 Authorization: Bearer <token>，读取需要 group 的 read 权限，写入和删除需要 write 权限。
+*/
+
+const (
+	apiPrefix = "/v1/"
+	// 默认让客户端每次都用 ETag 重新验证
+	defaultCacheControl = "no-cache"
+	defaultMaxBodySize  = 8 << 20
+	defaultMaxBatchKeys = 1000
+)
+
+// APIServer is an http.Handler serving the client REST API.
+type APIServer struct {
+	mux          *http.ServeMux
+	groups       groupSet
+	cacheControl string
+	maxBodySize  int64
+	maxBatchKeys int
+	auth         *Auth
+}
+
+// APIOption 用于配置 APIServer
+type APIOption func(*APIServer)
+
+// WithAPIGroups 只提供 groups 中的 group，默认提供所有注册的 group
+func WithAPIGroups(groups ...*geecache.Group) APIOption {
+	return func(s *APIServer) {
+		s.groups = newGroupSet(groups)
+	}
+}
+
+// WithCacheControl 设置读取 key 时返回的 Cache-Control 头，默认是 no-cache
+func WithCacheControl(value string) APIOption {
+	return func(s *APIServer) {
+		s.cacheControl = value
+	}
+}
+
+// WithMaxBodySize 限制 PUT 和批量读取的请求体大小，默认 8MiB
+func WithMaxBodySize(n int64) APIOption {
+	return func(s *APIServer) {
+		s.maxBodySize = n
+	}
+}
+
+// WithMaxBatchKeys 限制一次批量读取的 key 个数，默认 1000
+func WithMaxBatchKeys(n int) APIOption {
+	return func(s *APIServer) {
+		s.maxBatchKeys = n
+	}
+}
+
+// WithAPIAuth 要求请求带有 a 中客户端的 bearer token，并按照客户端的 ACL 检查对 group 的权限
+func WithAPIAuth(a *Auth) APIOption {
+	return func(s *APIServer) {
+		s.auth = a
+	}
+}
+
+// NewAPIServer 创建 APIServer，应该挂载在 /v1/ 上：mux.Handle("/v1/", NewAPIServer())
+func NewAPIServer(opts ...APIOption) *APIServer {
+	s := &APIServer{
+		mux:          http.NewServeMux(),
+		cacheControl: defaultCacheControl,
+		maxBodySize:  defaultMaxBodySize,
+		maxBatchKeys: defaultMaxBatchKeys,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	// GET 的路由同时匹配 HEAD
+	s.mux.HandleFunc("GET /v1/groups/{group}/keys/{key...}", s.withGroup(PermRead, s.serveGet))
+	s.mux.HandleFunc("PUT /v1/groups/{group}/keys/{key...}", s.withGroup(PermWrite, s.servePut))
+	s.mux.HandleFunc("DELETE /v1/groups/{group}/keys/{key...}", s.withGroup(PermWrite, s.serveDelete))
+	s.mux.HandleFunc("POST /v1/groups/{group}/keys", s.withGroup(PermRead, s.serveBatch))
+	s.mux.HandleFunc(apiPrefix, func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, http.StatusNotFound, "no such route: "+r.Method+" "+r.URL.Path)
+	})
+	return s
+}
+
+func (s *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// apiError 是出错时返回的 JSON
+type apiError struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("[API] write response: %v", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, apiError{Error: msg, Status: status})
+}
+
+// errorStatus 把读取 key 时的错误转换成 HTTP 状态码
+func errorStatus(err error) int {
+	if errors.Is(err, geecache.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadGateway
+}
+
+// withGroup 检查客户端对路径中的 group 是否有 perm 权限，再找到 group，不存在时返回 404。
+// 先检查权限，避免没有权限的客户端通过 404 探测 group 是否存在。
+func (s *APIServer) withGroup(perm Permission, h func(w http.ResponseWriter, r *http.Request, g *geecache.Group)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("group")
+		g := s.groups.get(name)
+		if s.auth != nil {
+			if err := s.auth.authorizeToken(r, name, perm); err != nil {
+				if g != nil {
+					g.RecordUnauthorized()
+				}
+				setChallenge(w, err)
+				writeJSONError(w, authStatus(err), err.Error())
+				return
+			}
+		}
+		if g == nil {
+			writeJSONError(w, http.StatusNotFound, "no such group: "+name)
+			return
+		}
+		h(w, r, g)
+	}
+}
+
+// pathKey 返回路径中的 key，key 为空时返回 400
+func pathKey(w http.ResponseWriter, r *http.Request) (string, bool) {
+	key := r.PathValue("key")
+	if key == "" {
+		writeJSONError(w, http.StatusBadRequest, "key is required")
+		return "", false
+	}
+	return key, true
+}
+
+// etag 根据值的内容生成强 ETag
+func etag(value util.ByteView) string {
+	h := fnv.New64a()
+	value.WriteTo(h)
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
+
+// etagMatches 判断 If-None-Match 头是否包含 tag
+func etagMatches(header, tag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *APIServer) serveGet(w http.ResponseWriter, r *http.Request, g *geecache.Group) {
+	key, ok := pathKey(w, r)
+	if !ok {
+		return
+	}
+	view, err := g.Get(key)
+	if err != nil {
+		status := errorStatus(err)
+		if r.Method == http.MethodHead {
+			w.WriteHeader(status)
+			return
+		}
+		writeJSONError(w, status, err.Error())
+		return
+	}
+	tag := etag(view)
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Cache-Control", s.cacheControl)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(view.Size()))
+	if r.Method == http.MethodHead {
+		return
+	}
+	view.WriteTo(w)
+}
+
+func (s *APIServer) servePut(w http.ResponseWriter, r *http.Request, g *geecache.Group) {
+	key, ok := pathKey(w, r)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBodySize))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("value exceeds the limit of %d bytes", s.maxBodySize))
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := g.Set(key, body); err != nil {
+		writeJSONError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	w.Header().Set("ETag", etag(util.NewByteView(body, "")))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *APIServer) serveDelete(w http.ResponseWriter, r *http.Request, g *geecache.Group) {
+	key, ok := pathKey(w, r)
+	if !ok {
+		return
+	}
+	if err := g.Delete(key); err != nil {
+		writeJSONError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BatchRequest 是批量读取的请求体
+type BatchRequest struct {
+	Keys []string `json:"keys"`
+}
+
+// BatchResult 是批量读取中一个 key 的结果，Value 在 JSON 中是 base64 编码的
+type BatchResult struct {
+	Key    string `json:"key"`
+	Value  []byte `json:"value,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Status int    `json:"status"`
+}
+
+// BatchResponse 是批量读取的响应体，Results 与请求中的 Keys 一一对应
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+func (s *APIServer) serveBatch(w http.ResponseWriter, r *http.Request, g *geecache.Group) {
+	var req BatchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.maxBodySize)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "decoding request body: "+err.Error())
+		return
+	}
+	if len(req.Keys) > s.maxBatchKeys {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("too many keys: %d > %d", len(req.Keys), s.maxBatchKeys))
+		return
+	}
+	resp := BatchResponse{Results: make([]BatchResult, len(req.Keys))}
+	for i, key := range req.Keys {
+		resp.Results[i].Key = key
+		view, err := g.Get(key)
+		if err != nil {
+			resp.Results[i].Error = err.Error()
+			resp.Results[i].Status = errorStatus(err)
+			continue
+		}
+		resp.Results[i].Value = view.ByteSlice()
+		resp.Results[i].Status = http.StatusOK
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
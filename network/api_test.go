@@ -0,0 +1,114 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"geecache"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIServer(t *testing.T) {
+	db := map[string]string{"Tom": "630", "Jack": "589"}
+	group := newTestGroup(t, geecache.NewRegistry(), "api-scores", 1<<20, geecache.GetterFunc(func(key string) ([]byte, error) {
+		if key == "broken" {
+			return nil, fmt.Errorf("database unavailable")
+		}
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("%s: %w", key, geecache.ErrNotFound)
+	}))
+	mux := http.NewServeMux()
+	mux.Handle("/v1/", NewAPIServer(WithAPIGroups(group), WithCacheControl("max-age=60")))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	keyURL := func(key string) string {
+		return ts.URL + "/v1/groups/api-scores/keys/" + key
+	}
+
+	do := func(method, url string, body io.Reader, header ...string) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, url, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp, string(data)
+	}
+
+	resp, body := do(http.MethodGet, keyURL("Tom"), nil)
+	if resp.StatusCode != http.StatusOK || body != "630" || resp.Header.Get("Cache-Control") != "max-age=60" {
+		t.Fatalf("GET Tom: %d %q %v", resp.StatusCode, body, resp.Header)
+	}
+	tag := resp.Header.Get("ETag")
+	if resp, _ := do(http.MethodGet, keyURL("Tom"), nil, "If-None-Match", `"other", `+tag); resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected 304 for matching ETag, got %d", resp.StatusCode)
+	}
+
+	// 错误返回 JSON
+	for key, status := range map[string]int{"missing": http.StatusNotFound, "broken": http.StatusBadGateway} {
+		resp, body := do(http.MethodGet, keyURL(key), nil)
+		var apiErr apiError
+		if err := json.Unmarshal([]byte(body), &apiErr); err != nil || resp.StatusCode != status || apiErr.Status != status ||
+			resp.Header.Get("Content-Type") != "application/json" {
+			t.Fatalf("GET %s: %d %q", key, resp.StatusCode, body)
+		}
+	}
+	if resp, _ := do(http.MethodGet, ts.URL+"/v1/groups/nope/keys/Tom", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown group, got %d", resp.StatusCode)
+	}
+
+	if resp, body := do(http.MethodHead, keyURL("Jack"), nil); resp.StatusCode != http.StatusOK || body != "" || resp.ContentLength != 3 {
+		t.Fatalf("HEAD Jack: %d %q", resp.StatusCode, body)
+	}
+	if resp, _ := do(http.MethodHead, keyURL("missing"), nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("HEAD missing: %d", resp.StatusCode)
+	}
+
+	// PUT 之后 GET 读到新值，ETag 也随之改变
+	if resp, _ := do(http.MethodPut, keyURL("Tom"), strings.NewReader("700")); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT Tom: %d", resp.StatusCode)
+	}
+	if resp, body := do(http.MethodGet, keyURL("Tom"), nil, "If-None-Match", tag); resp.StatusCode != http.StatusOK || body != "700" {
+		t.Fatalf("GET Tom after PUT: %d %q", resp.StatusCode, body)
+	}
+	// DELETE 之后重新从数据源加载
+	if resp, _ := do(http.MethodDelete, keyURL("Tom"), nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE Tom: %d", resp.StatusCode)
+	}
+	if _, body := do(http.MethodGet, keyURL("Tom"), nil); body != "630" {
+		t.Fatalf("GET Tom after DELETE: %q", body)
+	}
+
+	resp, body = do(http.MethodPost, ts.URL+"/v1/groups/api-scores/keys", strings.NewReader(`{"keys":["Tom","missing","Jack"]}`))
+	var batch BatchResponse
+	if err := json.Unmarshal([]byte(body), &batch); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("batch: %d %q", resp.StatusCode, body)
+	}
+	want := []BatchResult{
+		{Key: "Tom", Value: []byte("630"), Status: http.StatusOK},
+		{Key: "missing", Error: "missing: " + geecache.ErrNotFound.Error(), Status: http.StatusNotFound},
+		{Key: "Jack", Value: []byte("589"), Status: http.StatusOK},
+	}
+	if fmt.Sprint(batch.Results) != fmt.Sprint(want) {
+		t.Fatalf("batch results: got %v, want %v", batch.Results, want)
+	}
+	if resp, _ := do(http.MethodPost, ts.URL+"/v1/groups/api-scores/keys", strings.NewReader(`{"keys":`)); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for bad batch body, got %d", resp.StatusCode)
+	}
+	if resp, _ := do(http.MethodGet, ts.URL+"/v1/other", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown route, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,321 @@
+package network
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Auth 是 HTTP 接口的认证和授权：
+
+  - 节点之间的请求用集群共享的密钥做 HMAC-SHA256 签名，签名覆盖方法、路径、时间戳、随机数和请求体的哈希。
+    时间戳与本地时间相差超过 replay window 的请求、以及 replay window 内重复出现的随机数都会被拒绝。
+  - 客户端（REST API、运维接口、geecachectl）使用 Authorization: Bearer <token>，
+    每个 token 对应一个 Client，按照 Client.Groups 中的 ACL 检查对 group 的读、写、运维权限。
+
+没有通过认证的请求返回 401，没有权限的请求返回 403，两者都计入 Stats 和对应 group 的 geecache.Stats.Unauthorized。
+*/
+
+const (
+	timestampHeader = "X-Geecache-Timestamp"
+	nonceHeader     = "X-Geecache-Nonce"
+	signatureHeader = "X-Geecache-Signature"
+	// 默认的 replay window
+	defaultReplayWindow = 30 * time.Second
+	// AllGroups 在 ACL 中匹配所有 group，运维接口中与具体 group 无关的操作需要 AllGroups 的 PermAdmin 权限
+	AllGroups = "*"
+)
+
+// Permission 是客户端对一个 group 的访问权限，可以按位组合
+type Permission uint8
+
+const (
+	// PermRead 允许读取 key
+	PermRead Permission = 1 << iota
+	// PermWrite 允许写入和删除 key
+	PermWrite
+	// PermAdmin 允许运维接口中的操作（清空缓存、修改容量、管理节点等），同时拥有读写权限
+	PermAdmin
+)
+
+var permissionNames = []string{"read", "write", "admin"}
+
+// ParsePermission 解析 "read"、"write"、"admin" 组成的权限列表
+func ParsePermission(names ...string) (Permission, error) {
+	var p Permission
+	for _, name := range names {
+		i := slices.Index(permissionNames, strings.TrimSpace(name))
+		if i < 0 {
+			return 0, fmt.Errorf("unknown permission %q, use read, write or admin", name)
+		}
+		p |= 1 << i
+	}
+	return p, nil
+}
+
+func (p Permission) String() string {
+	var names []string
+	for i, name := range permissionNames {
+		if p&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// has 判断 p 是否包含 want，PermAdmin 包含所有权限
+func (p Permission) has(want Permission) bool {
+	return p&PermAdmin != 0 || p&want == want
+}
+
+// Client 是一个使用 bearer token 认证的客户端
+type Client struct {
+	// 客户端的名称，用于日志
+	Name  string
+	Token string
+	// group 名称到权限的映射，AllGroups 匹配所有 group
+	Groups map[string]Permission
+}
+
+// allowed 判断客户端是否拥有 group 的 perm 权限
+func (c *Client) allowed(group string, perm Permission) bool {
+	p := c.Groups[AllGroups]
+	if group != AllGroups {
+		p |= c.Groups[group]
+	}
+	return p.has(perm)
+}
+
+// AuthStats 是被拒绝的请求的统计
+type AuthStats struct {
+	// 没有通过认证（401）的请求数
+	Unauthenticated int64 `json:"unauthenticated"`
+	// 没有权限（403）的请求数
+	Forbidden int64 `json:"forbidden"`
+}
+
+// Auth 认证节点间和客户端的 HTTP 请求，见文件开头的说明
+type Auth struct {
+	// 集群共享的密钥，为空时不接受也不发送签名
+	secret       []byte
+	replayWindow time.Duration
+	// token 的 SHA-256 到客户端的映射，查找时不直接比较 token
+	clients map[[sha256.Size]byte]*Client
+	// 返回当前时间，测试中可以替换
+	now func() time.Time
+
+	mtx sync.Mutex
+	// replay window 内见过的随机数及其过期时间
+	nonces    map[string]time.Time
+	lastPrune time.Time
+
+	unauthenticated atomic.Int64
+	forbidden       atomic.Int64
+}
+
+// AuthOption 用于配置 Auth
+type AuthOption func(*Auth)
+
+// WithClusterSecret 设置节点间请求签名使用的密钥，集群内所有节点必须相同
+func WithClusterSecret(secret []byte) AuthOption {
+	return func(a *Auth) {
+		a.secret = secret
+	}
+}
+
+// WithReplayWindow 设置签名的有效期，也是随机数去重的时间范围，默认 30s。节点之间的时钟误差必须小于它。
+func WithReplayWindow(d time.Duration) AuthOption {
+	return func(a *Auth) {
+		if d > 0 {
+			a.replayWindow = d
+		}
+	}
+}
+
+// WithClients 设置使用 bearer token 认证的客户端
+func WithClients(clients ...Client) AuthOption {
+	return func(a *Auth) {
+		for i := range clients {
+			a.clients[sha256.Sum256([]byte(clients[i].Token))] = &clients[i]
+		}
+	}
+}
+
+// NewAuth 创建 Auth。在 CacheServer 上使用 WithAuth，在 APIServer 上使用 WithAPIAuth。
+func NewAuth(opts ...AuthOption) *Auth {
+	a := &Auth{
+		replayWindow: defaultReplayWindow,
+		clients:      make(map[[sha256.Size]byte]*Client),
+		now:          time.Now,
+		nonces:       make(map[string]time.Time),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Stats 返回被拒绝的请求的统计
+func (a *Auth) Stats() AuthStats {
+	return AuthStats{Unauthenticated: a.unauthenticated.Load(), Forbidden: a.forbidden.Load()}
+}
+
+// authError 是认证或授权失败的原因
+type authError struct {
+	status int
+	msg    string
+}
+
+func (e *authError) Error() string {
+	return e.msg
+}
+
+var (
+	errNoCredentials  = &authError{http.StatusUnauthorized, "unauthorized"}
+	errBadSignature   = &authError{http.StatusUnauthorized, "invalid request signature"}
+	errStaleSignature = &authError{http.StatusUnauthorized, "request signature expired or replayed"}
+	errForbidden      = &authError{http.StatusForbidden, "forbidden"}
+)
+
+// authStatus 返回认证错误对应的 HTTP 状态码
+func authStatus(err error) int {
+	var ae *authError
+	if errors.As(err, &ae) {
+		return ae.status
+	}
+	return http.StatusUnauthorized
+}
+
+// setChallenge 在 401 响应中告诉客户端使用 bearer token
+func setChallenge(w http.ResponseWriter, err error) {
+	if authStatus(err) == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="geecache"`)
+	}
+}
+
+// reject 统计被拒绝的请求，返回 err
+func (a *Auth) reject(err error) error {
+	if authStatus(err) == http.StatusForbidden {
+		a.forbidden.Add(1)
+	} else {
+		a.unauthenticated.Add(1)
+	}
+	return err
+}
+
+// authorizeToken 用 bearer token 认证请求 r，并检查客户端是否拥有 group 的 perm 权限
+func (a *Auth) authorizeToken(r *http.Request, group string, perm Permission) error {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return a.reject(errNoCredentials)
+	}
+	client, ok := a.clients[sha256.Sum256([]byte(token))]
+	if !ok {
+		return a.reject(errNoCredentials)
+	}
+	if !client.allowed(group, perm) {
+		return a.reject(errForbidden)
+	}
+	return nil
+}
+
+// signed 判断请求是否带有节点间的签名
+func signed(r *http.Request) bool {
+	return r.Header.Get(signatureHeader) != ""
+}
+
+// signature 计算请求的签名
+func (a *Auth) signature(method, uri, timestamp, nonce string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, a.secret)
+	for _, part := range []string{method, uri, timestamp, nonce, hex.EncodeToString(bodyHash[:])} {
+		mac.Write([]byte(part))
+		mac.Write([]byte{'\n'})
+	}
+	return mac.Sum(nil)
+}
+
+// sign 给发往其他节点的请求签名，body 是请求体。没有配置密钥时不签名。
+func (a *Auth) sign(req *http.Request, body []byte) {
+	if a == nil || len(a.secret) == 0 {
+		return
+	}
+	var b [16]byte
+	rand.Read(b[:])
+	nonce := hex.EncodeToString(b[:])
+	timestamp := strconv.FormatInt(a.now().Unix(), 10)
+	req.Header.Set(timestampHeader, timestamp)
+	req.Header.Set(nonceHeader, nonce)
+	req.Header.Set(signatureHeader, hex.EncodeToString(a.signature(req.Method, req.URL.RequestURI(), timestamp, nonce, body)))
+}
+
+// verifySignature 检查节点间请求的签名。为了计算请求体的哈希，会读入完整的请求体并替换 r.Body。
+func (a *Auth) verifySignature(r *http.Request) error {
+	if len(a.secret) == 0 {
+		return a.reject(errBadSignature)
+	}
+	sig, err := hex.DecodeString(r.Header.Get(signatureHeader))
+	if err != nil {
+		return a.reject(errBadSignature)
+	}
+	timestamp, nonce := r.Header.Get(timestampHeader), r.Header.Get(nonceHeader)
+	// 调用者负责用 http.MaxBytesReader 限制请求体的大小，读取失败不计入被拒绝的请求
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &authError{http.StatusRequestEntityTooLarge, "request body too large"}
+		}
+		return &authError{http.StatusBadRequest, "reading request body: " + err.Error()}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if !hmac.Equal(sig, a.signature(r.Method, r.RequestURI, timestamp, nonce, body)) {
+		return a.reject(errBadSignature)
+	}
+	// 签名正确之后再检查时间戳和随机数，避免伪造的请求占满随机数表
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || nonce == "" {
+		return a.reject(errBadSignature)
+	}
+	now := a.now()
+	if ts := time.Unix(sec, 0); ts.Before(now.Add(-a.replayWindow)) || ts.After(now.Add(a.replayWindow)) {
+		return a.reject(errStaleSignature)
+	}
+	if !a.useNonce(nonce, now) {
+		return a.reject(errStaleSignature)
+	}
+	return nil
+}
+
+// useNonce 记录随机数，在 replay window 内重复出现时返回 false
+func (a *Auth) useNonce(nonce string, now time.Time) bool {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	// 时间戳最多比现在晚 replayWindow，随机数保存 2 倍的 replayWindow 才能覆盖它的整个有效期
+	if now.Sub(a.lastPrune) > a.replayWindow {
+		for n, expiry := range a.nonces {
+			if now.After(expiry) {
+				delete(a.nonces, n)
+			}
+		}
+		a.lastPrune = now
+	}
+	if _, seen := a.nonces[nonce]; seen {
+		return false
+	}
+	a.nonces[nonce] = now.Add(2 * a.replayWindow)
+	return true
+}
@@ -0,0 +1,302 @@
+package network
+
+import (
+	"bytes"
+	"geecache"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signedRequest 返回一个 a 签名过的、服务端收到的请求
+func signedRequest(t *testing.T, a *Auth, method, target, body string) *http.Request {
+	t.Helper()
+	out, err := http.NewRequest(method, "http://10.0.0.2:8001"+target, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.sign(out, []byte(body))
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header = out.Header
+	return r
+}
+
+func TestAuthSignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	a := NewAuth(WithClusterSecret([]byte("cluster secret")), WithReplayWindow(10*time.Second))
+	a.now = func() time.Time { return now }
+
+	target := defaultBasePath + "scores/Tom%2F1"
+	r := signedRequest(t, a, http.MethodPut, target, "value")
+	if err := a.verifySignature(r); err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+	// 请求体仍然可以读取
+	if body, _ := io.ReadAll(r.Body); string(body) != "value" {
+		t.Fatalf("request body = %q after verification", body)
+	}
+
+	// 同一个请求再发一次
+	replay := httptest.NewRequest(http.MethodPut, target, strings.NewReader("value"))
+	replay.Header = r.Header
+	if err := a.verifySignature(replay); err != errStaleSignature {
+		t.Fatalf("replayed request: %v", err)
+	}
+
+	// 修改请求体、路径或者使用其他密钥
+	r = signedRequest(t, a, http.MethodPut, target, "value")
+	tampered := httptest.NewRequest(http.MethodPut, target, strings.NewReader("other"))
+	tampered.Header = r.Header
+	if err := a.verifySignature(tampered); err != errBadSignature {
+		t.Fatalf("tampered body: %v", err)
+	}
+	r = signedRequest(t, a, http.MethodGet, target, "")
+	moved := httptest.NewRequest(http.MethodGet, defaultBasePath+"scores/Jack", nil)
+	moved.Header = r.Header
+	if err := a.verifySignature(moved); err != errBadSignature {
+		t.Fatalf("tampered path: %v", err)
+	}
+	other := NewAuth(WithClusterSecret([]byte("other secret")))
+	if err := a.verifySignature(signedRequest(t, other, http.MethodGet, target, "")); err != errBadSignature {
+		t.Fatalf("wrong secret: %v", err)
+	}
+
+	// 超出 replay window 的时间戳
+	r = signedRequest(t, a, http.MethodGet, target, "")
+	now = now.Add(11 * time.Second)
+	if err := a.verifySignature(r); err != errStaleSignature {
+		t.Fatalf("expired signature: %v", err)
+	}
+	now = now.Add(-22 * time.Second)
+	if err := a.verifySignature(r); err != errStaleSignature {
+		t.Fatalf("signature from the future: %v", err)
+	}
+
+	if st := a.Stats(); st.Unauthenticated != 6 || st.Forbidden != 0 {
+		t.Fatalf("stats = %+v", st)
+	}
+	// 过期的随机数会被清理
+	now = now.Add(time.Minute)
+	a.verifySignature(signedRequest(t, a, http.MethodGet, target, ""))
+	if len(a.nonces) != 1 {
+		t.Fatalf("%d nonces kept, expected expired ones to be pruned", len(a.nonces))
+	}
+}
+
+func TestAuthCluster(t *testing.T) {
+	auth := NewAuth(WithClusterSecret([]byte("cluster secret")), WithClients(
+		Client{Name: "reader", Token: "reader-token", Groups: map[string]Permission{"scores": PermRead}},
+		Client{Name: "ops", Token: "ops-token", Groups: map[string]Permission{AllGroups: PermAdmin}},
+	))
+	cluster := newTestCluster(t, 2, WithAuth(auth))
+	a := anyNode(cluster)
+
+	// 节点之间的请求带有签名
+	key := a.foreignKey()
+	if view, err := a.group.Get(key); err != nil || view.String() != "value-"+key {
+		t.Fatalf("Get(%s) = %q, %v", key, view.String(), err)
+	}
+	owner := cluster[a.server.peers.GetNodes(key, 1)[0]]
+	if a.loads.Load() != 0 || owner.loads.Load() != 1 {
+		t.Fatalf("expected %s to be loaded by its owner, loads a=%d owner=%d", key, a.loads.Load(), owner.loads.Load())
+	}
+	if err := a.server.Invalidate("scores", key); err != nil {
+		t.Fatalf("signed invalidate: %v", err)
+	}
+
+	do := func(method, token string) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, owner.ts.URL+defaultBasePath+"scores/"+key, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "" {
+			t.Fatalf("401 without WWW-Authenticate")
+		}
+		return resp.StatusCode
+	}
+	for _, tt := range []struct {
+		method, token string
+		want          int
+	}{
+		{http.MethodGet, "", http.StatusUnauthorized},
+		{http.MethodGet, "wrong-token", http.StatusUnauthorized},
+		{http.MethodGet, "reader-token", http.StatusOK},
+		{http.MethodDelete, "reader-token", http.StatusForbidden},
+		{http.MethodDelete, "ops-token", http.StatusNoContent},
+	} {
+		if got := do(tt.method, tt.token); got != tt.want {
+			t.Errorf("%s with token %q: got %d, want %d", tt.method, tt.token, got, tt.want)
+		}
+	}
+	if n := owner.group.Stats().Unauthorized; n != 3 {
+		t.Fatalf("owner counted %d unauthorized requests, want 3", n)
+	}
+
+	// 密钥不同的节点不能访问集群，读取失败后从数据源加载
+	intruder := newTestNode(t, WithAuth(NewAuth(WithClusterSecret([]byte("guess")))))
+	intruder.server.AddPeers(intruder.id(), owner.id())
+	if view, err := intruder.group.Get(key); err != nil || view.String() != "value-"+key {
+		t.Fatalf("Get(%s) = %q, %v", key, view.String(), err)
+	}
+	if intruder.loads.Load() != 1 || owner.loads.Load() != 2 {
+		t.Fatalf("intruder should load %s itself, loads intruder=%d owner=%d", key, intruder.loads.Load(), owner.loads.Load())
+	}
+}
+
+func TestAPIAuth(t *testing.T) {
+	auth := NewAuth(WithClients(
+		Client{Name: "reader", Token: "reader-token", Groups: map[string]Permission{"auth-scores": PermRead}},
+		Client{Name: "writer", Token: "writer-token", Groups: map[string]Permission{AllGroups: PermRead | PermWrite}},
+	))
+	group := newTestGroup(t, geecache.NewRegistry(), "auth-scores", 1<<20, geecache.GetterFunc(func(key string) ([]byte, error) {
+		return testValue(key), nil
+	}))
+	api := NewAPIServer(WithAPIGroups(group), WithAPIAuth(auth))
+
+	do := func(method, path, token string, body []byte) int {
+		t.Helper()
+		r := httptest.NewRequest(method, path, bytes.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, r)
+		return w.Code
+	}
+	const keyPath = "/v1/groups/auth-scores/keys/Tom"
+	for _, tt := range []struct {
+		method, path, token string
+		want                int
+	}{
+		{http.MethodGet, keyPath, "", http.StatusUnauthorized},
+		{http.MethodGet, keyPath, "reader-token", http.StatusOK},
+		{http.MethodPost, "/v1/groups/auth-scores/keys", "reader-token", http.StatusOK},
+		{http.MethodPut, keyPath, "reader-token", http.StatusForbidden},
+		{http.MethodPut, keyPath, "writer-token", http.StatusNoContent},
+		{http.MethodDelete, keyPath, "writer-token", http.StatusNoContent},
+		// 没有权限时不暴露 group 是否存在
+		{http.MethodGet, "/v1/groups/other/keys/Tom", "reader-token", http.StatusForbidden},
+		{http.MethodGet, "/v1/groups/other/keys/Tom", "writer-token", http.StatusNotFound},
+	} {
+		var body []byte
+		if tt.method == http.MethodPost {
+			body = []byte(`{"keys": ["Tom"]}`)
+		}
+		if got := do(tt.method, tt.path, tt.token, body); got != tt.want {
+			t.Errorf("%s %s with token %q: got %d, want %d", tt.method, tt.path, tt.token, got, tt.want)
+		}
+	}
+	if n := group.Stats().Unauthorized; n != 2 {
+		t.Fatalf("group counted %d unauthorized requests, want 2", n)
+	}
+	if st := auth.Stats(); st.Unauthenticated != 1 || st.Forbidden != 2 {
+		t.Fatalf("auth stats = %+v", st)
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	auth := NewAuth(WithClients(
+		Client{Name: "scores-ops", Token: "scores-token", Groups: map[string]Permission{"scores": PermAdmin}},
+		Client{Name: "reader", Token: "reader-token", Groups: map[string]Permission{AllGroups: PermRead}},
+	))
+	n := newTestNode(t, WithAuth(auth))
+	admin := NewAdminServer(n.server, "legacy-token")
+	do := func(method, path, token string) int {
+		t.Helper()
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, r)
+		return w.Code
+	}
+	for _, tt := range []struct {
+		method, path, token string
+		want                int
+	}{
+		{http.MethodGet, "/admin/peers", "legacy-token", http.StatusOK},
+		{http.MethodGet, "/admin/peers", "unknown", http.StatusUnauthorized},
+		{http.MethodGet, "/admin/peers", "scores-token", http.StatusForbidden},
+		{http.MethodPost, "/admin/groups/scores/flush", "scores-token", http.StatusNoContent},
+		{http.MethodPost, "/admin/groups/scores/flush", "reader-token", http.StatusForbidden},
+	} {
+		if got := do(tt.method, tt.path, tt.token); got != tt.want {
+			t.Errorf("%s %s with token %q: got %d, want %d", tt.method, tt.path, tt.token, got, tt.want)
+		}
+	}
+	if got := n.group.Stats().Unauthorized; got != 1 {
+		t.Fatalf("group counted %d unauthorized requests, want 1", got)
+	}
+}
+
+func TestParsePermission(t *testing.T) {
+	p, err := ParsePermission("read", " write")
+	if err != nil || p != PermRead|PermWrite || p.String() != "read,write" {
+		t.Fatalf("ParsePermission = %v, %v", p, err)
+	}
+	if p.has(PermAdmin) || !PermAdmin.has(PermWrite) {
+		t.Fatalf("admin should imply every permission, and nothing else should imply admin")
+	}
+	if _, err := ParsePermission("delete"); err == nil {
+		t.Fatalf("expected an error for an unknown permission")
+	}
+}
+
+// zeros 是无限长的全零数据
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestAuthOversizedBody(t *testing.T) {
+	auth := NewAuth(WithClusterSecret([]byte("cluster secret")))
+	n := newTestNode(t, WithAuth(auth))
+	n.server.AddPeers(n.id())
+
+	// 伪造签名的请求在验证签名之前就因为请求体过大被拒绝，节点不会缓存完整的请求体
+	for _, path := range []string{"scores/Tom", "scores/", leavePath} {
+		method := http.MethodPut
+		if path != "scores/Tom" {
+			method = http.MethodPost
+		}
+		size := n.server.maxRequestBody() + 1
+		req, _ := http.NewRequest(method, n.ts.URL+defaultBasePath+path, io.LimitReader(zeros{}, size))
+		req.ContentLength = size
+		req.Header.Set(signatureHeader, "00")
+		req.Header.Set(timestampHeader, "0")
+		req.Header.Set(nonceHeader, "0")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s %s: got %d, want 413", method, path, resp.StatusCode)
+		}
+	}
+	if st := auth.Stats(); st.Unauthenticated != 0 {
+		t.Fatalf("oversized bodies should not be counted as authentication failures, stats = %+v", st)
+	}
+
+	// 不需要认证时，写入和迁移数据的请求体同样受限
+	open := newTestNode(t)
+	size := open.server.maxRequestBody() + 1
+	resp, err := http.Post(open.ts.URL+defaultBasePath+"scores/", "application/octet-stream", io.LimitReader(zeros{}, size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("unsigned oversized transfer: got %d, want 413", resp.StatusCode)
+	}
+}
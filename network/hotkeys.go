@@ -0,0 +1,47 @@
+package network
+
+import (
+	"sync"
+	"time"
+)
+
+// hotKeys 统计每个时间窗口内各个 key 被转发到对端的次数。
+// 超过阈值的 key 被认为是热点 key，它的读请求会轮流分散到所有副本上，而不是全部打到 owner。
+type hotKeys struct {
+	sync.Mutex
+	// 一个窗口内转发次数达到 threshold 即为热点 key，<= 0 表示不识别热点 key
+	threshold int
+	window    time.Duration
+	start     time.Time
+	counts    map[string]int
+	// 轮询计数器
+	next int
+}
+
+func newHotKeys(threshold int, window time.Duration) *hotKeys {
+	return &hotKeys{
+		threshold: threshold,
+		window:    window,
+		counts:    make(map[string]int),
+	}
+}
+
+// offset 记录一次对 key 的访问，如果 key 是热点 key，返回本次应该从第几个副本开始尝试
+func (h *hotKeys) offset(key string) int {
+	if h.threshold <= 0 {
+		return 0
+	}
+	h.Lock()
+	defer h.Unlock()
+	// 每个窗口开始时清空计数，避免 map 无限增长
+	if now := time.Now(); now.Sub(h.start) > h.window {
+		h.start = now
+		clear(h.counts)
+	}
+	h.counts[key]++
+	if h.counts[key] < h.threshold {
+		return 0
+	}
+	h.next++
+	return h.next
+}
@@ -0,0 +1,472 @@
+package network
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"geecache"
+	"geecache/util"
+	"hash/fnv"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+MemcacheServer 是一个兼容 memcached 协议的 TCP 服务，使用 memcached 客户端的旧服务不需要修改代码就可以读取 geecache 中的数据。
+同一个端口同时支持文本协议和二进制协议，根据连接的第一个字节区分。
+
+文本协议支持 get、gets、delete、version、stats、quit；二进制协议支持 Get、GetQ、GetK、GetKQ、Delete、Version、Noop、Stat、Quit、QuitQ。
+memcached 没有 db 的概念，key 写成 "group:key" 的形式时使用指定的 group，否则使用默认 group。
+get 是读穿透（read-through）的；delete 让 key 在整个集群中失效（见 geecache.Group.Delete），不会删除数据源中的数据，
+本节点的缓存中没有 key 时返回 NOT_FOUND（二进制协议返回 Key not found）。
+*/
+
+const (
+	memcacheVersion = "1.6.0-geecache"
+	// memcached 的 key 最长 250 字节
+	memcacheMaxKeyLen = 250
+	memcacheMaxLine   = 64 << 10
+)
+
+// MemcacheServer serves geecache groups over the memcached protocol.
+type MemcacheServer struct {
+	tcp    tcpServer
+	groups groupSet
+	// key 中没有指定 group 时使用的 group
+	defaultGroup *geecache.Group
+	started      time.Time
+
+	cmdGet    atomic.Int64
+	getHits   atomic.Int64
+	getMisses atomic.Int64
+}
+
+// NewMemcacheServer 创建一个 MemcacheServer，只提供 groups 中的 group，第一个 group 是默认 group；
+// groups 为空时提供所有注册的 group，按名称排序的第一个 group 是默认 group。
+func NewMemcacheServer(groups ...*geecache.Group) *MemcacheServer {
+	s := &MemcacheServer{groups: newGroupSet(groups), started: time.Now()}
+	if len(groups) > 0 {
+		s.defaultGroup = groups[0]
+	}
+	return s
+}
+
+// ListenAndServe 监听 TCP 地址 addr 并处理连接
+func (s *MemcacheServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve 接受 l 上的连接并处理，直到 Close 被调用
+func (s *MemcacheServer) Serve(l net.Listener) error {
+	return s.tcp.serve(l, s.serveConn)
+}
+
+// Close 停止监听并关闭所有连接
+func (s *MemcacheServer) Close() error {
+	return s.tcp.close()
+}
+
+func (s *MemcacheServer) resolve(key string) (*geecache.Group, string, bool) {
+	def := s.defaultGroup
+	if def == nil {
+		if groups := s.groups.list(); len(groups) > 0 {
+			def = groups[0]
+		}
+	}
+	return s.groups.resolveKey(key, def)
+}
+
+// get 读取 key，key 不存在或者加载失败时返回 false
+func (s *MemcacheServer) get(key string) (util.ByteView, bool) {
+	s.cmdGet.Add(1)
+	g, k, ok := s.resolve(key)
+	if !ok {
+		s.getMisses.Add(1)
+		return util.ByteView{}, false
+	}
+	view, err := g.Get(k)
+	if err != nil {
+		log.Printf("[Memcache] get %s/%s: %v", g.Name(), k, err)
+		s.getMisses.Add(1)
+		return util.ByteView{}, false
+	}
+	s.getHits.Add(1)
+	return view, true
+}
+
+// delete 让 key 在整个集群中失效，返回本节点的缓存中是否有 key。
+// 与 RESP 的 DEL 相同，即使本节点没有 key 也会通知其他节点，key 可能缓存在它的 owner 上。
+func (s *MemcacheServer) delete(key string) (bool, error) {
+	g, k, ok := s.resolve(key)
+	if !ok {
+		return false, fmt.Errorf("no group for key %s", key)
+	}
+	found := g.Contains(k)
+	return found, g.Delete(k)
+}
+
+// casUnique 用值的哈希作为 gets 返回的 cas，值不变时 cas 也不变
+func casUnique(value util.ByteView) uint64 {
+	h := fnv.New64a()
+	value.WriteTo(h)
+	return h.Sum64()
+}
+
+// stats 返回 stats 命令的统计项，memcached 的标准统计项由各个 group 的计数器汇总而来
+func (s *MemcacheServer) stats() [][2]string {
+	var total geecache.Stats
+	groups := s.groups.list()
+	for _, g := range groups {
+		st := g.Stats()
+		total.Items += st.Items
+		total.Bytes += st.Bytes
+		total.Gets += st.Gets
+		total.CacheHits += st.CacheHits
+		total.Loads += st.Loads
+		total.PeerLoads += st.PeerLoads
+		total.LocalLoads += st.LocalLoads
+		total.Deletes += st.Deletes
+	}
+	now := time.Now()
+	itoa := func(n int64) string { return strconv.FormatInt(n, 10) }
+	return [][2]string{
+		{"pid", strconv.Itoa(os.Getpid())},
+		{"uptime", itoa(int64(now.Sub(s.started).Seconds()))},
+		{"time", itoa(now.Unix())},
+		{"version", memcacheVersion},
+		{"curr_connections", strconv.Itoa(s.tcp.currConnections())},
+		{"total_connections", itoa(s.tcp.connections.Load())},
+		{"cmd_get", itoa(s.cmdGet.Load())},
+		{"get_hits", itoa(s.getHits.Load())},
+		{"get_misses", itoa(s.getMisses.Load())},
+		{"delete_hits", itoa(total.Deletes)},
+		{"curr_items", itoa(total.Items)},
+		{"bytes", itoa(total.Bytes)},
+		{"geecache_groups", strconv.Itoa(len(groups))},
+		{"geecache_gets", itoa(total.Gets)},
+		{"geecache_cache_hits", itoa(total.CacheHits)},
+		{"geecache_loads", itoa(total.Loads)},
+		{"geecache_peer_loads", itoa(total.PeerLoads)},
+		{"geecache_local_loads", itoa(total.LocalLoads)},
+	}
+}
+
+func (s *MemcacheServer) serveConn(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	first, err := r.Peek(1)
+	if err != nil {
+		return
+	}
+	if first[0] == binReqMagic {
+		s.serveBinary(r, w)
+	} else {
+		s.serveText(r, w)
+	}
+}
+
+// validKey 检查文本协议中的 key：不超过 250 字节，不包含空白和控制字符
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > memcacheMaxKeyLen {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *MemcacheServer) serveText(r *bufio.Reader, w *bufio.Writer) {
+	for {
+		line, err := readTextLine(r)
+		if err != nil {
+			if err == bufio.ErrBufferFull {
+				w.WriteString("CLIENT_ERROR line too long\r\n")
+				w.Flush()
+			}
+			return
+		}
+		quit := s.execText(strings.Fields(line), w)
+		if r.Buffered() == 0 || quit {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+func readTextLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line = append(line, chunk...)
+		if len(line) > memcacheMaxLine {
+			return "", bufio.ErrBufferFull
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+// execText 执行一条文本协议命令，返回是否应该关闭连接
+func (s *MemcacheServer) execText(args []string, w *bufio.Writer) (quit bool) {
+	if len(args) == 0 {
+		w.WriteString("ERROR\r\n")
+		return
+	}
+	switch cmd := args[0]; cmd {
+	case "get", "gets":
+		if len(args) < 2 {
+			w.WriteString("ERROR\r\n")
+			return
+		}
+		for _, key := range args[1:] {
+			if !validKey(key) {
+				w.WriteString("CLIENT_ERROR bad command line format\r\n")
+				return
+			}
+		}
+		for _, key := range args[1:] {
+			value, ok := s.get(key)
+			if !ok {
+				continue
+			}
+			if cmd == "gets" {
+				fmt.Fprintf(w, "VALUE %s 0 %d %d\r\n", key, value.Size(), casUnique(value))
+			} else {
+				fmt.Fprintf(w, "VALUE %s 0 %d\r\n", key, value.Size())
+			}
+			value.WriteTo(w)
+			w.WriteString("\r\n")
+		}
+		w.WriteString("END\r\n")
+	case "delete":
+		// delete <key> [0] [noreply]，旧版本的客户端会带上时间参数 0
+		noreply := args[len(args)-1] == "noreply"
+		if noreply {
+			args = args[:len(args)-1]
+		}
+		if len(args) < 2 || len(args) > 3 || len(args) == 3 && args[2] != "0" || !validKey(args[1]) {
+			w.WriteString("CLIENT_ERROR bad command line format\r\n")
+			return
+		}
+		found, err := s.delete(args[1])
+		if noreply {
+			return
+		}
+		switch {
+		case err != nil:
+			w.WriteString("SERVER_ERROR " + err.Error() + "\r\n")
+		case !found:
+			w.WriteString("NOT_FOUND\r\n")
+		default:
+			w.WriteString("DELETED\r\n")
+		}
+	case "version":
+		w.WriteString("VERSION " + memcacheVersion + "\r\n")
+	case "stats":
+		if len(args) > 1 {
+			// 不支持 stats items、stats slabs 等子命令，返回空结果
+			w.WriteString("END\r\n")
+			return
+		}
+		for _, st := range s.stats() {
+			w.WriteString("STAT " + st[0] + " " + st[1] + "\r\n")
+		}
+		w.WriteString("END\r\n")
+	case "quit":
+		return true
+	default:
+		w.WriteString("ERROR\r\n")
+	}
+	return
+}
+
+// 二进制协议，见 https://github.com/memcached/memcached/wiki/BinaryProtocolRevamped
+const (
+	binReqMagic = 0x80
+	binResMagic = 0x81
+
+	binHeaderLen = 24
+
+	opGet     = 0x00
+	opDelete  = 0x04
+	opQuit    = 0x07
+	opGetQ    = 0x09
+	opNoop    = 0x0a
+	opVersion = 0x0b
+	opGetK    = 0x0c
+	opGetKQ   = 0x0d
+	opStat    = 0x10
+	opQuitQ   = 0x17
+
+	statusOK             = 0x0000
+	statusKeyNotFound    = 0x0001
+	statusInvalidArgs    = 0x0004
+	statusUnknownCommand = 0x0081
+	statusInternalError  = 0x0084
+)
+
+// binHeader 是二进制协议的请求头和响应头
+type binHeader struct {
+	magic    byte
+	opcode   byte
+	keyLen   uint16
+	extLen   byte
+	status   uint16 // 请求中是 vbucket id
+	bodyLen  uint32
+	opaque   uint32
+	cas      uint64
+	dataType byte
+}
+
+func readBinHeader(r io.Reader) (binHeader, error) {
+	var b [binHeaderLen]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return binHeader{}, err
+	}
+	return binHeader{
+		magic:    b[0],
+		opcode:   b[1],
+		keyLen:   binary.BigEndian.Uint16(b[2:4]),
+		extLen:   b[4],
+		dataType: b[5],
+		status:   binary.BigEndian.Uint16(b[6:8]),
+		bodyLen:  binary.BigEndian.Uint32(b[8:12]),
+		opaque:   binary.BigEndian.Uint32(b[12:16]),
+		cas:      binary.BigEndian.Uint64(b[16:24]),
+	}, nil
+}
+
+// writeBinResponse 写入一个响应，extras、key、value 都可以为空
+func writeBinResponse(w io.Writer, req binHeader, status uint16, cas uint64, extras, key, value []byte) error {
+	return writeBinValue(w, req, status, cas, extras, key, util.NewByteView(value, ""))
+}
+
+// writeBinValue 与 writeBinResponse 相同，值直接从 ByteView 写出，不拷贝
+func writeBinValue(w io.Writer, req binHeader, status uint16, cas uint64, extras, key []byte, value util.ByteView) error {
+	var b [binHeaderLen]byte
+	b[0] = binResMagic
+	b[1] = req.opcode
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(key)))
+	b[4] = byte(len(extras))
+	binary.BigEndian.PutUint16(b[6:8], status)
+	binary.BigEndian.PutUint32(b[8:12], uint32(len(extras)+len(key)+value.Size()))
+	binary.BigEndian.PutUint32(b[12:16], req.opaque)
+	binary.BigEndian.PutUint64(b[16:24], cas)
+	for _, p := range [][]byte{b[:], extras, key} {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+	_, err := value.WriteTo(w)
+	return err
+}
+
+func (s *MemcacheServer) serveBinary(r *bufio.Reader, w *bufio.Writer) {
+	for {
+		req, err := readBinHeader(r)
+		if err != nil {
+			return
+		}
+		if req.magic != binReqMagic || uint32(req.extLen)+uint32(req.keyLen) > req.bodyLen ||
+			req.bodyLen > memcacheMaxLine {
+			// 请求格式错误，无法继续解析后面的请求
+			writeBinResponse(w, req, statusInvalidArgs, 0, nil, nil, []byte("Invalid arguments"))
+			w.Flush()
+			return
+		}
+		body := make([]byte, req.bodyLen)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+		key := body[req.extLen : int(req.extLen)+int(req.keyLen)]
+		quit := s.execBinary(req, string(key), w)
+		if r.Buffered() == 0 || quit {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// execBinary 执行一条二进制协议命令，返回是否应该关闭连接
+func (s *MemcacheServer) execBinary(req binHeader, key string, w io.Writer) (quit bool) {
+	switch req.opcode {
+	case opGet, opGetQ, opGetK, opGetKQ:
+		if len(key) == 0 || len(key) > memcacheMaxKeyLen {
+			writeBinResponse(w, req, statusInvalidArgs, 0, nil, nil, []byte("Invalid arguments"))
+			return
+		}
+		withKey := req.opcode == opGetK || req.opcode == opGetKQ
+		quiet := req.opcode == opGetQ || req.opcode == opGetKQ
+		var respKey []byte
+		if withKey {
+			respKey = []byte(key)
+		}
+		value, ok := s.get(key)
+		if !ok {
+			// quiet 的 get 未命中时不返回响应
+			if !quiet {
+				writeBinResponse(w, req, statusKeyNotFound, 0, nil, respKey, []byte("Not found"))
+			}
+			return
+		}
+		flags := make([]byte, 4)
+		writeBinValue(w, req, statusOK, casUnique(value), flags, respKey, value)
+	case opDelete:
+		if len(key) == 0 || len(key) > memcacheMaxKeyLen {
+			writeBinResponse(w, req, statusInvalidArgs, 0, nil, nil, []byte("Invalid arguments"))
+			return
+		}
+		found, err := s.delete(key)
+		switch {
+		case err != nil:
+			writeBinResponse(w, req, statusInternalError, 0, nil, nil, []byte(err.Error()))
+		case !found:
+			writeBinResponse(w, req, statusKeyNotFound, 0, nil, nil, []byte("Not found"))
+		default:
+			writeBinResponse(w, req, statusOK, 0, nil, nil, nil)
+		}
+	case opNoop:
+		writeBinResponse(w, req, statusOK, 0, nil, nil, nil)
+	case opVersion:
+		writeBinResponse(w, req, statusOK, 0, nil, nil, []byte(memcacheVersion))
+	case opStat:
+		// 每个统计项一个响应，最后以 key 为空的响应结束
+		for _, st := range s.stats() {
+			writeBinResponse(w, req, statusOK, 0, nil, []byte(st[0]), []byte(st[1]))
+		}
+		writeBinResponse(w, req, statusOK, 0, nil, nil, nil)
+	case opQuit:
+		writeBinResponse(w, req, statusOK, 0, nil, nil, nil)
+		return true
+	case opQuitQ:
+		return true
+	default:
+		writeBinResponse(w, req, statusUnknownCommand, 0, nil, nil, []byte("Unknown command"))
+	}
+	return
+}
@@ -0,0 +1,209 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"geecache"
+	"geecache/util"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func startMemcache(t *testing.T) (string, *geecache.Group) {
+	getter := func(prefix string) geecache.GetterFunc {
+		return func(key string) ([]byte, error) {
+			if key == "missing" {
+				return nil, fmt.Errorf("%s not exist", key)
+			}
+			return []byte(prefix + key), nil
+		}
+	}
+	registry := geecache.NewRegistry()
+	scores := newTestGroup(t, registry, "mc-scores", 1<<20, getter("score-"))
+	users := newTestGroup(t, registry, "mc-users", 1<<20, getter("user-"))
+	server := NewMemcacheServer(scores, users)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+	return l.Addr().String(), scores
+}
+
+func TestMemcacheText(t *testing.T) {
+	addr, scores := startMemcache(t)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	// 按脚本发送命令，检查返回的每一行
+	script := []struct {
+		cmd  string
+		want []string
+	}{
+		{"version", []string{"VERSION " + memcacheVersion}},
+		{"get Tom", []string{"VALUE Tom 0 9", "score-Tom", "END"}},
+		{"get Tom missing mc-users:Jack", []string{"VALUE Tom 0 9", "score-Tom", "VALUE mc-users:Jack 0 9", "user-Jack", "END"}},
+		{"get missing", []string{"END"}},
+		{"gets Sam", []string{fmt.Sprintf("VALUE Sam 0 9 %d", casUnique(util.NewByteView([]byte("score-Sam"), ""))), "score-Sam", "END"}},
+		{"delete Tom", []string{"DELETED"}},
+		{"delete Tom", []string{"NOT_FOUND"}},
+		{"delete never-loaded 0", []string{"NOT_FOUND"}},
+		{"delete Sam noreply", nil},
+		{"get " + strings.Repeat("k", 251), []string{"CLIENT_ERROR bad command line format"}},
+		{"set Tom 0 0 3", []string{"ERROR"}},
+	}
+	for _, step := range script {
+		fmt.Fprintf(conn, "%s\r\n", step.cmd)
+		for _, want := range step.want {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSuffix(line, "\r\n"); got != want {
+				t.Fatalf("%s: got %q, want %q", step.cmd, got, want)
+			}
+		}
+	}
+	// 本节点没有的 key 同样会在整个集群中失效
+	if st := scores.Stats(); st.Deletes != 4 || st.Items != 0 {
+		t.Fatalf("expected Tom and Sam to be deleted: %+v", st)
+	}
+
+	fmt.Fprintf(conn, "stats\r\n")
+	stats := make(map[string]string)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line = strings.TrimSuffix(line, "\r\n"); line == "END" {
+			break
+		}
+		fields := strings.Fields(line)
+		stats[fields[1]] = fields[2]
+	}
+	if stats["cmd_get"] != "6" || stats["get_hits"] != "4" || stats["get_misses"] != "2" ||
+		stats["curr_connections"] != "1" || stats["version"] != memcacheVersion {
+		t.Fatalf("unexpected stats: %v", stats)
+	}
+
+	fmt.Fprintf(conn, "quit\r\n")
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Fatalf("expected connection to be closed after quit, got %v", err)
+	}
+}
+
+// binRequest 构造一个二进制协议的请求
+func binRequest(opcode byte, opaque uint32, key string) []byte {
+	b := make([]byte, binHeaderLen, binHeaderLen+len(key))
+	b[0] = binReqMagic
+	b[1] = opcode
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(key)))
+	binary.BigEndian.PutUint32(b[8:12], uint32(len(key)))
+	binary.BigEndian.PutUint32(b[12:16], opaque)
+	return append(b, key...)
+}
+
+type binResponse struct {
+	binHeader
+	extras, key, value []byte
+}
+
+func readBinResponse(t *testing.T, r io.Reader) binResponse {
+	t.Helper()
+	h, err := readBinHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.magic != binResMagic {
+		t.Fatalf("bad magic %#x", h.magic)
+	}
+	body := make([]byte, h.bodyLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		t.Fatal(err)
+	}
+	return binResponse{h, body[:h.extLen], body[h.extLen : int(h.extLen)+int(h.keyLen)], body[int(h.extLen)+int(h.keyLen):]}
+}
+
+func TestMemcacheBinary(t *testing.T) {
+	addr, _ := startMemcache(t)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	// 客户端 multi-get 的典型做法：若干个 GetKQ 之后跟一个 Noop，未命中的 key 没有响应
+	var req bytes.Buffer
+	req.Write(binRequest(opGetKQ, 1, "Tom"))
+	req.Write(binRequest(opGetKQ, 2, "missing"))
+	req.Write(binRequest(opGetKQ, 3, "mc-users:Jack"))
+	req.Write(binRequest(opNoop, 4, ""))
+	conn.Write(req.Bytes())
+	for _, want := range []struct {
+		opaque     uint32
+		key, value string
+	}{{1, "Tom", "score-Tom"}, {3, "mc-users:Jack", "user-Jack"}, {4, "", ""}} {
+		resp := readBinResponse(t, r)
+		if resp.opaque != want.opaque || resp.status != statusOK || string(resp.key) != want.key || string(resp.value) != want.value {
+			t.Fatalf("got opaque=%d status=%d key=%q value=%q, want %+v", resp.opaque, resp.status, resp.key, resp.value, want)
+		}
+	}
+
+	conn.Write(binRequest(opGet, 5, "missing"))
+	if resp := readBinResponse(t, r); resp.status != statusKeyNotFound {
+		t.Fatalf("expected key not found, got status %d", resp.status)
+	}
+	conn.Write(binRequest(opGet, 6, "Sam"))
+	if resp := readBinResponse(t, r); resp.status != statusOK || len(resp.extras) != 4 || len(resp.key) != 0 ||
+		string(resp.value) != "score-Sam" || resp.cas != casUnique(util.NewByteView(resp.value, "")) {
+		t.Fatalf("unexpected get response: %+v", resp)
+	}
+	conn.Write(binRequest(opDelete, 7, "Sam"))
+	if resp := readBinResponse(t, r); resp.status != statusOK {
+		t.Fatalf("delete failed with status %d", resp.status)
+	}
+	conn.Write(binRequest(opDelete, 7, "Sam"))
+	if resp := readBinResponse(t, r); resp.status != statusKeyNotFound {
+		t.Fatalf("expected key not found after delete, got status %d", resp.status)
+	}
+	conn.Write(binRequest(opVersion, 8, ""))
+	if resp := readBinResponse(t, r); string(resp.value) != memcacheVersion {
+		t.Fatalf("unexpected version %q", resp.value)
+	}
+	conn.Write(binRequest(0x01, 9, "Tom")) // Set
+	if resp := readBinResponse(t, r); resp.status != statusUnknownCommand {
+		t.Fatalf("expected unknown command, got status %d", resp.status)
+	}
+
+	conn.Write(binRequest(opStat, 10, ""))
+	stats := make(map[string]string)
+	for {
+		resp := readBinResponse(t, r)
+		if len(resp.key) == 0 {
+			break
+		}
+		stats[string(resp.key)] = string(resp.value)
+	}
+	if stats["cmd_get"] != "5" || stats["get_hits"] != "3" {
+		t.Fatalf("unexpected stats: %v", stats)
+	}
+
+	conn.Write(binRequest(opQuit, 11, ""))
+	if resp := readBinResponse(t, r); resp.opaque != 11 {
+		t.Fatalf("unexpected quit response: %+v", resp)
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Fatalf("expected connection to be closed after quit, got %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package network
+
+import (
+	"geecache"
+	"geecache/consistenthash"
+)
+
+// Option 用于配置 CacheServer
+type Option func(*CacheServer)
+
+// WithHash 设置一致性哈希使用的哈希函数，集群内所有节点必须使用相同的哈希函数。
+// 默认是 consistenthash.CRC32，与旧版本的节点兼容；新集群建议使用 consistenthash.XXHash64，短 key 的分布更均匀。
+func WithHash(fn consistenthash.Hash) Option {
+	return func(p *CacheServer) {
+		p.hasher = fn
+	}
+}
+
+// WithVirtualNodes 设置每个节点在哈希环上的虚拟节点个数，默认是 defaultReplicas
+func WithVirtualNodes(n int) Option {
+	return func(p *CacheServer) {
+		p.virtualNodes = n
+	}
+}
+
+// WithGroups 限定 CacheServer 只对外提供这些 group，而不是 geecache 全局注册的所有 group。
+// 同一个进程里运行多个节点（例如测试）时，可以让每个节点拥有各自独立的 group。
+func WithGroups(groups ...*geecache.Group) Option {
+	return func(p *CacheServer) {
+		p.groups = make(groupSet, len(groups))
+		for _, g := range groups {
+			p.groups[g.Name()] = g
+		}
+	}
+}
+
+// WithTransferRate 设置哈希环变化时向新节点迁移数据的速率（每秒缓存项个数），<= 0 表示不限速
+func WithTransferRate(entriesPerSecond int) Option {
+	return func(p *CacheServer) {
+		p.transferRate = entriesPerSecond
+	}
+}
+
+// WithReplication 设置每个 key 保存在多少个节点上（owner + n-1 个副本）。
+// 从数据源加载的值会同时写入所有副本，owner 不可用时可以从其他副本读取。
+func WithReplication(n int) Option {
+	return func(p *CacheServer) {
+		p.replication = max(n, 1)
+	}
+}
+
+// WithHotKeySpread 开启热点 key 识别：一秒内转发次数达到 threshold 的 key，读请求会轮流分散到它的所有副本上
+func WithHotKeySpread(threshold int) Option {
+	return func(p *CacheServer) {
+		p.hotKeys = newHotKeys(threshold, hotKeyWindow)
+	}
+}
+
+// WithLocality 设置本节点所在的可用区和机架。副本优先分散到不同的可用区，读取时优先访问同一可用区的副本。
+func WithLocality(meta consistenthash.Meta) Option {
+	return func(p *CacheServer) {
+		p.locality = meta
+	}
+}
+
+// WithChunkSize 设置流式传输值时每次写出的块大小，默认 64KiB
+func WithChunkSize(n int) Option {
+	return func(p *CacheServer) {
+		if n > 0 {
+			p.chunkSize = n
+		}
+	}
+}
+
+// WithMaxValueSize 设置节点间传输的值的最大字节数，超过的值服务端拒绝发送、客户端拒绝接收。<= 0 表示不限制。
+// 节点间写入和迁移数据的请求体最大是 64MiB 和 n 加上少量余量中较大的一个。
+func WithMaxValueSize(n int64) Option {
+	return func(p *CacheServer) {
+		p.maxValueSize = n
+	}
+}
+
+// WithShutdownHandoff 设置 Shutdown 时每个 group 迁移给新的归属节点的缓存项个数，按最近使用的顺序优先迁移热点 key。
+// 默认是 0，即不迁移，新的归属节点在缓存未命中时从数据源加载；< 0 表示迁移全部缓存项。
+func WithShutdownHandoff(n int) Option {
+	return func(p *CacheServer) {
+		p.shutdownHandoff = n
+	}
+}
+
+// WithAuth 开启节点间接口的认证：发往其他节点的请求用 a 的集群密钥签名，收到的请求必须带有正确的签名，
+// 或者带有拥有相应权限的 bearer token（读取需要 PermRead，写入和删除需要 PermWrite，迁移数据和退出通知需要 PermAdmin）。
+// 运维接口 AdminServer 也接受 a 中拥有 PermAdmin 权限的 token。
+func WithAuth(a *Auth) Option {
+	return func(p *CacheServer) {
+		p.auth = a
+	}
+}
+
+// WithTLS 让节点间通信使用 TLS：Start 和 Serve 监听 HTTPS，访问其他节点时用 cfg 验证对端的证书。
+// cfg.RequireClientCert 为 true 时开启 mTLS，只接受证书身份是哈希环上的节点的请求。节点 ID 必须以 https:// 开头。
+func WithTLS(cfg *TLSConfig) Option {
+	return func(p *CacheServer) {
+		p.tls = cfg
+	}
+}
@@ -0,0 +1,431 @@
+package network
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"geecache"
+	"geecache/util"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+/*
+RESPServer 是一个兼容 Redis 协议（RESP2/RESP3 的子集）的 TCP 服务，redis-cli 和现有的 Redis 客户端库可以直接读取 geecache 中的数据。
+
+支持的命令：GET、MGET、EXISTS、DEL、PING、ECHO、SELECT、INFO、HELLO、COMMAND、QUIT。
+每个 group 相当于 Redis 的一个 db：SELECT 可以使用 group 名称，也可以使用 group 按名称排序后的序号；
+key 也可以写成 "group:key" 的形式，直接指定 group。GET 是读穿透（read-through）的，缓存未命中时从远程节点或数据源加载。
+DEL 让 key 在整个集群中失效（见 geecache.Group.Delete），不会删除数据源中的数据。
+*/
+
+const (
+	// 命令参数个数和单个参数长度的上限，防止恶意请求耗尽内存
+	respMaxArgs   = 1 << 20
+	respMaxBulk   = 1 << 20
+	respMaxInline = 64 << 10
+)
+
+var errRESPProtocol = errors.New("Protocol error")
+
+// RESPServer serves geecache groups over the Redis protocol.
+type RESPServer struct {
+	tcp    tcpServer
+	groups groupSet
+
+	commands atomic.Int64
+}
+
+// NewRESPServer 创建一个 RESPServer，只提供 groups 中的 group；groups 为空时提供所有注册的 group
+func NewRESPServer(groups ...*geecache.Group) *RESPServer {
+	return &RESPServer{groups: newGroupSet(groups)}
+}
+
+// ListenAndServe 监听 TCP 地址 addr 并处理连接
+func (s *RESPServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve 接受 l 上的连接并处理，直到 Close 被调用
+func (s *RESPServer) Serve(l net.Listener) error {
+	return s.tcp.serve(l, s.serveConn)
+}
+
+// Close 停止监听并关闭所有连接
+func (s *RESPServer) Close() error {
+	return s.tcp.close()
+}
+
+// respConn 是一个客户端连接的状态
+type respConn struct {
+	server *RESPServer
+	r      *bufio.Reader
+	w      *bufio.Writer
+	// 协议版本，HELLO 3 之后使用 RESP3
+	proto int
+	// SELECT 选择的 group
+	group *geecache.Group
+}
+
+func (s *RESPServer) serveConn(conn net.Conn) {
+	c := &respConn{
+		server: s,
+		r:      bufio.NewReader(conn),
+		w:      bufio.NewWriter(conn),
+		proto:  2,
+	}
+	if groups := s.groups.list(); len(groups) > 0 {
+		c.group = groups[0]
+	}
+	for {
+		args, err := c.readCommand()
+		if err != nil {
+			if errors.Is(err, errRESPProtocol) {
+				c.writeError("ERR " + err.Error())
+				c.w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		s.commands.Add(1)
+		quit := c.exec(args)
+		// 客户端使用 pipeline 时，读完缓冲区中的所有命令之后再一起发送响应
+		if c.r.Buffered() == 0 || quit {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// readCommand 读取一条命令：RESP 数组形式的多条 bulk string，或者以空格分隔的 inline 命令（方便用 telnet 调试）
+func (c *respConn) readCommand() ([]string, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > respMaxArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errRESPProtocol)
+	}
+	// 与 Redis 相同，长度 <= 0 的数组（例如 *-1）是空命令，忽略它继续读取下一条
+	if n <= 0 {
+		return nil, nil
+	}
+	args := make([]string, 0, min(n, 1024))
+	for range n {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("%w: expected '$', got '%.1s'", errRESPProtocol, line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > respMaxBulk {
+			return nil, fmt.Errorf("%w: invalid bulk length", errRESPProtocol)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		if string(buf[size:]) != "\r\n" {
+			return nil, fmt.Errorf("%w: bulk string not terminated by CRLF", errRESPProtocol)
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func (c *respConn) readLine() (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := c.r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line = append(line, chunk...)
+		if len(line) > respMaxInline {
+			return "", fmt.Errorf("%w: too big inline request", errRESPProtocol)
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+func (c *respConn) writeSimple(s string) {
+	c.w.WriteString("+" + s + "\r\n")
+}
+
+func (c *respConn) writeError(msg string) {
+	c.w.WriteString("-" + msg + "\r\n")
+}
+
+func (c *respConn) writeInt(n int64) {
+	c.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (c *respConn) writeBulk(b []byte) {
+	c.w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	c.w.Write(b)
+	c.w.WriteString("\r\n")
+}
+
+// writeBulkView 与 writeBulk 相同，值直接从 ByteView 写出，不拷贝
+func (c *respConn) writeBulkView(v util.ByteView) {
+	c.w.WriteString("$" + strconv.Itoa(v.Size()) + "\r\n")
+	v.WriteTo(c.w)
+	c.w.WriteString("\r\n")
+}
+
+func (c *respConn) writeNull() {
+	if c.proto >= 3 {
+		c.w.WriteString("_\r\n")
+	} else {
+		c.w.WriteString("$-1\r\n")
+	}
+}
+
+func (c *respConn) writeArray(n int) {
+	c.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// writeMap 写入 n 个键值对的 map，RESP2 中用长度为 2n 的数组表示
+func (c *respConn) writeMap(n int) {
+	if c.proto >= 3 {
+		c.w.WriteString("%" + strconv.Itoa(n) + "\r\n")
+	} else {
+		c.writeArray(2 * n)
+	}
+}
+
+func (c *respConn) wrongArgs(cmd string) {
+	c.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+}
+
+// exec 执行一条命令，返回是否应该关闭连接
+func (c *respConn) exec(args []string) (quit bool) {
+	cmd := strings.ToUpper(args[0])
+	args = args[1:]
+	switch cmd {
+	case "PING":
+		switch len(args) {
+		case 0:
+			c.writeSimple("PONG")
+		case 1:
+			c.writeBulk([]byte(args[0]))
+		default:
+			c.wrongArgs(cmd)
+		}
+	case "ECHO":
+		if len(args) != 1 {
+			c.wrongArgs(cmd)
+			return
+		}
+		c.writeBulk([]byte(args[0]))
+	case "QUIT":
+		c.writeSimple("OK")
+		return true
+	case "HELLO":
+		c.hello(args)
+	case "COMMAND":
+		// redis-cli 启动时会发送 COMMAND DOCS，返回空数组即可
+		c.writeArray(0)
+	case "SELECT":
+		c.selectGroup(args)
+	case "GET":
+		if len(args) != 1 {
+			c.wrongArgs(cmd)
+			return
+		}
+		c.get(args[0])
+	case "MGET":
+		if len(args) == 0 {
+			c.wrongArgs(cmd)
+			return
+		}
+		c.writeArray(len(args))
+		for _, key := range args {
+			c.get(key)
+		}
+	case "EXISTS":
+		if len(args) == 0 {
+			c.wrongArgs(cmd)
+			return
+		}
+		// 只检查本节点的缓存，不存在的 key 不会触发加载
+		var n int64
+		for _, key := range args {
+			if g, key, err := c.resolve(key); err == nil && g.Contains(key) {
+				n++
+			}
+		}
+		c.writeInt(n)
+	case "DEL", "UNLINK":
+		if len(args) == 0 {
+			c.wrongArgs(cmd)
+			return
+		}
+		var n int64
+		for _, key := range args {
+			g, key, err := c.resolve(key)
+			if err != nil {
+				c.writeError("ERR " + err.Error())
+				return
+			}
+			// 与 Redis 相同，只统计本节点缓存中存在的 key，但总是让 key 在整个集群中失效
+			if g.Contains(key) {
+				n++
+			}
+			if err := g.Delete(key); err != nil {
+				log.Printf("[RESP] delete %s: %v", key, err)
+			}
+		}
+		c.writeInt(n)
+	case "INFO":
+		c.writeBulk([]byte(c.server.info(args)))
+	default:
+		c.writeError(fmt.Sprintf("ERR unknown command '%s'", strings.ToLower(cmd)))
+	}
+	return
+}
+
+// resolve 找到 key 所在的 group："group:key" 形式的 key 使用指定的 group，否则使用 SELECT 选择的 group
+func (c *respConn) resolve(key string) (*geecache.Group, string, error) {
+	g, key, ok := c.server.groups.resolveKey(key, c.group)
+	if !ok {
+		return nil, "", errors.New("no group selected")
+	}
+	return g, key, nil
+}
+
+func (c *respConn) get(key string) {
+	g, key, err := c.resolve(key)
+	if err != nil {
+		c.writeNull()
+		return
+	}
+	view, err := g.Get(key)
+	if err != nil {
+		// 数据源中不存在的 key 和加载失败都返回 nil，与 Redis 中 key 不存在的语义一致
+		log.Printf("[RESP] get %s/%s: %v", g.Name(), key, err)
+		c.writeNull()
+		return
+	}
+	c.writeBulkView(view)
+}
+
+func (c *respConn) selectGroup(args []string) {
+	if len(args) != 1 {
+		c.wrongArgs("SELECT")
+		return
+	}
+	if g := c.server.groups.get(args[0]); g != nil {
+		c.group = g
+		c.writeSimple("OK")
+		return
+	}
+	groups := c.server.groups.list()
+	index, err := strconv.Atoi(args[0])
+	if err != nil || index < 0 || index >= len(groups) {
+		c.writeError("ERR DB index is out of range")
+		return
+	}
+	c.group = groups[index]
+	c.writeSimple("OK")
+}
+
+// hello 处理 HELLO [protover]，用于切换到 RESP3
+func (c *respConn) hello(args []string) {
+	if len(args) > 0 {
+		ver, err := strconv.Atoi(args[0])
+		if err != nil {
+			c.writeError("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if ver != 2 && ver != 3 {
+			c.writeError("NOPROTO unsupported protocol version")
+			return
+		}
+		c.proto = ver
+	}
+	c.writeMap(4)
+	c.writeBulk([]byte("server"))
+	c.writeBulk([]byte("geecache"))
+	c.writeBulk([]byte("proto"))
+	c.writeInt(int64(c.proto))
+	c.writeBulk([]byte("mode"))
+	c.writeBulk([]byte("standalone"))
+	c.writeBulk([]byte("role"))
+	c.writeBulk([]byte("master"))
+}
+
+// info 生成 INFO 命令的输出，section 为空时输出所有部分
+func (s *RESPServer) info(args []string) string {
+	want := func(section string) bool {
+		if len(args) == 0 {
+			return true
+		}
+		for _, a := range args {
+			if a = strings.ToLower(a); a == section || a == "all" || a == "everything" || a == "default" {
+				return true
+			}
+		}
+		return false
+	}
+	groups := s.groups.list()
+	var b strings.Builder
+	if want("server") {
+		b.WriteString("# Server\r\n")
+		// 一些客户端库会检查 redis_version
+		b.WriteString("redis_version:7.0.0\r\n")
+		b.WriteString("redis_mode:standalone\r\n")
+		b.WriteString("geecache_groups:" + strconv.Itoa(len(groups)) + "\r\n\r\n")
+	}
+	if want("stats") {
+		var hits, misses int64
+		for _, g := range groups {
+			st := g.Stats()
+			hits += st.CacheHits
+			misses += st.Gets - st.CacheHits
+		}
+		b.WriteString("# Stats\r\n")
+		fmt.Fprintf(&b, "total_connections_received:%d\r\n", s.tcp.connections.Load())
+		fmt.Fprintf(&b, "total_commands_processed:%d\r\n", s.commands.Load())
+		fmt.Fprintf(&b, "keyspace_hits:%d\r\n", hits)
+		fmt.Fprintf(&b, "keyspace_misses:%d\r\n\r\n", misses)
+	}
+	if want("keyspace") {
+		b.WriteString("# Keyspace\r\n")
+		for i, g := range groups {
+			fmt.Fprintf(&b, "db%d:keys=%d,expires=0,avg_ttl=0,group=%s\r\n", i, g.Stats().Items, g.Name())
+		}
+		b.WriteString("\r\n")
+	}
+	if want("groups") {
+		b.WriteString("# Groups\r\n")
+		for _, g := range groups {
+			st := g.Stats()
+			fmt.Fprintf(&b, "%s:keys=%d,bytes=%d,gets=%d,hits=%d,loads=%d,peer_loads=%d,local_loads=%d\r\n",
+				g.Name(), st.Items, st.Bytes, st.Gets, st.CacheHits, st.Loads, st.PeerLoads, st.LocalLoads)
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\r\n")
+}
@@ -0,0 +1,202 @@
+package network
+
+import (
+	"bufio"
+	"fmt"
+	"geecache"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// respClient 是一个手写的最小 RESP 客户端
+type respClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialRESP(t *testing.T, addr string) *respClient {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &respClient{t, conn, bufio.NewReader(conn)}
+}
+
+// do 以 RESP 数组的形式发送命令并读取一个响应
+func (c *respClient) do(args ...string) any {
+	c.t.Helper()
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := c.conn.Write([]byte(b.String())); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.read()
+}
+
+// read 读取一个响应：简单字符串返回 string，错误返回 error，整数返回 int64，
+// bulk string 返回 []byte，null 返回 nil，数组和 map 返回 []any
+func (c *respClient) read() any {
+	c.t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return fmt.Errorf("%s", line[1:])
+	case ':':
+		n, _ := strconv.ParseInt(line[1:], 10, 64)
+		return n
+	case '_':
+		return nil
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			c.t.Fatal(err)
+		}
+		return buf[:n]
+	case '*', '%':
+		n, _ := strconv.Atoi(line[1:])
+		if line[0] == '%' {
+			n *= 2
+		}
+		items := make([]any, n)
+		for i := range items {
+			items[i] = c.read()
+		}
+		return items
+	}
+	c.t.Fatalf("unexpected reply %q", line)
+	return nil
+}
+
+func (c *respClient) expect(want string, args ...string) {
+	c.t.Helper()
+	if got := fmt.Sprint(stringify(c.do(args...))); got != want {
+		c.t.Fatalf("%v: got %s, want %s", args, got, want)
+	}
+}
+
+// stringify 把 bulk string 转换成字符串，方便比较
+func stringify(v any) any {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case []any:
+		for i := range v {
+			v[i] = stringify(v[i])
+		}
+	}
+	return v
+}
+
+func TestRESPServer(t *testing.T) {
+	var loads atomic.Int64
+	getter := func(prefix string) geecache.GetterFunc {
+		return func(key string) ([]byte, error) {
+			loads.Add(1)
+			if key == "missing" {
+				return nil, fmt.Errorf("%s not exist", key)
+			}
+			return []byte(prefix + key), nil
+		}
+	}
+	registry := geecache.NewRegistry()
+	scores := newTestGroup(t, registry, "resp-scores", 1<<20, getter("score-"))
+	users := newTestGroup(t, registry, "resp-users", 1<<20, getter("user-"))
+	server := NewRESPServer(scores, users)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+	defer server.Close()
+
+	c := dialRESP(t, l.Addr().String())
+	c.expect("PONG", "PING")
+	c.expect("hi", "PING", "hi")
+	// 默认选择排序后的第一个 group
+	c.expect("score-Tom", "GET", "Tom")
+	c.expect("<nil>", "GET", "missing")
+	c.expect("user-Tom", "GET", "resp-users:Tom")
+	c.expect("[score-Tom <nil> user-Sam]", "MGET", "Tom", "missing", "resp-users:Sam")
+	c.expect("OK", "SELECT", "1")
+	c.expect("user-Jack", "GET", "Jack")
+	c.expect("OK", "SELECT", "resp-scores")
+	c.expect("score-Jack", "GET", "Jack")
+	c.expect("ERR DB index is out of range", "SELECT", "9")
+	// EXISTS 只检查缓存，不会加载不存在的 key
+	before := loads.Load()
+	c.expect("2", "EXISTS", "Tom", "missing", "Jack", "Sam")
+	if loads.Load() != before {
+		t.Fatalf("EXISTS loaded %d keys from the source", loads.Load()-before)
+	}
+
+	// DEL 只统计存在的 key，之后重新从数据源加载
+	c.expect("1", "DEL", "Tom", "Sam")
+	c.expect("score-Tom", "GET", "Tom")
+	if loads.Load() != before+1 {
+		t.Fatalf("expected Tom to be reloaded after DEL")
+	}
+
+	info, ok := c.do("INFO").([]byte)
+	if !ok || !strings.Contains(string(info), "db0:keys=2,expires=0,avg_ttl=0,group=resp-scores") ||
+		!strings.Contains(string(info), "keyspace_hits:") {
+		t.Fatalf("unexpected INFO output: %s", info)
+	}
+	c.expect("ERR unknown command 'flushall'", "FLUSHALL")
+	c.expect("ERR wrong number of arguments for 'get' command", "GET")
+
+	// RESP3 中 null 和 map 使用新的类型
+	c.expect("[server geecache proto 3 mode standalone role master]", "HELLO", "3")
+	c.expect("<nil>", "GET", "missing")
+
+	// inline 命令
+	c.conn.Write([]byte("PING\r\n"))
+	if got := c.read(); got != "PONG" {
+		t.Fatalf("inline PING: got %v", got)
+	}
+	c.expect("OK", "QUIT")
+}
+
+func TestRESPMultibulkLength(t *testing.T) {
+	server := NewRESPServer(newTestGroup(t, geecache.NewRegistry(), "resp-len", 1<<20, geecache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+	defer server.Close()
+
+	// 空数组和负数长度的数组被忽略，连接继续可用
+	c := dialRESP(t, l.Addr().String())
+	c.conn.Write([]byte("*-1\r\n*0\r\n*-100\r\n"))
+	c.expect("PONG", "PING")
+
+	// 超过上限的长度是协议错误，服务端回复错误后关闭连接
+	c.conn.Write([]byte(fmt.Sprintf("*%d\r\n", respMaxArgs+1)))
+	if err, ok := c.read().(error); !ok || !strings.Contains(err.Error(), "invalid multibulk length") {
+		t.Fatalf("oversized multibulk: got %v", err)
+	}
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Fatalf("expected the connection to be closed, got %v", err)
+	}
+}
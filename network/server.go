@@ -1,17 +1,27 @@
 package network
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"geecache"
+	"geecache/compress"
 	"geecache/consistenthash"
 	pb "geecache/proto"
+	"geecache/util"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -19,6 +29,18 @@ const (
 	// 节点间通讯地址的前缀，默认是 /_geecache/，那么 http://example.com/_geecache/ 开头的请求，就用于节点间的访问。因为一个主机上还可能承载其他的服务，加一段 Path 是一个好习惯。比如，大部分网站的 API 接口，一般以 /api 作为前缀。
 	defaultBasePath = "/_geecache/"
 	defaultReplicas = 50
+	// 迁移数据时每秒最多发送的缓存项个数
+	defaultTransferRate = 1000
+	// 迁移数据时每个 Transfer 请求携带的缓存项个数和值的总字节数，超过任意一个就发送
+	transferBatchSize  = 64
+	transferBatchBytes = 16 << 20
+	// 节点间请求体的默认上限，配置的 maxValueSize 更大时使用 maxValueSize 加上 key 等字段的余量
+	defaultMaxRequestBody = 64 << 20
+	requestBodyOverhead   = 64 << 10
+	// 统计热点 key 的时间窗口
+	hotKeyWindow = time.Second
+	// 节点退出集群时通知其他节点的路径，不会和 <groupname>/<key> 冲突
+	leavePath = "_leave"
 )
 
 // CacheServer，作为承载节点间 HTTP 通信的核心数据结构
@@ -26,30 +48,97 @@ type CacheServer struct {
 	sync.RWMutex
 	// 当前节点的自身地址, e.g. "https://example.net:8000"
 	selfURL string
+	// 当前节点所在的可用区和机架，读副本时优先选择同一可用区的节点
+	locality consistenthash.Meta
 	// 当前节点的API前缀
 	basePath string
 
+	// 一致性哈希使用的哈希函数和每个节点的虚拟节点个数
+	hasher       consistenthash.Hash
+	virtualNodes int
 	// 一致性哈希根据具体的 key 选择节点来实现负载均衡
 	peers *consistenthash.NodeMap
+	// 每个 key 保存在多少个节点上（owner + 副本），默认是 1，即不做副本
+	replication int
+	// 识别热点 key，把热点 key 的读请求分散到所有副本
+	hotKeys *hotKeys
 	// 映射远程节点与对应的 httpGetter。每一个远程节点对应一个 httpGetter，因为 httpGetter 与远程节点的地址 baseURL 有关。
 	getters map[consistenthash.NodeID]*httpGetter
+
+	// 本节点对外提供服务的 group，为 nil 时使用 geecache 的全局注册表
+	groups groupSet
+	// 哈希环变化时迁移数据的速率（每秒缓存项个数），<= 0 表示不限速
+	transferRate int
+	// 保证同一时刻只有一个迁移任务在执行
+	handoffMtx sync.Mutex
+
+	// 流式传输时每次写出的块大小
+	chunkSize int
+	// 节点间传输的值的最大字节数，<= 0 表示不限制
+	maxValueSize int64
+	// 退出时每个 group 迁移给新的归属节点的最热的缓存项个数，0 表示不迁移，< 0 表示全部迁移
+	shutdownHandoff int
+	// Start 或 Serve 创建的 HTTP 服务，Shutdown 时停止
+	srv *http.Server
+	// 节点间通信的 TLS 参数，为 nil 时使用明文的 HTTP
+	tls *TLSConfig
+	// 访问其他节点使用的 HTTP 客户端
+	client *http.Client
+	// 请求的认证和授权，为 nil 时不检查
+	auth *Auth
 }
 
-func NewCacheServer(addr string) *CacheServer {
-	return &CacheServer{
-		selfURL:  addr,
-		basePath: defaultBasePath,
-		peers:    consistenthash.New(defaultReplicas, nil),
-		getters:  make(map[consistenthash.NodeID]*httpGetter),
+func NewCacheServer(addr string, opts ...Option) *CacheServer {
+	p := &CacheServer{
+		selfURL:      addr,
+		basePath:     defaultBasePath,
+		hasher:       consistenthash.CRC32,
+		virtualNodes: defaultReplicas,
+		getters:      make(map[consistenthash.NodeID]*httpGetter),
+		transferRate: defaultTransferRate,
+		replication:  1,
+		hotKeys:      newHotKeys(0, hotKeyWindow),
+		chunkSize:    defaultChunkSize,
+	}
+	for _, opt := range opts {
+		opt(p)
 	}
+	p.client = http.DefaultClient
+	if p.tls != nil {
+		p.client = p.tls.newClient()
+	}
+	p.peers = consistenthash.New(p.virtualNodes, p.hasher)
+	p.peers.SetMeta(consistenthash.NodeID(p.selfURL), p.locality)
+	return p
+}
+
+func (p *CacheServer) getGroup(name string) *geecache.Group {
+	return p.groups.get(name)
+}
+
+// listGroups 返回按名称排序的 group
+func (p *CacheServer) listGroups() []*geecache.Group {
+	return p.groups.list()
 }
 
 // ServeHTTP 负责处理所有HTTP请求 selfURL/<basepath>/<groupname>/<key>
+//
+//	GET  <basepath>/<groupname>/<key> 获取缓存值
+//	POST <basepath>/<groupname>/      接收其他节点迁移过来的缓存项
+//	POST <basepath>_leave             其他节点退出集群，请求体是该节点的 ID
 func (p *CacheServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if !strings.HasPrefix(r.URL.Path, p.basePath) {
 		panic("HTTPPool serving unexpected path: " + r.URL.Path)
 	}
 	log.Printf("[Server %s] %s : %s", p.selfURL, r.Method, r.URL.Path)
+	// 在检查签名和解码之前限制请求体的大小，避免任何客户端都能让节点缓存任意大的请求体
+	r.Body = http.MaxBytesReader(w, r.Body, p.maxRequestBody())
+	if r.URL.Path == p.basePath+leavePath && r.Method == http.MethodPost {
+		if p.authorize(w, r, AllGroups, PermAdmin) {
+			p.serveLeave(w, r)
+		}
+		return
+	}
 	parts := strings.SplitN(r.URL.Path[len(p.basePath):], "/", 2)
 	if len(parts) < 2 {
 		http.Error(w, "bad request", http.StatusBadRequest)
@@ -58,40 +147,323 @@ func (p *CacheServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	groupName := parts[0]
 	key := parts[1]
+	if !p.authorize(w, r, groupName, methodPermission(r.Method)) {
+		return
+	}
 
-	group := geecache.GetGroup(groupName)
+	group := p.getGroup(groupName)
 	if group == nil {
 		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
 		return
 	}
-	value, err := group.Get(key)
+
+	switch {
+	case r.Method == http.MethodGet:
+		p.serveGet(w, r, group, key)
+	case r.Method == http.MethodPost && key == "":
+		p.serveTransfer(w, r, group)
+	case r.Method == http.MethodPut && key != "":
+		p.serveSet(w, r, group, key)
+	case r.Method == http.MethodDelete && key != "":
+		// 只删除本节点的缓存，其他持有者由发起 Delete 的节点通知
+		group.Evict(key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// maxRequestBody 返回节点间请求体的最大字节数，需要容纳一次 Set 的值或者一批迁移的缓存项
+func (p *CacheServer) maxRequestBody() int64 {
+	return max(defaultMaxRequestBody, p.maxValueSize+requestBodyOverhead)
+}
+
+// methodPermission 返回节点间接口的请求需要的权限
+func methodPermission(method string) Permission {
+	switch method {
+	case http.MethodPut, http.MethodDelete:
+		return PermWrite
+	case http.MethodPost:
+		return PermAdmin
+	default:
+		return PermRead
+	}
+}
+
+// authorize 检查节点间接口的请求：开启 mTLS 时先检查客户端证书的身份（见 verifyPeer）；
+// 配置了 WithAuth 时，带有签名的请求来自集群中的其他节点，允许所有操作，
+// 其他请求（例如 geecachectl）按照 bearer token 的 ACL 检查。
+// 检查失败时写入 401 或 403 并返回 false，被拒绝的请求计入 group 和 Auth 的统计。
+func (p *CacheServer) authorize(w http.ResponseWriter, r *http.Request, group string, perm Permission) bool {
+	err := p.verifyPeer(r)
+	if err != nil {
+		if p.auth != nil {
+			p.auth.reject(err)
+		}
+	} else if p.auth != nil {
+		if signed(r) {
+			err = p.auth.verifySignature(r)
+		} else {
+			err = p.auth.authorizeToken(r, group, perm)
+		}
+	}
+	if err == nil {
+		return true
+	}
+	if status := authStatus(err); status == http.StatusUnauthorized || status == http.StatusForbidden {
+		if g := p.getGroup(group); g != nil {
+			g.RecordUnauthorized()
+		}
+	}
+	log.Printf("[Server %s] reject %s %s from %s: %v", p.selfURL, r.Method, r.URL.Path, r.RemoteAddr, err)
+	setChallenge(w, err)
+	http.Error(w, err.Error(), authStatus(err))
+	return false
+}
+
+// serveLeave 处理其他节点退出集群的通知，把它从哈希环中删除
+func (p *CacheServer) serveLeave(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 4096))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	peer := consistenthash.NodeID(strings.TrimSpace(string(body)))
+	if peer == "" || peer == consistenthash.NodeID(p.selfURL) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	log.Printf("[Server %s] peer %s is leaving", p.selfURL, peer)
+	p.DelPeeker(peer)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *CacheServer) serveGet(w http.ResponseWriter, r *http.Request, group *geecache.Group, key string) {
+	value, err := group.GetEncoded(key)
+	if err == nil && value.Encoding != "" && !acceptsEncoding(r, value.Encoding) {
+		// 客户端不支持缓存中保存的压缩算法，只能解压后发送
+		value, err = compress.Decode(value)
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if p.maxValueSize > 0 && int64(value.Size()) > p.maxValueSize {
+		http.Error(w, fmt.Sprintf("value of %d bytes exceeds the limit of %d bytes", value.Size(), p.maxValueSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	// 客户端支持流式传输时，直接分块写出值，避免在内存中再编码一份完整的 pb.Response
+	if wantsStream(r) {
+		writeStream(w, value, p.chunkSize)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/octet-stream") // 表明是二进制流
 	// 用 protobuf 的目的非常简单，为了获得更高的性能。传输前使用 protobuf 编码，接收方再进行解码，可以显著地降低二进制传输的大小。另外一方面，protobuf 可非常适合传输结构化数据，便于通信字段的扩展。
-	body, err := proto.Marshal(&pb.Response{Value: value.ByteSlice()})
+	header, err := proto.Marshal(&pb.Response{Encoding: value.Encoding})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	w.Write(body) // 这里可以用 w.Write(view.b) 代替，写入 http body 不会影响 cache 的值
+	// value 字段放在最后：只编码它的 tag 和长度，值本身用 WriteTo 直接写入 http body，不拷贝缓存中的数据
+	header = protowire.AppendTag(header, responseValueField, protowire.BytesType)
+	header = protowire.AppendVarint(header, uint64(value.Size()))
+	w.Header().Set("Content-Length", strconv.Itoa(len(header)+value.Size()))
+	w.Write(header)
+	value.WriteTo(w)
+}
+
+// responseValueField 是 pb.Response 中 value 字段的编号
+var responseValueField = (&pb.Response{}).ProtoReflect().Descriptor().Fields().ByName("value").Number()
+
+// readBody 读取受 maxRequestBody 限制的请求体，失败时写入 413 或 400 并返回 false
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return nil, false
+	}
+	return body, true
+}
+
+func (p *CacheServer) serveTransfer(w http.ResponseWriter, r *http.Request, group *geecache.Group) {
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	var req pb.TransferRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		http.Error(w, "decoding request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var accepted int64
+	for _, e := range req.GetEntries() {
+		// 不认识的压缩算法无法解压，丢弃
+		if _, ok := compress.Lookup(e.GetEncoding()); e.GetEncoding() != "" && !ok {
+			continue
+		}
+		group.Populate(e.GetKey(), util.NewByteView(e.GetValue(), e.GetEncoding()))
+		accepted++
+	}
+	log.Printf("[Server %s] accepted %d entries of group %s", p.selfURL, accepted, group.Name())
+
+	body, err := proto.Marshal(&pb.TransferResponse{Accepted: accepted})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
+// serveSet 处理其他节点 Group.Set 发来的写请求：更新本地缓存，再复制到 key 的其他副本
+func (p *CacheServer) serveSet(w http.ResponseWriter, r *http.Request, group *geecache.Group, key string) {
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	var req pb.SetRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		http.Error(w, "decoding request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := compress.Lookup(req.GetEncoding()); req.GetEncoding() != "" && !ok {
+		http.Error(w, "unsupported encoding: "+req.GetEncoding(), http.StatusUnsupportedMediaType)
+		return
+	}
+	if p.maxValueSize > 0 && int64(len(req.GetValue())) > p.maxValueSize {
+		http.Error(w, "value too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	value := util.NewByteView(req.GetValue(), req.GetEncoding())
+	group.Populate(key, value)
+	p.Replicate(group.Name(), key, value)
+
+	body, err := proto.Marshal(&pb.SetResponse{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
+// Start 在 addr 上监听并处理节点间的请求，直到 Shutdown 被调用。Shutdown 之后返回 nil。
+func (p *CacheServer) Start(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(l)
+}
+
+// Serve 在 l 上处理节点间的请求，直到 Shutdown 被调用。Shutdown 之后返回 nil。
+// 配置了 WithTLS 时使用 HTTPS。
+func (p *CacheServer) Serve(l net.Listener) error {
+	mux := http.NewServeMux()
+	mux.Handle(p.basePath, p)
+	srv := &http.Server{Handler: mux}
+	if p.tls != nil {
+		srv.TLSConfig = p.tls.ServerConfig()
+	}
+	p.Lock()
+	if p.srv != nil {
+		p.Unlock()
+		l.Close()
+		return errors.New("network: server already started")
+	}
+	p.srv = srv
+	p.Unlock()
+	var err error
+	if srv.TLSConfig != nil {
+		err = srv.ServeTLS(l, "", "")
+	} else {
+		err = srv.Serve(l)
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown 让本节点平滑地退出集群：
+//  1. 按照 WithShutdownHandoff 把最热的缓存项迁移给新的归属节点；
+//  2. 通知其他节点把自己从哈希环中删除，之后的请求不再路由到本节点；
+//  3. 停止 Start 或 Serve 启动的 HTTP 服务，等待正在处理的请求完成。
+//
+// group 不会被关闭，需要在 Shutdown 之后调用各个 group 的 Shutdown，等待进行中的加载完成。
+// ctx 结束时不再等待，返回 ctx.Err()。
+func (p *CacheServer) Shutdown(ctx context.Context) error {
+	self := consistenthash.NodeID(p.selfURL)
+	p.RLock()
+	getters := make([]*httpGetter, 0, len(p.getters))
+	for node, getter := range p.getters {
+		if node != self {
+			getters = append(getters, getter)
+		}
+	}
+	var plan *consistenthash.MigrationPlan
+	if p.shutdownHandoff != 0 {
+		next := p.peers.Clone()
+		next.DelNode(self)
+		plan = p.peers.Diff(next)
+	}
+	srv := p.srv
+	p.RUnlock()
+
+	if plan != nil && len(plan.Migrations) > 0 {
+		p.handoff(ctx, plan, p.shutdownHandoff)
+	}
+	var errs []error
+	for _, getter := range getters {
+		if err := getter.Leave(ctx, self); err != nil {
+			errs = append(errs, fmt.Errorf("announce leave to %s: %w", getter.remoteURL, err))
+		}
+	}
+	if srv != nil {
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
 }
 
 // AddPeers 添加一个对端节点到本地节点注册表
 func (p *CacheServer) AddPeers(peers ...consistenthash.NodeID) {
 	p.Lock()
 	defer p.Unlock()
+	old := p.peers.Clone()
 	for _, peer := range peers {
-		url, err := url.JoinPath(string(peer), p.basePath)
-		if err != nil {
-			panic(err)
-		}
-		p.peers.AddNodes(peer)
-		p.getters[peer] = &httpGetter{remoteURL: url}
+		p.addPeerLocked(peer)
+	}
+	p.startHandoff(old)
+}
+
+// AddPeer 添加一个带位置信息的对端节点，副本会优先分散到不同的可用区和机架
+func (p *CacheServer) AddPeer(peer consistenthash.NodeID, meta consistenthash.Meta) {
+	p.Lock()
+	defer p.Unlock()
+	old := p.peers.Clone()
+	p.peers.SetMeta(peer, meta)
+	p.addPeerLocked(peer)
+	p.startHandoff(old)
+}
+
+func (p *CacheServer) addPeerLocked(peer consistenthash.NodeID) {
+	url, err := url.JoinPath(string(peer), p.basePath)
+	if err != nil {
+		panic(err)
 	}
+	p.peers.AddNodes(peer)
+	p.getters[peer] = &httpGetter{remoteURL: url, maxValueSize: p.maxValueSize, client: p.client, auth: p.auth}
 }
 
 // DelPeeker 从本地节点注册表中删除一个对端节点
@@ -99,35 +471,328 @@ func (p *CacheServer) DelPeeker(peer consistenthash.NodeID) {
 	p.Lock()
 	defer p.Unlock()
 	if _, ok := p.getters[peer]; ok {
+		old := p.peers.Clone()
 		p.peers.DelNode(peer)
 		delete(p.getters, peer)
+		p.startHandoff(old)
+	}
+}
+
+// startHandoff 在哈希环变化后，异步地把不再属于自己的缓存项迁移给新的归属节点。调用者必须持有写锁。
+func (p *CacheServer) startHandoff(old *consistenthash.NodeMap) {
+	plan := old.Diff(p.peers)
+	if len(plan.Migrations) == 0 {
+		return
+	}
+	go p.Handoff(plan)
+}
+
+// Handoff 把本地缓存中按照 plan 由本节点迁移到其他节点的 key 推送给新的归属节点，
+// 让新节点从对端预热，而不是在缓存未命中时直接打到数据源。本地的副本不会删除，由 LRU 自然淘汰。
+func (p *CacheServer) Handoff(plan *consistenthash.MigrationPlan) {
+	p.handoff(context.Background(), plan, -1)
+}
+
+// handoff 与 Handoff 相同，但每个 group 最多迁移 limit 个最近使用过的 key（limit < 0 表示不限制），ctx 结束时停止迁移
+func (p *CacheServer) handoff(ctx context.Context, plan *consistenthash.MigrationPlan, limit int) {
+	p.handoffMtx.Lock()
+	defer p.handoffMtx.Unlock()
+	self := consistenthash.NodeID(p.selfURL)
+	for _, group := range p.listGroups() {
+		batches := make(map[consistenthash.NodeID][]*pb.Entry)
+		batchBytes := make(map[consistenthash.NodeID]int)
+		moved := 0
+		// Range 从最新到最旧遍历，先迁移最热的 key
+		group.Range(func(key string, value util.ByteView) bool {
+			if ctx.Err() != nil || moved == limit {
+				return false
+			}
+			from, to, ok := plan.Move(key)
+			if !ok || from != self || to == self {
+				return true
+			}
+			moved++
+			batches[to] = append(batches[to], &pb.Entry{Key: key, Value: value.ByteSlice(), Encoding: value.Encoding})
+			batchBytes[to] += len(key) + value.Size()
+			// 请求体不能超过对端的 maxRequestBody
+			if len(batches[to]) >= transferBatchSize || batchBytes[to] >= transferBatchBytes {
+				p.transfer(group.Name(), to, batches[to])
+				batches[to], batchBytes[to] = nil, 0
+			}
+			return true
+		})
+		for to, entries := range batches {
+			if len(entries) > 0 {
+				p.transfer(group.Name(), to, entries)
+			}
+		}
+	}
+}
+
+// transfer 把一批缓存项发送给 to 节点，并按照 transferRate 限速
+func (p *CacheServer) transfer(group string, to consistenthash.NodeID, entries []*pb.Entry) {
+	p.RLock()
+	getter := p.getters[to]
+	p.RUnlock()
+	if getter == nil {
+		return
+	}
+	var resp pb.TransferResponse
+	if err := getter.Transfer(&pb.TransferRequest{Group: group, Entries: entries}, &resp); err != nil {
+		log.Printf("[Server %s] transfer %d entries of group %s to %s failed: %v", p.selfURL, len(entries), group, to, err)
+	} else {
+		log.Printf("[Server %s] transferred %d entries of group %s to %s", p.selfURL, resp.GetAccepted(), group, to)
+	}
+	if p.transferRate > 0 {
+		time.Sleep(time.Duration(len(entries)) * time.Second / time.Duration(p.transferRate))
 	}
 }
 
 func (p *CacheServer) PickPeer(key string) geecache.PeerGetter {
+	if peers := p.PickPeers(key); len(peers) > 0 {
+		return peers[0]
+	}
+	return nil
+}
+
+// PickPeers 返回保存 key 的所有节点，owner 在前。热点 key 会轮流从不同的副本开始，分散读压力。
+// 与本节点在同一可用区的副本总是排在其他可用区的副本之前，减少跨可用区的流量。
+func (p *CacheServer) PickPeers(key string) []geecache.PeerGetter {
 	p.RLock()
 	defer p.RUnlock()
-	nodeId := p.peers.GetNode(key)
+	nodeIds := p.peers.GetNodes(key, p.replication)
 	// 不要选到自己了,否则会自己请求自己导致无限递归
 	// 哈希到自己也说明了这个key确实缓存未命中，因为能走到PickPeer就是本地缓存未命中
-	if nodeId != consistenthash.NodeID(p.selfURL) {
-		log.Printf("[Server %s] Pick peer %v", p.selfURL, nodeId)
-		return p.getters[nodeId]
+	if len(nodeIds) == 0 || slices.Contains(nodeIds, consistenthash.NodeID(p.selfURL)) {
+		return nil
 	}
-	return nil
+	offset := p.hotKeys.offset(key) % len(nodeIds)
+	nodeIds = slices.Concat(nodeIds[offset:], nodeIds[:offset])
+	slices.SortStableFunc(nodeIds, func(a, b consistenthash.NodeID) int {
+		return p.zoneRank(a) - p.zoneRank(b)
+	})
+	peers := make([]geecache.PeerGetter, 0, len(nodeIds))
+	for _, nodeId := range nodeIds {
+		if getter, ok := p.getters[nodeId]; ok {
+			peers = append(peers, getter)
+		}
+	}
+	log.Printf("[Server %s] Pick peers %v", p.selfURL, nodeIds)
+	return peers
+}
+
+// zoneRank 与本节点在同一可用区的节点返回 0，否则返回 1
+func (p *CacheServer) zoneRank(node consistenthash.NodeID) int {
+	if p.peers.Meta(node).Zone == p.locality.Zone {
+		return 0
+	}
+	return 1
+}
+
+// Replicate 把本节点从数据源加载的值异步推送给 key 的其他持有者
+func (p *CacheServer) Replicate(group, key string, value util.ByteView) {
+	p.RLock()
+	var getters []*httpGetter
+	for _, nodeId := range p.peers.GetNodes(key, p.replication) {
+		if getter, ok := p.getters[nodeId]; ok && nodeId != consistenthash.NodeID(p.selfURL) {
+			getters = append(getters, getter)
+		}
+	}
+	p.RUnlock()
+	if len(getters) == 0 {
+		return
+	}
+	req := &pb.TransferRequest{
+		Group:   group,
+		Entries: []*pb.Entry{{Key: key, Value: value.ByteSlice(), Encoding: value.Encoding}},
+	}
+	go func() {
+		for _, getter := range getters {
+			var resp pb.TransferResponse
+			if err := getter.Transfer(req, &resp); err != nil {
+				log.Printf("[Server %s] replicate %s to %s failed: %v", p.selfURL, key, getter.remoteURL, err)
+			}
+		}
+	}()
+}
+
+// Invalidate 从 key 的所有持有者（本节点除外）的缓存中删除 key
+func (p *CacheServer) Invalidate(group, key string) error {
+	p.RLock()
+	var getters []*httpGetter
+	for _, nodeId := range p.peers.GetNodes(key, p.replication) {
+		if getter, ok := p.getters[nodeId]; ok && nodeId != consistenthash.NodeID(p.selfURL) {
+			getters = append(getters, getter)
+		}
+	}
+	p.RUnlock()
+	var errs []error
+	for _, getter := range getters {
+		if err := getter.Delete(&pb.Request{Group: group, Key: key}); err != nil {
+			errs = append(errs, fmt.Errorf("invalidate %s on %s: %w", key, getter.remoteURL, err))
+		}
+	}
+	return errors.Join(errs...)
 }
 
 type httpGetter struct {
 	// 将要访问的远程节点的地址，例如 http://example.com/_geecache/
 	remoteURL string
+	// 接受的值的最大字节数，<= 0 表示不限制
+	maxValueSize int64
+	// 发送请求使用的客户端，配置了 TLS 时带有客户端证书，为 nil 时使用 http.DefaultClient
+	client *http.Client
+	// 用集群密钥给请求签名，为 nil 时不签名
+	auth *Auth
+}
+
+func (g *httpGetter) httpClient() *http.Client {
+	if g.client == nil {
+		return http.DefaultClient
+	}
+	return g.client
+}
+
+// get 以流式传输的方式请求远程节点，调用者负责关闭返回的响应
+func (g *httpGetter) get(in *pb.Request) (*http.Response, error) {
+	url, err := url.JoinPath(g.remoteURL, url.QueryEscape(in.GetGroup()), url.QueryEscape(in.GetKey()))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", streamContentType)
+	// 显式设置 Accept-Encoding 后，http.Transport 不会再自动解压，压缩过的值原样保存
+	req.Header.Set("Accept-Encoding", strings.Join(compress.Names(), ", "))
+	g.auth.sign(req, nil)
+	resp, err := g.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("server returned: %v", resp.Status)
+	}
+	return resp, nil
 }
 
 func (g *httpGetter) Get(in *pb.Request, out *pb.Response) error {
+	resp, err := g.get(in)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// 对端支持流式传输时，按声明的大小一次性分配内存读取原始字节
+	if resp.Header.Get("Content-Type") == streamContentType {
+		value, err := readStream(resp, g.maxValueSize)
+		if err != nil {
+			return err
+		}
+		out.Value = value
+		out.Encoding = resp.Header.Get("Content-Encoding")
+		return nil
+	}
+
+	bytes, err := io.ReadAll(newLimitedBody(resp.Body, g.maxValueSize))
+	if err != nil {
+		return fmt.Errorf("reading response body: %v", err)
+	}
+	if err := proto.Unmarshal(bytes, out); err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
+	}
+	return nil
+}
+
+// GetStream 以流的方式读取远程节点上的值，值不会完整地读入内存。调用者负责关闭返回的 io.ReadCloser。
+func (g *httpGetter) GetStream(in *pb.Request) (io.ReadCloser, error) {
+	resp, err := g.get(in)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Header.Get("Content-Type") != streamContentType {
+		// 对端不支持流式传输，只能读入完整的 pb.Response
+		defer resp.Body.Close()
+		data, err := io.ReadAll(newLimitedBody(resp.Body, g.maxValueSize))
+		if err != nil {
+			return nil, fmt.Errorf("reading response body: %v", err)
+		}
+		var out pb.Response
+		if err := proto.Unmarshal(data, &out); err != nil {
+			return nil, fmt.Errorf("decoding response body: %v", err)
+		}
+		return compress.NewReader(util.NewByteView(out.GetValue(), out.GetEncoding()))
+	}
+	size, err := valueSize(resp)
+	if err == nil && g.maxValueSize > 0 && size > g.maxValueSize {
+		err = fmt.Errorf("value of %d bytes exceeds the limit of %d bytes", size, g.maxValueSize)
+	}
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	body := newLimitedBody(resp.Body, g.maxValueSize)
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" {
+		return newDecodingBody(body, encoding)
+	}
+	return body, nil
+}
+
+// Transfer 把缓存项推送给远程节点
+func (g *httpGetter) Transfer(in *pb.TransferRequest, out *pb.TransferResponse) error {
+	url, err := url.JoinPath(g.remoteURL, url.QueryEscape(in.GetGroup()), "/")
+	if err != nil {
+		return err
+	}
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	g.auth.sign(req, body)
+	resp, err := g.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned: %v", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading response body: %v", err)
+	}
+	if err := proto.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
+	}
+	return nil
+}
+
+// Set 把 key 的新值写入远程节点的缓存
+func (g *httpGetter) Set(in *pb.SetRequest, out *pb.SetResponse) error {
 	url, err := url.JoinPath(g.remoteURL, url.QueryEscape(in.GetGroup()), url.QueryEscape(in.GetKey()))
 	if err != nil {
 		return err
 	}
-	resp, err := http.Get(url)
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	g.auth.sign(req, body)
+	resp, err := g.httpClient().Do(req)
 	if err != nil {
 		return err
 	}
@@ -137,12 +802,53 @@ func (g *httpGetter) Get(in *pb.Request, out *pb.Response) error {
 		return fmt.Errorf("server returned: %v", resp.Status)
 	}
 
-	bytes, err := io.ReadAll(resp.Body)
+	data, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return fmt.Errorf("reading response body: %v", err)
 	}
-	if err := proto.Unmarshal(bytes, out); err != nil {
+	if err := proto.Unmarshal(data, out); err != nil {
 		return fmt.Errorf("decoding response body: %v", err)
 	}
 	return nil
 }
+
+// Leave 通知远程节点 node 退出了集群
+func (g *httpGetter) Leave(ctx context.Context, node consistenthash.NodeID) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.remoteURL+leavePath, strings.NewReader(string(node)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	g.auth.sign(req, []byte(node))
+	resp, err := g.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("server returned: %v", resp.Status)
+	}
+	return nil
+}
+
+// Delete 从远程节点的缓存中删除 key
+func (g *httpGetter) Delete(in *pb.Request) error {
+	url, err := url.JoinPath(g.remoteURL, url.QueryEscape(in.GetGroup()), url.QueryEscape(in.GetKey()))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		return err
+	}
+	g.auth.sign(req, nil)
+	resp, err := g.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("server returned: %v", resp.Status)
+	}
+	return nil
+}
@@ -1,13 +1,28 @@
 package network
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"geecache"
+	"geecache/compress"
+	"geecache/consistenthash"
+	pb "geecache/proto"
+	"geecache/util"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
 )
 
 var db = map[string]string{
@@ -24,6 +39,7 @@ func TestCacheServer(t *testing.T) {
 		}
 		return nil, fmt.Errorf("%v is not exist", key)
 	}))
+	t.Cleanup(func() { geecache.DeleteGroup(context.Background(), "scores") })
 	server := NewCacheServer(":9999")
 
 	t.Run("Test GET /scores/Tom", func(t *testing.T) {
@@ -38,9 +54,533 @@ func TestCacheServer(t *testing.T) {
 		}
 
 		expectedBody := "630"
-		body := recorder.Body.String()
-		if body != expectedBody {
+		var resp pb.Response
+		if err := proto.Unmarshal(recorder.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decoding response body: %v", err)
+		}
+		if body := string(resp.GetValue()); body != expectedBody {
 			t.Errorf("Expected body %s, got %s", expectedBody, body)
 		}
 	})
 }
+
+// 默认的哈希环必须与 consistenthash.New 的默认值（CRC32）一致，升级时新旧节点对 key 的归属才能达成一致
+func TestDefaultHash(t *testing.T) {
+	nodes := []consistenthash.NodeID{"http://10.0.0.1:8001", "http://10.0.0.2:8001", "http://10.0.0.3:8001"}
+	server := NewCacheServer(string(nodes[0]))
+	server.AddPeers(nodes...)
+	ring := consistenthash.New(defaultReplicas, nil)
+	ring.AddNodes(nodes...)
+	for i := range 1000 {
+		key := strconv.Itoa(i)
+		if got, want := server.peers.GetNodes(key, 1)[0], ring.GetNodes(key, 1)[0]; got != want {
+			t.Fatalf("owner of %s is %s, consistenthash.New says %s", key, got, want)
+		}
+	}
+}
+
+// testValue 是测试数据源中 key 对应的值，以 big 开头的 key 对应 1MiB 的大值
+func testValue(key string) []byte {
+	if strings.HasPrefix(key, "big") {
+		return bytes.Repeat([]byte(key), (1<<20)/len(key))
+	}
+	return []byte("value-" + key)
+}
+
+// testNode 是在本进程内运行的一个缓存节点，拥有自己独立的 group
+type testNode struct {
+	server *CacheServer
+	group  *geecache.Group
+	ts     *httptest.Server
+	// 数据源被访问的次数
+	loads atomic.Int64
+}
+
+// newTestGroup 在注册表 r 中创建 group
+func newTestGroup(t *testing.T, r *geecache.Registry, name string, cacheBytes int64, getter geecache.Getter, opts ...geecache.GroupOption) *geecache.Group {
+	t.Helper()
+	g, err := r.NewGroup(name, cacheBytes, getter, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func newTestNode(t *testing.T, opts ...Option) *testNode {
+	return newTestNodeWithGroup(t, nil, opts...)
+}
+
+func newTestNodeWithGroup(t *testing.T, groupOpts []geecache.GroupOption, opts ...Option) *testNode {
+	n := &testNode{}
+	n.ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n.server.ServeHTTP(w, r)
+	}))
+	t.Cleanup(n.ts.Close)
+	// 每个节点使用独立的注册表，同名的 group 互不影响
+	n.group = newTestGroup(t, geecache.NewRegistry(), "scores", 8<<20, geecache.GetterFunc(func(key string) ([]byte, error) {
+		n.loads.Add(1)
+		return testValue(key), nil
+	}), groupOpts...)
+	n.server = NewCacheServer(n.ts.URL, append(opts, WithGroups(n.group))...)
+	n.group.RegisterPeerPicker(n.server)
+	return n
+}
+
+func (n *testNode) id() consistenthash.NodeID {
+	return consistenthash.NodeID(n.ts.URL)
+}
+
+func TestHandoff(t *testing.T) {
+	a := newTestNode(t, WithTransferRate(0))
+	a.server.AddPeers(a.id())
+	const numKeys = 200
+	for i := range numKeys {
+		if _, err := a.group.Get(strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 新节点 b 加入集群，a 应该把属于 b 的 key 推送给 b
+	b := newTestNode(t)
+	b.server.AddPeers(a.id(), b.id())
+	a.server.AddPeers(b.id())
+
+	var moved []string
+	for i := range numKeys {
+		key := strconv.Itoa(i)
+		if b.server.PickPeer(key) == nil {
+			moved = append(moved, key)
+		}
+	}
+	if len(moved) == 0 {
+		t.Fatalf("expected some keys to move to the new node")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		count := 0
+		b.group.Range(func(key string, _ util.ByteView) bool {
+			count++
+			return true
+		})
+		if count == len(moved) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d keys handed off to the new node, got %d", len(moved), count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 迁移过来的 key 直接命中 b 的本地缓存，不会访问数据源
+	for _, key := range moved {
+		if view, err := b.group.Get(key); err != nil || view.String() != "value-"+key {
+			t.Fatalf("get %s from new node: %v, %v", key, view, err)
+		}
+	}
+	if loads := b.loads.Load(); loads != 0 {
+		t.Fatalf("expected new node to be warmed up by its peer, but it loaded %d keys from source", loads)
+	}
+}
+
+// hasKey 判断 key 是否在节点的本地缓存中，不会触发加载
+func (n *testNode) hasKey(key string) bool {
+	found := false
+	n.group.Range(func(k string, _ util.ByteView) bool {
+		found = k == key
+		return !found
+	})
+	return found
+}
+
+// newTestCluster 在本进程内启动一个由 size 个节点组成的集群
+func newTestCluster(t *testing.T, size int, opts ...Option) map[consistenthash.NodeID]*testNode {
+	cluster := make(map[consistenthash.NodeID]*testNode, size)
+	var ids []consistenthash.NodeID
+	for range size {
+		n := newTestNode(t, opts...)
+		cluster[n.id()] = n
+		ids = append(ids, n.id())
+	}
+	for _, n := range cluster {
+		n.server.AddPeers(ids...)
+	}
+	return cluster
+}
+
+// anyNode 返回集群中的任意一个节点
+func anyNode(cluster map[consistenthash.NodeID]*testNode) *testNode {
+	for _, n := range cluster {
+		return n
+	}
+	return nil
+}
+
+// foreignKey 返回一个不保存在节点 n 上、以 prefix 开头的 key
+func (n *testNode) foreignKey(prefix ...string) string {
+	for i := 0; ; i++ {
+		key := strings.Join(prefix, "") + strconv.Itoa(i)
+		if n.server.PickPeers(key) != nil {
+			return key
+		}
+	}
+}
+
+func TestReplicaFailover(t *testing.T) {
+	cluster := newTestCluster(t, 3, WithReplication(2))
+	a := anyNode(cluster)
+	key := a.foreignKey()
+	holders := a.server.peers.GetNodes(key, 2)
+	owner, replica := cluster[holders[0]], cluster[holders[1]]
+
+	if view, err := a.group.Get(key); err != nil || view.String() != "value-"+key {
+		t.Fatalf("get %s: %v, %v", key, view, err)
+	}
+	if !owner.hasKey(key) {
+		t.Fatalf("owner should have cached %s", key)
+	}
+	// owner 从数据源加载后会把值复制到副本
+	deadline := time.Now().Add(5 * time.Second)
+	for !replica.hasKey(key) {
+		if time.Now().After(deadline) {
+			t.Fatalf("replica never received %s", key)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// owner 挂掉之后，从副本读取，不会再访问数据源
+	owner.ts.Close()
+	if view, err := a.group.Get(key); err != nil || view.String() != "value-"+key {
+		t.Fatalf("get %s after owner died: %v, %v", key, view, err)
+	}
+	var loads int64
+	for _, n := range cluster {
+		loads += n.loads.Load()
+	}
+	if loads != 1 {
+		t.Fatalf("expected the source to be loaded once, got %d", loads)
+	}
+}
+
+func TestHotKeySpread(t *testing.T) {
+	cluster := newTestCluster(t, 3, WithReplication(2), WithHotKeySpread(1))
+	n := anyNode(cluster)
+	key := n.foreignKey()
+	first := make(map[string]bool)
+	for range 4 {
+		peers := n.server.PickPeers(key)
+		if len(peers) != 2 {
+			t.Fatalf("expected 2 replicas, got %d", len(peers))
+		}
+		first[peers[0].(*httpGetter).remoteURL] = true
+	}
+	if len(first) != 2 {
+		t.Fatalf("reads of a hot key should be spread across replicas, got %v", first)
+	}
+}
+
+func TestZoneAwarePeers(t *testing.T) {
+	zones := []string{"zone-a", "zone-b", "zone-c"}
+	nodes := make(map[consistenthash.NodeID]*testNode)
+	metas := make(map[consistenthash.NodeID]consistenthash.Meta)
+	for i := range 6 {
+		meta := consistenthash.Meta{Zone: zones[i%3], Rack: "rack-" + strconv.Itoa(i)}
+		n := newTestNode(t, WithReplication(3), WithLocality(meta))
+		nodes[n.id()] = n
+		metas[n.id()] = meta
+	}
+	for _, n := range nodes {
+		for id, meta := range metas {
+			n.server.AddPeer(id, meta)
+		}
+	}
+
+	for id, n := range nodes {
+		for i := range 100 {
+			key := strconv.Itoa(i)
+			holders := n.server.peers.GetNodes(key, 3)
+			seen := make(map[string]bool)
+			for _, h := range holders {
+				seen[metas[h].Zone] = true
+			}
+			if len(seen) != 3 {
+				t.Fatalf("replicas of %s should span all zones, got %v", key, holders)
+			}
+			peers := n.server.PickPeers(key)
+			if peers == nil {
+				continue
+			}
+			// 每个可用区都有一个副本，第一个候选节点一定和本节点在同一可用区
+			first := consistenthash.NodeID(strings.TrimSuffix(peers[0].(*httpGetter).remoteURL, defaultBasePath))
+			if metas[first].Zone != metas[id].Zone {
+				t.Fatalf("node in %s picked %s in %s first", metas[id].Zone, first, metas[first].Zone)
+			}
+		}
+	}
+
+	// 通过同一可用区的副本读取
+	n := anyNode(nodes)
+	key := n.foreignKey()
+	if view, err := n.group.Get(key); err != nil || view.String() != "value-"+key {
+		t.Fatalf("get %s: %v, %v", key, view, err)
+	}
+}
+
+func TestStreamLargeValue(t *testing.T) {
+	cluster := newTestCluster(t, 2, WithChunkSize(4<<10))
+	a := anyNode(cluster)
+	key := a.foreignKey("big")
+	want := testValue(key)
+
+	rc, err := a.group.GetReader(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("streamed %d bytes, want %d bytes: %v", len(got), len(want), err)
+	}
+	// 值在对端节点上，不会缓存到本节点
+	if a.hasKey(key) {
+		t.Fatalf("streamed value should not be cached locally")
+	}
+	if view, err := a.group.Get(key); err != nil || !view.EqualBytes(want) {
+		t.Fatalf("get %s: %v", key, err)
+	}
+
+	// 服务端使用 chunked 编码分块写出
+	peerURL := a.server.PickPeer(key).(*httpGetter).remoteURL
+	req, _ := http.NewRequest(http.MethodGet, peerURL+"scores/"+key, nil)
+	req.Header.Set("Accept", streamContentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked" {
+		t.Fatalf("expected a chunked response, got %v", resp.TransferEncoding)
+	}
+	if resp.Header.Get(valueSizeHeader) != strconv.Itoa(len(want)) {
+		t.Fatalf("expected value size %d, got %s", len(want), resp.Header.Get(valueSizeHeader))
+	}
+}
+
+func TestMaxValueSize(t *testing.T) {
+	cluster := newTestCluster(t, 2, WithMaxValueSize(64<<10))
+	a := anyNode(cluster)
+	key := a.foreignKey("big")
+	getter := a.server.PickPeer(key).(*httpGetter)
+
+	if _, err := getter.GetStream(&pb.Request{Group: "scores", Key: key}); err == nil || !strings.Contains(err.Error(), "413") {
+		t.Fatalf("expected the peer to refuse a value over the limit, got %v", err)
+	}
+	var resp pb.Response
+	if err := getter.Get(&pb.Request{Group: "scores", Key: key}, &resp); err == nil {
+		t.Fatalf("expected an error for a value over the limit")
+	}
+
+	// 对端不限制时，客户端也会拒绝超过自己限制的值
+	b := anyNode(newTestCluster(t, 2))
+	key = b.foreignKey("big")
+	getter = &httpGetter{remoteURL: b.server.PickPeer(key).(*httpGetter).remoteURL, maxValueSize: 1024}
+	if err := getter.Get(&pb.Request{Group: "scores", Key: b.foreignKey("small")}, &resp); err != nil {
+		t.Fatalf("small value should pass: %v", err)
+	}
+	if err := getter.Get(&pb.Request{Group: "scores", Key: key}, &resp); err == nil {
+		t.Fatalf("expected the client to refuse a value over its limit")
+	}
+	if _, err := getter.GetStream(&pb.Request{Group: "scores", Key: key}); err == nil {
+		t.Fatalf("expected the client to refuse a value over its limit")
+	}
+}
+
+func TestContentEncoding(t *testing.T) {
+	gzipped := []geecache.GroupOption{geecache.WithCompression(compress.Gzip, 64)}
+	a, b := newTestNodeWithGroup(t, gzipped), newTestNodeWithGroup(t, gzipped)
+	for _, n := range []*testNode{a, b} {
+		n.server.AddPeers(a.id(), b.id())
+	}
+	key := a.foreignKey("big")
+	want := testValue(key)
+	if view, err := a.group.Get(key); err != nil || !view.EqualBytes(want) {
+		t.Fatalf("get %s: %v", key, err)
+	}
+
+	get := func(acceptEncoding string) (*http.Response, []byte) {
+		req, _ := http.NewRequest(http.MethodGet, b.ts.URL+defaultBasePath+"scores/"+key, nil)
+		req.Header.Set("Accept", streamContentType)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, body
+	}
+	// 客户端接受 gzip 时直接发送缓存中压缩过的数据
+	resp, body := get("deflate, gzip")
+	if resp.Header.Get("Content-Encoding") != "gzip" || len(body) >= len(want)/4 {
+		t.Fatalf("expected a gzip body, got %q with %d bytes", resp.Header.Get("Content-Encoding"), len(body))
+	}
+	// 否则发送解压后的数据
+	resp, body = get("gzip;q=0, br")
+	if resp.Header.Get("Content-Encoding") != "" || !bytes.Equal(body, want) {
+		t.Fatalf("expected an identity body, got %q with %d bytes", resp.Header.Get("Content-Encoding"), len(body))
+	}
+
+	rc, err := a.server.PickPeer(key).(*httpGetter).GetStream(&pb.Request{Group: "scores", Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if got, err := io.ReadAll(rc); err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("GetStream should decompress: %v", err)
+	}
+}
+
+func TestSet(t *testing.T) {
+	cluster := newTestCluster(t, 3, WithReplication(2))
+	n := anyNode(cluster)
+	key := n.foreignKey("set")
+
+	if err := n.group.Set(key, []byte("written")); err != nil {
+		t.Fatal(err)
+	}
+	// 写请求发给了 key 的 owner，owner 再复制给其他副本
+	var holders []*testNode
+	for _, id := range n.server.peers.GetNodes(key, 2) {
+		holders = append(holders, cluster[id])
+	}
+	if !holders[0].hasKey(key) {
+		t.Fatalf("owner of %s should have the new value", key)
+	}
+	if n.hasKey(key) {
+		t.Fatalf("non-owner should not cache %s", key)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !holders[1].hasKey(key) {
+		if time.Now().After(deadline) {
+			t.Fatalf("replica of %s was not updated", key)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, node := range cluster {
+		if view, err := node.group.Get(key); err != nil || view.String() != "written" {
+			t.Fatalf("get %s from %s: %q, %v", key, node.id(), view.String(), err)
+		}
+		if loads := node.loads.Load(); loads != 0 {
+			t.Fatalf("%s loaded %s from source after Set", node.id(), key)
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	cluster := newTestCluster(t, 3, WithReplication(2))
+	n := anyNode(cluster)
+	key := n.foreignKey("del")
+	if _, err := n.group.Get(key); err != nil {
+		t.Fatal(err)
+	}
+	// 等待 owner 把值复制到副本
+	deadline := time.Now().Add(5 * time.Second)
+	for _, id := range n.server.peers.GetNodes(key, 2) {
+		for !cluster[id].hasKey(key) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s should cache %s", id, key)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if err := n.group.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range cluster {
+		if node.hasKey(key) {
+			t.Fatalf("%s still caches %s after Delete", node.id(), key)
+		}
+	}
+	if st := n.group.Stats(); st.Deletes != 1 || st.PeerLoads != 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	cluster := newTestCluster(t, 3, WithTransferRate(0), WithShutdownHandoff(-1))
+	leaving := anyNode(cluster)
+	var owned []string
+	for i := 0; len(owned) < 50; i++ {
+		key := "shutdown" + strconv.Itoa(i)
+		if leaving.server.PickPeer(key) == nil {
+			owned = append(owned, key)
+			if _, err := leaving.group.Get(key); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := leaving.server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := leaving.group.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leaving.group.Get("shutdown-new"); !errors.Is(err, geecache.ErrClosed) {
+		t.Fatalf("expected ErrClosed after Shutdown, got %v", err)
+	}
+
+	for id, n := range cluster {
+		if id == leaving.id() {
+			continue
+		}
+		// 其他节点收到通知后把退出的节点从哈希环中删除
+		if n.server.peers.HasNode(leaving.id()) {
+			t.Fatalf("%s still has %s in its ring", id, leaving.id())
+		}
+		// 退出的节点已经把缓存项迁移给了新的归属节点，不需要访问数据源
+		for _, key := range owned {
+			if view, err := n.group.Get(key); err != nil || view.String() != "value-"+key {
+				t.Fatalf("get %s from %s: %q, %v", key, id, view.String(), err)
+			}
+		}
+		if loads := n.loads.Load(); loads != 0 {
+			t.Fatalf("%s loaded %d keys from source after handoff", id, loads)
+		}
+	}
+}
+
+func TestServeShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewCacheServer("http://" + l.Addr().String())
+	errCh := make(chan error, 1)
+	go func() { errCh <- server.Serve(l) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Get("http://" + l.Addr().String() + defaultBasePath + "nosuchgroup/key")
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Serve returned %v after Shutdown", err)
+	}
+}
@@ -0,0 +1,154 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"geecache/compress"
+	"geecache/util"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// 节点间以流的方式传输值时使用的 Content-Type。
+	// 客户端在 Accept 中带上它，服务端就直接分块写出值的原始字节，而不是把整个值编码进一个 pb.Response。
+	streamContentType = "application/x-geecache-stream"
+	// 流式传输时服务端告知的值的总大小，客户端据此一次性分配内存，或提前拒绝过大的值
+	valueSizeHeader = "X-Geecache-Value-Size"
+	// 默认每次写出并 Flush 的块大小
+	defaultChunkSize = 64 << 10
+)
+
+// wantsStream 判断客户端是否接受流式传输
+func wantsStream(r *http.Request) bool {
+	return r.Header.Get("Accept") == streamContentType
+}
+
+// writeStream 按 chunkSize 分块写出 value，每块写完立即 Flush，HTTP 层使用 chunked 编码，不需要在内存里再拷贝一份完整的值
+func writeStream(w http.ResponseWriter, value util.ByteView, chunkSize int) {
+	w.Header().Set("Content-Type", streamContentType)
+	w.Header().Set(valueSizeHeader, strconv.Itoa(value.Size()))
+	if value.Encoding != "" {
+		w.Header().Set("Content-Encoding", value.Encoding)
+	}
+	flusher, _ := w.(http.Flusher)
+	for b := value; b.Size() > 0; {
+		n := min(b.Size(), chunkSize)
+		if _, err := b.Slice(0, n).WriteTo(w); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		b = b.Slice(n, b.Size())
+	}
+}
+
+// acceptsEncoding 判断客户端的 Accept-Encoding 是否接受 encoding
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, header := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(header, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			if strings.TrimSpace(name) != encoding {
+				continue
+			}
+			// q=0 表示明确不接受
+			if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// decodingBody 解压响应体，关闭时同时关闭解压器和底层的响应体
+type decodingBody struct {
+	io.ReadCloser
+	body io.Closer
+}
+
+func newDecodingBody(body io.ReadCloser, encoding string) (io.ReadCloser, error) {
+	c, ok := compress.Lookup(encoding)
+	if !ok {
+		body.Close()
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+	}
+	r, err := c.NewReader(body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	return &decodingBody{ReadCloser: r, body: body}, nil
+}
+
+func (b *decodingBody) Close() error {
+	b.ReadCloser.Close()
+	return b.body.Close()
+}
+
+// valueSize 返回响应头中声明的值大小，没有声明时返回 -1
+func valueSize(resp *http.Response) (int64, error) {
+	header := resp.Header.Get(valueSizeHeader)
+	if header == "" {
+		return -1, nil
+	}
+	size, err := strconv.ParseInt(header, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid %s header: %q", valueSizeHeader, header)
+	}
+	return size, nil
+}
+
+// readStream 读取流式响应中的完整值，超过 maxSize（> 0 时）则返回错误
+func readStream(resp *http.Response, maxSize int64) ([]byte, error) {
+	size, err := valueSize(resp)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize > 0 && size > maxSize {
+		return nil, fmt.Errorf("value of %d bytes exceeds the limit of %d bytes", size, maxSize)
+	}
+	var buf bytes.Buffer
+	if size > 0 {
+		buf.Grow(int(size))
+	}
+	if _, err := buf.ReadFrom(newLimitedBody(resp.Body, maxSize)); err != nil {
+		return nil, fmt.Errorf("reading response body: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// limitedBody 在读取的字节数超过 maxSize 时返回错误，防止对端发送超大的值
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	maxSize   int64
+}
+
+func newLimitedBody(body io.ReadCloser, maxSize int64) io.ReadCloser {
+	if maxSize <= 0 {
+		return body
+	}
+	return &limitedBody{ReadCloser: body, remaining: maxSize, maxSize: maxSize}
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, fmt.Errorf("value exceeds the limit of %d bytes", b.maxSize)
+	}
+	// 多读一个字节，用来判断是否超过限制
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n, fmt.Errorf("value exceeds the limit of %d bytes", b.maxSize)
+	}
+	return n, err
+}
@@ -0,0 +1,135 @@
+package network
+
+import (
+	"geecache"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// tcpServer 管理监听和客户端连接，RESPServer 和 MemcacheServer 共用
+type tcpServer struct {
+	mtx      sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+
+	// 累计接受的连接数
+	connections atomic.Int64
+}
+
+// serve 接受 l 上的连接，每个连接启动一个协程调用 handle，直到 close 被调用
+func (s *tcpServer) serve(l net.Listener, handle func(net.Conn)) error {
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	s.listener = l
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.mtx.Unlock()
+	for {
+		conn, err := l.Accept()
+		s.mtx.Lock()
+		if s.closed {
+			s.mtx.Unlock()
+			if conn != nil {
+				conn.Close()
+			}
+			return net.ErrClosed
+		}
+		if err != nil {
+			s.mtx.Unlock()
+			return err
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mtx.Unlock()
+		s.connections.Add(1)
+		go func() {
+			defer func() {
+				s.mtx.Lock()
+				delete(s.conns, conn)
+				s.mtx.Unlock()
+				conn.Close()
+				s.wg.Done()
+			}()
+			handle(conn)
+		}()
+	}
+}
+
+// close 停止监听，关闭所有连接并等待连接的协程退出
+func (s *tcpServer) close() error {
+	s.mtx.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mtx.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// currConnections 返回当前打开的连接数
+func (s *tcpServer) currConnections() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.conns)
+}
+
+// groupSet 是一个服务提供的 group，为 nil 时提供 geecache.NewGroup 注册的所有 group
+type groupSet map[string]*geecache.Group
+
+func newGroupSet(groups []*geecache.Group) groupSet {
+	if len(groups) == 0 {
+		return nil
+	}
+	set := make(groupSet, len(groups))
+	for _, g := range groups {
+		set[g.Name()] = g
+	}
+	return set
+}
+
+func (set groupSet) get(name string) *geecache.Group {
+	if set != nil {
+		return set[name]
+	}
+	return geecache.GetGroup(name)
+}
+
+// list 返回按名称排序的 group
+func (set groupSet) list() []*geecache.Group {
+	if set == nil {
+		return geecache.ListGroups()
+	}
+	groups := make([]*geecache.Group, 0, len(set))
+	for _, g := range set {
+		groups = append(groups, g)
+	}
+	slices.SortFunc(groups, func(a, b *geecache.Group) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return groups
+}
+
+// resolveKey 找到 key 所在的 group："group:key" 形式的 key 使用指定的 group，否则使用 def
+func (set groupSet) resolveKey(key string, def *geecache.Group) (*geecache.Group, string, bool) {
+	if name, rest, ok := strings.Cut(key, ":"); ok {
+		if g := set.get(name); g != nil {
+			return g, rest, true
+		}
+	}
+	return def, key, def != nil
+}
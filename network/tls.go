@@ -0,0 +1,134 @@
+package network
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// TLSConfig 是节点间通信的 TLS 参数。节点的证书既用作服务端证书，开启 mTLS 时也用作访问其他节点的客户端证书。
+//
+// 节点的身份由证书的 SAN 决定：URI SAN 等于节点 ID（例如 https://10.0.0.2:8001），
+// 或者 DNS/IP SAN 与节点 ID 中的主机名匹配。开启 mTLS 之后，只有身份是哈希环上的节点
+// （或者在 AllowedIdentities 中）的客户端才能访问节点间的接口。
+type TLSConfig struct {
+	// 本节点的证书和私钥
+	Certificate tls.Certificate
+	// 验证对端证书的 CA，为 nil 时使用系统的根证书
+	RootCAs *x509.CertPool
+	// 要求客户端提供由 RootCAs 签发的证书（mTLS），并检查客户端的身份
+	RequireClientCert bool
+	// 除了哈希环上的节点之外，还允许访问的客户端身份（URI SAN 或者 DNS/IP SAN），例如 geecachectl 使用的证书
+	AllowedIdentities []string
+}
+
+// LoadTLSConfig 从 PEM 文件中读取证书、私钥和 CA。caFile 为空时使用系统的根证书。
+func LoadTLSConfig(certFile, keyFile, caFile string) (*TLSConfig, error) {
+	cfg := &TLSConfig{}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("network: loading certificate: %w", err)
+		}
+		cfg.Certificate = cert
+	}
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("network: loading CA: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("network: no certificates found in %s", caFile)
+		}
+	}
+	return cfg, nil
+}
+
+// hasCertificate 判断是否配置了本节点的证书
+func (c *TLSConfig) hasCertificate() bool {
+	return len(c.Certificate.Certificate) > 0
+}
+
+// ServerConfig 返回节点间接口的服务端 tls.Config。开启 mTLS 时在握手阶段验证客户端证书由 RootCAs 签发，
+// 客户端的身份由 CacheServer 在处理请求时检查。
+func (c *TLSConfig) ServerConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{c.Certificate},
+	}
+	if c.RequireClientCert {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		cfg.ClientCAs = c.RootCAs
+	}
+	return cfg
+}
+
+// ClientConfig 返回访问其他节点的 tls.Config。服务端证书按照节点 ID 中的主机名验证，
+// 配置了本节点的证书时把它作为客户端证书。
+func (c *TLSConfig) ClientConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    c.RootCAs,
+	}
+	if c.hasCertificate() {
+		cfg.Certificates = []tls.Certificate{c.Certificate}
+	}
+	return cfg
+}
+
+// newClient 返回使用 ClientConfig 的 http.Client
+func (c *TLSConfig) newClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = c.ClientConfig()
+	return &http.Client{Transport: transport}
+}
+
+// errUnknownPeer 表示客户端证书的身份不是已知的节点，返回 403
+var errUnknownPeer = &authError{http.StatusForbidden, "client certificate does not identify a known peer"}
+
+// hasIdentity 判断证书 cert 是否代表 identity。identity 可以是节点 ID 这样的 URL，也可以是主机名或 IP。
+func hasIdentity(cert *x509.Certificate, identity string) bool {
+	host := identity
+	if u, err := url.Parse(identity); err == nil && u.Host != "" {
+		for _, uri := range cert.URIs {
+			if strings.TrimSuffix(uri.String(), "/") == strings.TrimSuffix(identity, "/") {
+				return true
+			}
+		}
+		host = u.Hostname()
+	}
+	return cert.VerifyHostname(host) == nil
+}
+
+// verifyPeer 检查开启 mTLS 时请求 r 的客户端证书是否代表哈希环上的节点或者允许的身份
+func (p *CacheServer) verifyPeer(r *http.Request) error {
+	if p.tls == nil || !p.tls.RequireClientCert {
+		return nil
+	}
+	// 由其他 TLS 终端转发、或者在没有 TLS 的端口上收到的请求没有经过证书验证
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return errUnknownPeer
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	for _, identity := range p.tls.AllowedIdentities {
+		if hasIdentity(cert, identity) {
+			return nil
+		}
+	}
+	p.RLock()
+	defer p.RUnlock()
+	if hasIdentity(cert, p.selfURL) {
+		return nil
+	}
+	for node := range p.getters {
+		if hasIdentity(cert, string(node)) {
+			return nil
+		}
+	}
+	return errUnknownPeer
+}
@@ -0,0 +1,219 @@
+package network
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"geecache"
+	"geecache/consistenthash"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// testCA 是测试中在进程内生成的 CA
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "geecache test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue 签发一个同时用于服务端和客户端的证书，sans 中的 URL 作为 URI SAN，IP 作为 IP SAN
+func (ca *testCA) issue(t *testing.T, sans ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: sans[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, san := range sans {
+		if ip := net.ParseIP(san); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else if u, err := url.Parse(san); err == nil && u.Scheme != "" {
+			tmpl.URIs = append(tmpl.URIs, u)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, san)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// newTLSTestNode 启动一个使用 HTTPS 的节点，证书由 ca 签发，身份是节点 ID 和 127.0.0.1
+func newTLSTestNode(t *testing.T, ca *testCA, mtls bool) *testNode {
+	n := &testNode{}
+	n.ts = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n.server.ServeHTTP(w, r)
+	}))
+	t.Cleanup(n.ts.Close)
+	self := "https://" + n.ts.Listener.Addr().String()
+	cfg := &TLSConfig{
+		Certificate:       ca.issue(t, self, "127.0.0.1"),
+		RootCAs:           ca.pool,
+		RequireClientCert: mtls,
+	}
+	n.group = newTestGroup(t, geecache.NewRegistry(), "scores", 8<<20, geecache.GetterFunc(func(key string) ([]byte, error) {
+		n.loads.Add(1)
+		return testValue(key), nil
+	}))
+	n.server = NewCacheServer(self, WithTLS(cfg), WithGroups(n.group))
+	n.group.RegisterPeerPicker(n.server)
+	n.ts.TLS = cfg.ServerConfig()
+	n.ts.StartTLS()
+	if n.ts.URL != self {
+		t.Fatalf("test server listens on %s, expected %s", n.ts.URL, self)
+	}
+	return n
+}
+
+// tlsGet 用 cfg 作为客户端的 TLS 参数，请求节点 n 上的 key
+func tlsGet(n *testNode, cfg *tls.Config, key string) (*http.Response, error) {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get(n.ts.URL + defaultBasePath + "scores/" + key)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	a, b := newTLSTestNode(t, ca, true), newTLSTestNode(t, ca, true)
+	for _, n := range []*testNode{a, b} {
+		n.server.AddPeers(a.id(), b.id())
+	}
+
+	// a 通过 mTLS 从 b 读取属于 b 的 key
+	key := a.foreignKey()
+	if view, err := a.group.Get(key); err != nil || view.String() != "value-"+key {
+		t.Fatalf("Get(%s) = %q, %v", key, view.String(), err)
+	}
+	if a.loads.Load() != 0 || b.loads.Load() != 1 {
+		t.Fatalf("expected %s to be loaded by its owner, loads a=%d b=%d", key, a.loads.Load(), b.loads.Load())
+	}
+	if err := a.server.Invalidate("scores", key); err != nil {
+		t.Fatalf("Invalidate over mTLS: %v", err)
+	}
+
+	// 没有客户端证书，握手失败
+	if _, err := tlsGet(b, &tls.Config{RootCAs: ca.pool}, key); err == nil {
+		t.Fatalf("request without a client certificate should fail")
+	}
+	// 证书由同一个 CA 签发，但不是哈希环上的节点
+	intruder := ca.issue(t, "https://10.0.0.9:8001", "intruder.example")
+	cfg := &tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{intruder}}
+	if resp, err := tlsGet(b, cfg, key); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unknown peer should be rejected with 403, got %v, %v", resp, err)
+	}
+	if n := b.group.Stats().Unauthorized; n != 1 {
+		t.Fatalf("rejected peer counted %d times, want 1", n)
+	}
+	// 加入哈希环之后就可以访问
+	b.server.AddPeers("https://10.0.0.9:8001")
+	if resp, err := tlsGet(b, cfg, key); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("known peer should be accepted, got %v, %v", resp, err)
+	}
+	b.server.DelPeeker("https://10.0.0.9:8001")
+	// AllowedIdentities 中的身份不需要在哈希环上
+	b.server.tls.AllowedIdentities = []string{"intruder.example"}
+	if resp, err := tlsGet(b, cfg, key); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("allowed identity should be accepted, got %v, %v", resp, err)
+	}
+}
+
+func TestTLSUntrustedPeer(t *testing.T) {
+	a := newTLSTestNode(t, newTestCA(t), false)
+	// b 的证书由另一个 CA 签发，a 不信任 b，读取失败后从数据源加载
+	b := newTLSTestNode(t, newTestCA(t), false)
+	a.server.AddPeers(a.id(), b.id())
+	b.server.AddPeers(a.id(), b.id())
+	key := a.foreignKey()
+	if view, err := a.group.Get(key); err != nil || view.String() != "value-"+key {
+		t.Fatalf("Get(%s) = %q, %v", key, view.String(), err)
+	}
+	if a.loads.Load() != 1 || b.loads.Load() != 0 {
+		t.Fatalf("untrusted peer should not be used, loads a=%d b=%d", a.loads.Load(), b.loads.Load())
+	}
+	// 只开启 TLS 时不要求客户端证书
+	if resp, err := tlsGet(b, &tls.Config{InsecureSkipVerify: true}, key); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("TLS without client auth should accept any client, got %v, %v", resp, err)
+	}
+}
+
+func TestHasIdentity(t *testing.T) {
+	ca := newTestCA(t)
+	leaf, _ := x509.ParseCertificate(ca.issue(t, "https://10.0.0.2:8001", "cache-1.internal").Certificate[0])
+	for _, tt := range []struct {
+		identity consistenthash.NodeID
+		want     bool
+	}{
+		{"https://10.0.0.2:8001", true},
+		{"https://10.0.0.2:8001/", true},
+		{"https://10.0.0.2:8002", false},
+		{"https://cache-1.internal:8001", true},
+		{"https://cache-2.internal:8001", false},
+		{"cache-1.internal", true},
+	} {
+		if got := hasIdentity(leaf, string(tt.identity)); got != tt.want {
+			t.Errorf("hasIdentity(%s) = %v, want %v", tt.identity, got, tt.want)
+		}
+	}
+}
+
+func TestMutualTLSRejectionStats(t *testing.T) {
+	auth := NewAuth(WithClusterSecret([]byte("cluster secret")))
+	server := NewCacheServer("https://10.0.0.2:8001", WithTLS(&TLSConfig{RequireClientCert: true}), WithAuth(auth))
+	// 没有经过 TLS 的请求没有客户端证书
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, defaultBasePath+"scores/Tom", nil))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("got %d, want 403", w.Code)
+	}
+	if st := auth.Stats(); st.Forbidden != 1 || st.Unauthenticated != 0 {
+		t.Fatalf("auth stats = %+v", st)
+	}
+}
@@ -1,6 +1,10 @@
 package geecache
 
-import pb "geecache/proto"
+import (
+	pb "geecache/proto"
+	"geecache/util"
+	"io"
+)
 
 // PeerPicker is the interface that must be implemented to locate
 // the peer that owns a specific key.
@@ -8,9 +12,46 @@ type PeerPicker interface {
 	PickPeer(key string) PeerGetter
 }
 
+// ReplicaPicker is implemented by a PeerPicker that stores each key on
+// several peers.
+// 每个 key 保存在多个节点（owner 和若干副本）上，一个节点挂掉之后可以从其他副本读取。
+type ReplicaPicker interface {
+	PeerPicker
+	// PickPeers 按优先级返回保存 key 的候选节点，依次尝试直到成功。
+	// 本节点就是 key 的持有者之一时返回 nil，直接从数据源加载。
+	PickPeers(key string) []PeerGetter
+	// Replicate 在本节点从数据源加载了 key 之后调用，把值复制到 key 的其他持有者
+	Replicate(group, key string, value util.ByteView)
+}
+
+// Invalidator is implemented by a PeerPicker that can remove a key from
+// the caches of all the peers holding it. See Group.Delete.
+type Invalidator interface {
+	Invalidate(group, key string) error
+}
+
 // PeerGetter is the interface that must be implemented by a peer.
 // PeerGetter 就对应于流程中的 HTTP 客户端
 type PeerGetter interface {
 	// 用于从对应 group 查找缓存值（远程版本的Get）
 	Get(in *pb.Request, out *pb.Response) error
 }
+
+// PeerStreamer is implemented by a PeerGetter that can stream a value
+// instead of returning it in one piece.
+// 用于传输很大的值，值不需要完整地读入内存。
+type PeerStreamer interface {
+	GetStream(in *pb.Request) (io.ReadCloser, error)
+}
+
+// PeerSetter is implemented by a PeerGetter that accepts writes.
+// Group.Set 通过它把新值发给 key 的 owner。
+type PeerSetter interface {
+	Set(in *pb.SetRequest, out *pb.SetResponse) error
+}
+
+// PeerDeleter is implemented by a PeerGetter that can remove a key from the
+// peer's cache.
+type PeerDeleter interface {
+	Delete(in *pb.Request) error
+}
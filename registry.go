@@ -0,0 +1,108 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrGroupExists 表示注册表中已经有同名的 group
+	ErrGroupExists = errors.New("geecache: group already exists")
+	// ErrNoSuchGroup 表示注册表中没有这个 group
+	ErrNoSuchGroup = errors.New("geecache: no such group")
+)
+
+// Registry 是一组按名称索引的 group。包级别的 NewGroup、GetGroup 等函数使用默认的全局注册表；
+// 在一个进程内承载多套互相隔离的 group（例如测试或者多租户的服务）时，可以为每一套创建独立的 Registry。
+type Registry struct {
+	mtx    sync.RWMutex
+	groups map[string]*Group
+}
+
+func NewRegistry() *Registry {
+	return &Registry{groups: make(map[string]*Group)}
+}
+
+var defaultRegistry = NewRegistry()
+
+// NewGroup 创建一个 group 并注册到 r 中，已经有同名的 group 时返回 ErrGroupExists
+func (r *Registry) NewGroup(name string, cacheBytes int64, srcGetter Getter, opts ...GroupOption) (*Group, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if _, ok := r.groups[name]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrGroupExists, name)
+	}
+	g := newGroup(name, cacheBytes, srcGetter, opts)
+	r.groups[name] = g
+	return g, nil
+}
+
+// Get 返回名为 name 的 group，不存在时返回 nil
+func (r *Registry) Get(name string) *Group {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.groups[name]
+}
+
+// List 返回 r 中所有的 group，按名称排序
+func (r *Registry) List() []*Group {
+	r.mtx.RLock()
+	list := make([]*Group, 0, len(r.groups))
+	for _, g := range r.groups {
+		list = append(list, g)
+	}
+	r.mtx.RUnlock()
+	slices.SortFunc(list, func(a, b *Group) int {
+		return strings.Compare(a.name, b.name)
+	})
+	return list
+}
+
+// Delete 从 r 中删除名为 name 的 group 并关闭它（见 Group.Shutdown）。
+// group 立即从注册表中删除，之后可以用同样的名字创建新的 group；ctx 只限制等待关闭的时间。
+func (r *Registry) Delete(ctx context.Context, name string) error {
+	r.mtx.Lock()
+	g, ok := r.groups[name]
+	delete(r.groups, name)
+	r.mtx.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoSuchGroup, name)
+	}
+	return g.Shutdown(ctx)
+}
+
+// NewSnapshotter 创建保存和恢复 r 中所有 group 的 Snapshotter，见 NewSnapshotter
+func (r *Registry) NewSnapshotter(dir string, interval time.Duration) *Snapshotter {
+	return &Snapshotter{dir: dir, interval: interval, registry: r}
+}
+
+// NewGroup 创建一个 group 并注册到全局注册表中。
+// 已经有同名的 group 时 panic，需要处理这种情况时使用 Registry.NewGroup，或者先调用 DeleteGroup。
+func NewGroup(name string, cacheBytes int64, srcGetter Getter, opts ...GroupOption) *Group {
+	g, err := defaultRegistry.NewGroup(name, cacheBytes, srcGetter, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// GetGroup returns the named group previously created with NewGroup, or
+// nil if there's no such group.
+func GetGroup(name string) *Group {
+	return defaultRegistry.Get(name)
+}
+
+// ListGroups returns all the groups created with NewGroup, sorted by name.
+func ListGroups() []*Group {
+	return defaultRegistry.List()
+}
+
+// DeleteGroup 从全局注册表中删除 group 并关闭它，见 Registry.Delete
+func DeleteGroup(ctx context.Context, name string) error {
+	return defaultRegistry.Delete(ctx, name)
+}
@@ -0,0 +1,69 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	getter := func(value string) Getter {
+		return GetterFunc(func(key string) ([]byte, error) {
+			return []byte(value), nil
+		})
+	}
+	scores, err := r.NewGroup("scores", 1<<20, getter("630"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.NewGroup("users", 1<<20, getter("Tom")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.NewGroup("scores", 1<<20, getter("589")); !errors.Is(err, ErrGroupExists) {
+		t.Fatalf("expected ErrGroupExists, got %v", err)
+	}
+	if r.Get("scores") != scores {
+		t.Fatalf("the first group should be kept")
+	}
+	// 不同的注册表互相隔离
+	if GetGroup("scores") == scores || NewRegistry().Get("scores") != nil {
+		t.Fatalf("registries should be isolated")
+	}
+	var names []string
+	for _, g := range r.List() {
+		names = append(names, g.Name())
+	}
+	if len(names) != 2 || names[0] != "scores" || names[1] != "users" {
+		t.Fatalf("List() = %v", names)
+	}
+
+	// 更换数据源只影响之后的加载
+	if view, err := scores.Get("Tom"); err != nil || view.String() != "630" {
+		t.Fatalf("Get(Tom) = %q, %v", view.String(), err)
+	}
+	scores.SetGetter(getter("589"))
+	if view, err := scores.Get("Tom"); err != nil || view.String() != "630" {
+		t.Fatalf("cached value should not change: %q, %v", view.String(), err)
+	}
+	if view, err := scores.Get("Jack"); err != nil || view.String() != "589" {
+		t.Fatalf("Get(Jack) = %q, %v", view.String(), err)
+	}
+
+	// 删除之后 group 被关闭，名字可以重新使用
+	if err := r.Delete(context.Background(), "scores"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Delete(context.Background(), "scores"); !errors.Is(err, ErrNoSuchGroup) {
+		t.Fatalf("expected ErrNoSuchGroup, got %v", err)
+	}
+	if _, err := scores.Get("Sam"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("deleted group should be closed, got %v", err)
+	}
+	if r.Get("scores") != nil || len(r.List()) != 1 {
+		t.Fatalf("group should be removed from the registry")
+	}
+	if _, err := r.NewGroup("scores", 1<<20, getter("567")); err != nil {
+		t.Fatalf("name should be reusable after Delete: %v", err)
+	}
+}
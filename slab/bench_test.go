@@ -0,0 +1,136 @@
+package slab
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"geecache/lru"
+)
+
+// 和 geecache 默认使用的 list+map LRU 对比吞吐和 GC 停顿，运行：
+//
+//	go test ./slab -run '^$' -bench . -benchmem
+
+type bytesValue []byte
+
+func (b bytesValue) Size() int {
+	return len(b)
+}
+
+const (
+	benchEntries   = 1 << 20
+	benchValueSize = 64
+)
+
+var benchKeys = func() []string {
+	keys := make([]string, benchEntries)
+	for i := range keys {
+		keys[i] = "key-" + strconv.Itoa(i)
+	}
+	return keys
+}()
+
+// backend 是两种实现共同的读写接口
+type backend interface {
+	put(key string, value []byte)
+	get(key string, dst []byte) ([]byte, bool)
+}
+
+type lruBackend struct{ c *lru.Cache }
+
+func (b lruBackend) put(key string, value []byte) {
+	// geecache 写入缓存前会复制一份值
+	b.c.Put(key, bytesValue(append([]byte(nil), value...)))
+}
+
+func (b lruBackend) get(key string, dst []byte) ([]byte, bool) {
+	v, ok := b.c.Get(key)
+	if !ok {
+		return dst, false
+	}
+	return append(dst, v.(bytesValue)...), true
+}
+
+type slabBackend struct{ c *Cache }
+
+func (b slabBackend) put(key string, value []byte) {
+	b.c.Put(key, value)
+}
+
+func (b slabBackend) get(key string, dst []byte) ([]byte, bool) {
+	return b.c.Get(key, dst)
+}
+
+var backends = []struct {
+	name string
+	new  func(maxBytes int64) backend
+}{
+	{"lru", func(maxBytes int64) backend { return lruBackend{lru.New(maxBytes, nil)} }},
+	{"slab", func(maxBytes int64) backend { return slabBackend{New(maxBytes, nil)} }},
+}
+
+// fill 写入 n 个缓存项
+func fill(be backend, n int) {
+	value := make([]byte, benchValueSize)
+	for _, key := range benchKeys[:n] {
+		be.put(key, value)
+	}
+}
+
+func BenchmarkPut(b *testing.B) {
+	for _, bk := range backends {
+		b.Run(bk.name, func(b *testing.B) {
+			// 容量只能放下一部分缓存项，写入的同时会淘汰
+			be := bk.new(benchEntries / 4 * (benchValueSize + 64))
+			value := make([]byte, benchValueSize)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := range b.N {
+				be.put(benchKeys[i%benchEntries], value)
+			}
+		})
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	for _, bk := range backends {
+		b.Run(bk.name, func(b *testing.B) {
+			be := bk.new(1 << 30)
+			fill(be, benchEntries)
+			buf := make([]byte, 0, benchValueSize)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := range b.N {
+				buf, _ = be.get(benchKeys[i%benchEntries], buf[:0])
+			}
+		})
+	}
+}
+
+// BenchmarkGC 在缓存中有 1M 个缓存项时测量一次完整 GC 的耗时和 STW 停顿。
+// list+map LRU 的每个缓存项有好几个指针需要 GC 扫描，slab 的 page 和索引都不含指针。
+func BenchmarkGC(b *testing.B) {
+	for _, bk := range backends {
+		b.Run(bk.name, func(b *testing.B) {
+			be := bk.new(1 << 30)
+			fill(be, benchEntries)
+			runtime.GC()
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			b.ResetTimer()
+			start := time.Now()
+			for range b.N {
+				runtime.GC()
+			}
+			elapsed := time.Since(start)
+			b.StopTimer()
+			runtime.ReadMemStats(&after)
+			runtime.KeepAlive(be)
+			b.ReportMetric(float64(after.PauseTotalNs-before.PauseTotalNs)/float64(b.N), "pause-ns/op")
+			b.ReportMetric(float64(elapsed.Nanoseconds())/float64(b.N), "gc-ns/op")
+			b.ReportMetric(float64(after.HeapObjects), "heap-objects")
+		})
+	}
+}
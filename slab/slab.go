@@ -0,0 +1,411 @@
+// Package slab 是把 key 和值保存在预先分配的大块内存（page）中的 LRU 缓存。
+//
+// lru.Cache 的每个缓存项都有 list.Element、Entry、key 字符串和值切片等多个堆对象，
+// 缓存项很多时 GC 扫描这些指针会占用大量 CPU。slab.Cache 中的缓存项不含任何指针：
+// page 是 []byte，索引是 map[uint64]uint64（key 的哈希 -> 位置），LRU 链表用 chunk 编号串联，
+// GC 需要扫描的对象个数只和 page 的个数有关，与缓存项的个数无关。
+//
+// 与 memcached 类似，page 按 chunk 大小分成若干 slab class，每个缓存项保存在能放下它的最小的 chunk 中，
+// 每个 class 有自己的 LRU 链表。没有空闲的 chunk、也不能再分配 page 时，淘汰这个 class 中最久未使用的项；
+// 这个 class 还没有任何 page 时，从 page 最多的 class 回收一个 page。
+package slab
+
+import (
+	"cmp"
+	"encoding/binary"
+	"hash/maphash"
+	"slices"
+)
+
+const (
+	// DefaultPageSize 是默认的 page 大小，也是单个缓存项（包括 key 和 header）的最大字节数
+	DefaultPageSize = 1 << 20
+	// 最小的 chunk 大小，以及相邻 class 的 chunk 大小之比
+	minChunkSize = 64
+	growthFactor = 1.25
+	// chunk 的 header：prev、next、hash、access、keyLen、valueLen
+	headerSize = 32
+	// LRU 链表中表示空的 chunk 编号，chunk 编号从 1 开始
+	nilChunk = 0
+)
+
+// slabClass 是大小相同的 chunk 的集合
+type slabClass struct {
+	size    int
+	perPage int
+	// 属于这个 class 的 page 在 Cache.pages 中的下标，chunk 编号 id 在第 (id-1)/perPage 个 page 中
+	pages []uint32
+	// 空闲的 chunk 编号
+	free []uint32
+	// LRU 链表，head 最新，tail 最旧
+	head, tail uint32
+	items      int
+}
+
+// Cache 是基于 slab 分配的 LRU 缓存，不是并发安全的
+type Cache struct {
+	maxBytes int64
+	pageSize int
+	// 已经分配的 page，回收的 page 置为 nil，下标放入 freePages
+	pages     [][]byte
+	freePages []uint32
+	// 当前持有内存的 page 个数
+	livePages int
+	classes   []slabClass
+	// key 的哈希 -> 位置（class << 32 | chunk 编号）
+	index map[uint64]uint64
+	seed  maphash.Seed
+	// 每次访问递增，记录在 chunk 中，Range 按它从新到旧排序
+	clock  uint64
+	nbytes int64
+	// 缓存项被淘汰时调用（主动 Remove 的项除外），value 只在回调期间有效
+	OnEvicted func(key string, value []byte)
+}
+
+// Option 用于配置 Cache
+type Option func(*Cache)
+
+// WithPageSize 设置 page 的大小，默认 1MiB。缓存项不能超过一个 page，maxBytes 按整 page 使用。
+// New 的 maxBytes 小于 page 时，page 缩小到 maxBytes。
+func WithPageSize(n int) Option {
+	return func(c *Cache) {
+		if n >= minChunkSize {
+			c.pageSize = n
+		}
+	}
+}
+
+// New 创建最多使用 maxBytes 字节内存的缓存，page 在需要时才分配
+func New(maxBytes int64, onEvicted func(key string, value []byte), opts ...Option) *Cache {
+	c := &Cache{
+		maxBytes:  maxBytes,
+		pageSize:  DefaultPageSize,
+		index:     make(map[uint64]uint64),
+		seed:      maphash.MakeSeed(),
+		OnEvicted: onEvicted,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	// 容量不足一个 page 时缩小 page，否则一个 page 也分配不了，什么都存不下
+	if maxBytes > 0 && maxBytes < int64(c.pageSize) {
+		c.pageSize = max(int(maxBytes)&^7, minChunkSize)
+	}
+	for size := minChunkSize; ; size = int(float64(size) * growthFactor) {
+		// chunk 按 8 字节对齐，最后一个 class 正好是一个 page
+		size = min((size+7)&^7, c.pageSize)
+		c.classes = append(c.classes, slabClass{size: size, perPage: c.pageSize / size})
+		if size == c.pageSize {
+			break
+		}
+	}
+	return c
+}
+
+// classFor 返回能放下 n 字节的最小的 class，放不下时返回 -1
+func (c *Cache) classFor(n int) int {
+	i, _ := slices.BinarySearchFunc(c.classes, n, func(class slabClass, n int) int {
+		return class.size - n
+	})
+	if i == len(c.classes) {
+		return -1
+	}
+	return i
+}
+
+// chunk 返回 class 中编号为 id 的 chunk 的内存
+func (c *Cache) chunk(class int, id uint32) []byte {
+	sc := &c.classes[class]
+	page, slot := int(id-1)/sc.perPage, int(id-1)%sc.perPage
+	return c.pages[sc.pages[page]][slot*sc.size : (slot+1)*sc.size]
+}
+
+func location(class int, id uint32) uint64 {
+	return uint64(class)<<32 | uint64(id)
+}
+
+func splitLocation(loc uint64) (int, uint32) {
+	return int(loc >> 32), uint32(loc)
+}
+
+// header 的读写
+func prevOf(chunk []byte) uint32   { return binary.LittleEndian.Uint32(chunk[0:]) }
+func nextOf(chunk []byte) uint32   { return binary.LittleEndian.Uint32(chunk[4:]) }
+func hashOf(chunk []byte) uint64   { return binary.LittleEndian.Uint64(chunk[8:]) }
+func accessOf(chunk []byte) uint64 { return binary.LittleEndian.Uint64(chunk[16:]) }
+
+func setPrev(chunk []byte, id uint32) { binary.LittleEndian.PutUint32(chunk[0:], id) }
+func setNext(chunk []byte, id uint32) { binary.LittleEndian.PutUint32(chunk[4:], id) }
+
+// keyOf 和 valueOf 返回的切片指向 chunk 的内存
+func keyOf(chunk []byte) []byte {
+	n := binary.LittleEndian.Uint32(chunk[24:])
+	return chunk[headerSize : headerSize+n]
+}
+
+func valueOf(chunk []byte) []byte {
+	k, v := binary.LittleEndian.Uint32(chunk[24:]), binary.LittleEndian.Uint32(chunk[28:])
+	return chunk[headerSize+k : headerSize+k+v]
+}
+
+// lookup 返回 key 所在的位置
+func (c *Cache) lookup(key string) (class int, id uint32, ok bool) {
+	loc, ok := c.index[maphash.String(c.seed, key)]
+	if !ok {
+		return 0, 0, false
+	}
+	class, id = splitLocation(loc)
+	// 哈希冲突时 key 不同
+	if string(keyOf(c.chunk(class, id))) != key {
+		return 0, 0, false
+	}
+	return class, id, true
+}
+
+// Get 返回 key 的值，追加到 dst 之后返回
+func (c *Cache) Get(key string, dst []byte) ([]byte, bool) {
+	class, id, ok := c.lookup(key)
+	if !ok {
+		return dst, false
+	}
+	c.touch(class, id)
+	return append(dst, valueOf(c.chunk(class, id))...), true
+}
+
+// Put 写入 key，缓存项超过一个 page 时不保存并返回 false（同时删除 key 的旧值）
+func (c *Cache) Put(key string, value []byte) bool {
+	h := maphash.String(c.seed, key)
+	if loc, ok := c.index[h]; ok {
+		// 旧值或者哈希冲突的另一个 key 都直接删除
+		c.free(splitLocation(loc))
+	}
+	class := c.classFor(headerSize + len(key) + len(value))
+	if class < 0 {
+		return false
+	}
+	id, ok := c.alloc(class)
+	if !ok {
+		return false
+	}
+	chunk := c.chunk(class, id)
+	binary.LittleEndian.PutUint64(chunk[8:], h)
+	binary.LittleEndian.PutUint32(chunk[24:], uint32(len(key)))
+	binary.LittleEndian.PutUint32(chunk[28:], uint32(len(value)))
+	copy(chunk[headerSize:], key)
+	copy(chunk[headerSize+len(key):], value)
+	c.index[h] = location(class, id)
+	c.pushFront(class, id)
+	c.classes[class].items++
+	c.nbytes += int64(c.classes[class].size)
+	return true
+}
+
+// Remove 删除 key，返回 key 是否存在。主动删除的项不会触发 OnEvicted。
+func (c *Cache) Remove(key string) bool {
+	class, id, ok := c.lookup(key)
+	if ok {
+		c.free(class, id)
+	}
+	return ok
+}
+
+// touch 把 chunk 移到 LRU 链表的头部
+func (c *Cache) touch(class int, id uint32) {
+	c.unlink(class, id)
+	c.pushFront(class, id)
+}
+
+func (c *Cache) pushFront(class int, id uint32) {
+	sc := &c.classes[class]
+	chunk := c.chunk(class, id)
+	c.clock++
+	binary.LittleEndian.PutUint64(chunk[16:], c.clock)
+	setPrev(chunk, nilChunk)
+	setNext(chunk, sc.head)
+	if sc.head != nilChunk {
+		setPrev(c.chunk(class, sc.head), id)
+	} else {
+		sc.tail = id
+	}
+	sc.head = id
+}
+
+func (c *Cache) unlink(class int, id uint32) {
+	sc := &c.classes[class]
+	chunk := c.chunk(class, id)
+	prev, next := prevOf(chunk), nextOf(chunk)
+	if prev != nilChunk {
+		setNext(c.chunk(class, prev), next)
+	} else {
+		sc.head = next
+	}
+	if next != nilChunk {
+		setPrev(c.chunk(class, next), prev)
+	} else {
+		sc.tail = prev
+	}
+}
+
+// free 删除 chunk 中的缓存项，把 chunk 放回空闲列表
+func (c *Cache) free(class int, id uint32) {
+	sc := &c.classes[class]
+	chunk := c.chunk(class, id)
+	delete(c.index, hashOf(chunk))
+	c.unlink(class, id)
+	// 清空 header，回收 page 时不会把空闲的 chunk 当成缓存项
+	clear(chunk[:headerSize])
+	sc.free = append(sc.free, id)
+	sc.items--
+	c.nbytes -= int64(sc.size)
+}
+
+// evict 淘汰 chunk 中的缓存项并调用 OnEvicted
+func (c *Cache) evict(class int, id uint32) {
+	if c.OnEvicted != nil {
+		chunk := c.chunk(class, id)
+		c.OnEvicted(string(keyOf(chunk)), valueOf(chunk))
+	}
+	c.free(class, id)
+}
+
+// alloc 为 class 分配一个 chunk：先用空闲的 chunk，再分配新的 page，最后淘汰
+func (c *Cache) alloc(class int) (uint32, bool) {
+	sc := &c.classes[class]
+	if len(sc.free) == 0 && !c.addPage(class) {
+		switch {
+		case sc.tail != nilChunk:
+			c.evict(class, sc.tail)
+		case c.stealPage(class):
+		default:
+			return 0, false
+		}
+	}
+	id := sc.free[len(sc.free)-1]
+	sc.free = sc.free[:len(sc.free)-1]
+	return id, true
+}
+
+// addPage 在不超过 maxBytes 的前提下给 class 分配一个新的 page
+func (c *Cache) addPage(class int) bool {
+	if int64(c.livePages+1)*int64(c.pageSize) > c.maxBytes {
+		return false
+	}
+	var page uint32
+	if n := len(c.freePages); n > 0 {
+		page = c.freePages[n-1]
+		c.freePages = c.freePages[:n-1]
+		c.pages[page] = make([]byte, c.pageSize)
+	} else {
+		page = uint32(len(c.pages))
+		c.pages = append(c.pages, make([]byte, c.pageSize))
+	}
+	c.livePages++
+	c.assignPage(class, page)
+	return true
+}
+
+// assignPage 把 page 交给 class，page 中的 chunk 都是空闲的
+func (c *Cache) assignPage(class int, page uint32) {
+	sc := &c.classes[class]
+	sc.pages = append(sc.pages, page)
+	first := uint32((len(sc.pages)-1)*sc.perPage) + 1
+	// 倒序放入，先分配编号小的 chunk
+	for i := sc.perPage - 1; i >= 0; i-- {
+		sc.free = append(sc.free, first+uint32(i))
+	}
+}
+
+// releasePage 淘汰 class 最后一个 page 中的所有缓存项，把 page 从 class 中移除并返回它的下标
+func (c *Cache) releasePage(class int) uint32 {
+	sc := &c.classes[class]
+	last := len(sc.pages) - 1
+	first := uint32(last*sc.perPage) + 1
+	end := first + uint32(sc.perPage)
+	for id := first; id < end; id++ {
+		chunk := c.chunk(class, id)
+		if loc, ok := c.index[hashOf(chunk)]; ok && loc == location(class, id) {
+			c.evict(class, id)
+		}
+	}
+	sc.free = slices.DeleteFunc(sc.free, func(id uint32) bool { return id >= first })
+	page := sc.pages[last]
+	sc.pages = sc.pages[:last]
+	clear(c.pages[page])
+	return page
+}
+
+// stealPage 从 page 最多的其他 class 回收一个 page 给 class
+func (c *Cache) stealPage(class int) bool {
+	victim := -1
+	for i := range c.classes {
+		if i != class && len(c.classes[i].pages) > 0 && (victim < 0 || len(c.classes[i].pages) > len(c.classes[victim].pages)) {
+			victim = i
+		}
+	}
+	if victim < 0 {
+		return false
+	}
+	c.assignPage(class, c.releasePage(victim))
+	return true
+}
+
+// SetMaxBytes 修改容量上限，超出上限的 page 立即回收（其中的缓存项会触发 OnEvicted）
+func (c *Cache) SetMaxBytes(maxBytes int64) {
+	c.maxBytes = maxBytes
+	for int64(c.livePages)*int64(c.pageSize) > maxBytes {
+		victim := 0
+		for i := range c.classes {
+			if len(c.classes[i].pages) > len(c.classes[victim].pages) {
+				victim = i
+			}
+		}
+		page := c.releasePage(victim)
+		c.pages[page] = nil
+		c.freePages = append(c.freePages, page)
+		c.livePages--
+	}
+}
+
+// MaxBytes 返回容量上限
+func (c *Cache) MaxBytes() int64 {
+	return c.maxBytes
+}
+
+// Len 返回缓存项的个数
+func (c *Cache) Len() int {
+	return len(c.index)
+}
+
+// Bytes 返回缓存项占用的 chunk 的总字节数
+func (c *Cache) Bytes() int64 {
+	return c.nbytes
+}
+
+// PageBytes 返回已经分配的 page 的总字节数，也就是缓存实际占用的内存
+func (c *Cache) PageBytes() int64 {
+	return int64(c.livePages) * int64(c.pageSize)
+}
+
+// Range 从最新到最旧依次遍历缓存项，fn 返回 false 时停止遍历。遍历不会更新缓存项的新旧顺序。
+// value 只在 fn 调用期间有效，fn 中不能修改缓存。
+func (c *Cache) Range(fn func(key string, value []byte) bool) {
+	type item struct {
+		access uint64
+		loc    uint64
+	}
+	items := make([]item, 0, len(c.index))
+	for class := range c.classes {
+		for id := c.classes[class].head; id != nilChunk; id = nextOf(c.chunk(class, id)) {
+			items = append(items, item{accessOf(c.chunk(class, id)), location(class, id)})
+		}
+	}
+	slices.SortFunc(items, func(a, b item) int {
+		return cmp.Compare(b.access, a.access)
+	})
+	for _, it := range items {
+		chunk := c.chunk(splitLocation(it.loc))
+		if !fn(string(keyOf(chunk)), valueOf(chunk)) {
+			return
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package slab
+
+import (
+	"bytes"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestGetPut(t *testing.T) {
+	c := New(1<<20, nil, WithPageSize(4096))
+	if !c.Put("Tom", []byte("630")) || !c.Put("Jack", []byte("589")) {
+		t.Fatal("Put failed")
+	}
+	if v, ok := c.Get("Tom", nil); !ok || string(v) != "630" {
+		t.Fatalf("Get(Tom) = %q, %v", v, ok)
+	}
+	// 覆盖旧值，新值可能在另一个 class 中
+	c.Put("Tom", bytes.Repeat([]byte("x"), 500))
+	if v, ok := c.Get("Tom", nil); !ok || len(v) != 500 || c.Len() != 2 {
+		t.Fatalf("Get(Tom) after overwrite = %d bytes, %v, len %d", len(v), ok, c.Len())
+	}
+	if !c.Remove("Tom") || c.Remove("Tom") {
+		t.Fatal("Remove should report whether the key existed")
+	}
+	if _, ok := c.Get("Tom", nil); ok || c.Len() != 1 {
+		t.Fatal("Tom should be removed")
+	}
+	// 超过一个 page 的缓存项不保存
+	if c.Put("big", make([]byte, 4096)) {
+		t.Fatal("an item larger than a page should be rejected")
+	}
+	if v, _ := c.Get("Jack", []byte("score=")); string(v) != "score=589" {
+		t.Fatalf("Get should append to dst, got %q", v)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	var evicted []string
+	value := func(key string) []byte {
+		if key == "small" {
+			return []byte("v")
+		}
+		return append([]byte("value-"+key), make([]byte, 800)...)
+	}
+	// 两个 page，每个 page 放 4 个 872 字节的 chunk
+	c := New(2*4096, func(key string, v []byte) {
+		if !bytes.Equal(v, value(key)) {
+			t.Errorf("evicted %s with unexpected value", key)
+		}
+		evicted = append(evicted, key)
+	}, WithPageSize(4096))
+	for i := range 8 {
+		c.Put(strconv.Itoa(i), value(strconv.Itoa(i)))
+	}
+	if len(evicted) != 0 || c.PageBytes() != 2*4096 {
+		t.Fatalf("8 items should fit in 2 pages, evicted %v, %d page bytes", evicted, c.PageBytes())
+	}
+	// 访问 0 之后，最久未使用的是 1
+	c.Get("0", nil)
+	c.Put("8", value("8"))
+	if len(evicted) != 1 || evicted[0] != "1" {
+		t.Fatalf("expected 1 to be evicted, got %v", evicted)
+	}
+
+	// 小的 class 没有 page，从大的 class 回收一个 page
+	evicted = nil
+	c.Put("small", value("small"))
+	if len(evicted) != 4 {
+		t.Fatalf("expected a page of 4 items to be reclaimed, got %v", evicted)
+	}
+	if v, ok := c.Get("small", nil); !ok || string(v) != "v" {
+		t.Fatalf("Get(small) = %q, %v", v, ok)
+	}
+
+	// 缩小容量时回收 page
+	evicted = nil
+	n := c.Len()
+	c.SetMaxBytes(4096)
+	if c.PageBytes() != 4096 || len(evicted) == 0 || c.Len() != n-len(evicted) {
+		t.Fatalf("SetMaxBytes should release a page: %d page bytes, evicted %v, len %d", c.PageBytes(), evicted, c.Len())
+	}
+}
+
+func TestRange(t *testing.T) {
+	c := New(1<<20, nil, WithPageSize(4096))
+	for i, size := range []int{10, 200, 10, 2000, 10} {
+		c.Put(strconv.Itoa(i), make([]byte, size))
+	}
+	c.Get("1", nil)
+	var keys []string
+	c.Range(func(key string, value []byte) bool {
+		keys = append(keys, key)
+		return true
+	})
+	// 跨 class 按访问时间从新到旧排列
+	if want := []string{"1", "4", "3", "2", "0"}; !slicesEqual(keys, want) {
+		t.Fatalf("Range order %v, want %v", keys, want)
+	}
+}
+
+func slicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestRandom 随机读写，和一个 map 对比：缓存中的值一定是最后写入的值，不在缓存中的 key 一定被淘汰或删除过
+func TestRandom(t *testing.T) {
+	model := make(map[string][]byte)
+	c := New(8*4096, func(key string, value []byte) {
+		if !bytes.Equal(value, model[key]) {
+			t.Fatalf("evicted %s with a stale value", key)
+		}
+		delete(model, key)
+	}, WithPageSize(4096))
+	rnd := rand.New(rand.NewSource(1))
+	for i := range 20000 {
+		key := strconv.Itoa(rnd.Intn(300))
+		switch op := rnd.Intn(10); {
+		case op < 5:
+			value := bytes.Repeat([]byte{byte(i)}, rnd.Intn(1500))
+			if c.Put(key, value) {
+				model[key] = value
+			} else {
+				delete(model, key)
+			}
+		case op < 9:
+			v, ok := c.Get(key, nil)
+			want, exists := model[key]
+			if ok != exists || !bytes.Equal(v, want) {
+				t.Fatalf("op %d: Get(%s) = %d bytes, %v; want %d bytes, %v", i, key, len(v), ok, len(want), exists)
+			}
+		default:
+			if c.Remove(key) != (model[key] != nil) {
+				t.Fatalf("op %d: Remove(%s) disagrees with the model", i, key)
+			}
+			delete(model, key)
+		}
+		if c.Len() != len(model) || c.PageBytes() > 8*4096 {
+			t.Fatalf("op %d: len %d, model %d, page bytes %d", i, c.Len(), len(model), c.PageBytes())
+		}
+	}
+}
+
+func TestSmallMaxBytes(t *testing.T) {
+	// 容量小于默认的 page 时，page 缩小到容量，缓存仍然可用
+	c := New(512<<10, nil)
+	if !c.Put("k", make([]byte, 1000)) || c.Len() != 1 {
+		t.Fatalf("Put into a cache smaller than the default page failed, len %d", c.Len())
+	}
+	if _, ok := c.Get("k", nil); !ok {
+		t.Fatal("Get(k) missed")
+	}
+	if c.PageBytes() > 512<<10 {
+		t.Fatalf("allocated %d bytes, more than maxBytes", c.PageBytes())
+	}
+	if c.Put("big", make([]byte, 512<<10)) {
+		t.Fatal("an item larger than maxBytes should be rejected")
+	}
+}
@@ -0,0 +1,391 @@
+package geecache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"geecache/util"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+/*
+快照文件格式（所有整数都是 uvarint 编码，除非特别说明）：
+
+	magic    "GCSN"
+	version  1 字节
+	count    缓存项个数
+	entry * count，按 LRU 从旧到新排列，恢复时依次 Put 即可还原 LRU 顺序
+		key       长度 + 内容
+		encoding  长度 + 内容（压缩算法，见 util.ByteView.Encoding）
+		value     长度 + 内容
+		expiry    8 字节小端，过期时间的 Unix 纳秒时间戳，0 表示不过期
+		crc       4 字节小端，本条记录以上所有字节的 CRC-32C
+	trailer  4 字节小端，从 magic 到最后一条记录的所有字节的 CRC-32C，用于发现文件被截断
+*/
+
+const (
+	snapshotMagic   = "GCSN"
+	snapshotVersion = 1
+	// 防止损坏的长度字段导致分配过多内存
+	maxSnapshotKeyLen   = 1 << 16
+	maxSnapshotValueLen = 1 << 30
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorruptSnapshot 表示快照文件损坏（校验和不匹配、被截断或格式错误）
+var ErrCorruptSnapshot = errors.New("geecache: corrupt snapshot")
+
+// snapshotWriter 写入数据的同时计算整个文件和当前记录的校验和
+type snapshotWriter struct {
+	w      *bufio.Writer
+	file   hash.Hash32
+	record hash.Hash32
+	buf    [binary.MaxVarintLen64]byte
+}
+
+func (sw *snapshotWriter) write(b []byte) error {
+	sw.file.Write(b)
+	sw.record.Write(b)
+	_, err := sw.w.Write(b)
+	return err
+}
+
+// Write 实现 io.Writer，让 util.ByteView 直接写入，不拷贝值
+func (sw *snapshotWriter) Write(b []byte) (int, error) {
+	if err := sw.write(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (sw *snapshotWriter) writeUvarint(v uint64) error {
+	return sw.write(sw.buf[:binary.PutUvarint(sw.buf[:], v)])
+}
+
+func (sw *snapshotWriter) writeBytes(b []byte) error {
+	if err := sw.writeUvarint(uint64(len(b))); err != nil {
+		return err
+	}
+	return sw.write(b)
+}
+
+func (sw *snapshotWriter) writeUint32(v uint32) error {
+	return sw.write(binary.LittleEndian.AppendUint32(sw.buf[:0], v))
+}
+
+// Snapshot 把缓存中的所有项按 LRU 顺序写入 w
+func (c *Cache) Snapshot(w io.Writer) error {
+	entries := c.entries()
+	// entries 从新到旧遍历，快照中从旧到新保存
+	slices.Reverse(entries)
+
+	sw := &snapshotWriter{
+		w:      bufio.NewWriter(w),
+		file:   crc32.New(crcTable),
+		record: crc32.New(crcTable),
+	}
+	if err := sw.write([]byte(snapshotMagic)); err != nil {
+		return err
+	}
+	if err := sw.write([]byte{snapshotVersion}); err != nil {
+		return err
+	}
+	if err := sw.writeUvarint(uint64(len(entries))); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		sw.record.Reset()
+		if err := sw.writeBytes([]byte(e.key)); err != nil {
+			return err
+		}
+		if err := sw.writeBytes([]byte(e.value.Encoding)); err != nil {
+			return err
+		}
+		if err := sw.writeUvarint(uint64(e.value.Size())); err != nil {
+			return err
+		}
+		if _, err := e.value.WriteTo(sw); err != nil {
+			return err
+		}
+		if err := sw.write(binary.LittleEndian.AppendUint64(sw.buf[:0], uint64(e.expiry))); err != nil {
+			return err
+		}
+		if err := sw.writeUint32(sw.record.Sum32()); err != nil {
+			return err
+		}
+	}
+	if err := sw.writeUint32(sw.file.Sum32()); err != nil {
+		return err
+	}
+	return sw.w.Flush()
+}
+
+type snapshotEntry struct {
+	key   string
+	value util.ByteView
+	// 过期时间的 Unix 纳秒时间戳，0 表示不过期
+	expiry int64
+}
+
+// snapshotReader 读取数据的同时计算整个文件和当前记录的校验和
+type snapshotReader struct {
+	r      *bufio.Reader
+	file   hash.Hash32
+	record hash.Hash32
+}
+
+func (sr *snapshotReader) ReadByte() (byte, error) {
+	b, err := sr.r.ReadByte()
+	if err == nil {
+		sr.file.Write([]byte{b})
+		sr.record.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (sr *snapshotReader) read(n uint64) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(sr.r, b); err != nil {
+		return nil, err
+	}
+	sr.file.Write(b)
+	sr.record.Write(b)
+	return b, nil
+}
+
+func (sr *snapshotReader) readBytes(limit uint64) ([]byte, error) {
+	n, err := binary.ReadUvarint(sr)
+	if err != nil {
+		return nil, err
+	}
+	if n > limit {
+		return nil, fmt.Errorf("%w: length %d exceeds %d", ErrCorruptSnapshot, n, limit)
+	}
+	return sr.read(n)
+}
+
+// readChecksum 读取一个校验和并与 want 比较
+func (sr *snapshotReader) readChecksum(want uint32) error {
+	b, err := sr.read(4)
+	if err != nil {
+		return err
+	}
+	if got := binary.LittleEndian.Uint32(b); got != want {
+		return fmt.Errorf("%w: checksum mismatch", ErrCorruptSnapshot)
+	}
+	return nil
+}
+
+func (sr *snapshotReader) readEntry() (snapshotEntry, error) {
+	sr.record.Reset()
+	key, err := sr.readBytes(maxSnapshotKeyLen)
+	if err != nil {
+		return snapshotEntry{}, err
+	}
+	encoding, err := sr.readBytes(maxSnapshotKeyLen)
+	if err != nil {
+		return snapshotEntry{}, err
+	}
+	value, err := sr.readBytes(maxSnapshotValueLen)
+	if err != nil {
+		return snapshotEntry{}, err
+	}
+	expiry, err := sr.read(8)
+	if err != nil {
+		return snapshotEntry{}, err
+	}
+	if err := sr.readChecksum(sr.record.Sum32()); err != nil {
+		return snapshotEntry{}, err
+	}
+	return snapshotEntry{string(key), util.NewByteView(value, string(encoding)), int64(binary.LittleEndian.Uint64(expiry))}, nil
+}
+
+// Restore 从 r 中读取 Snapshot 写入的快照并放入缓存。整个快照校验通过之后才会修改缓存，
+// 快照损坏时返回 ErrCorruptSnapshot，缓存保持不变。
+func (c *Cache) Restore(r io.Reader) error {
+	entries, err := readSnapshot(r)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		switch {
+		case e.expiry == 0:
+			// 保存快照时没有过期时间，按照当前的 ttl 计算
+			c.Put(e.key, e.value)
+		case c.expired(cacheValue{expiry: e.expiry}):
+			// 已经过期，丢弃
+		default:
+			c.put(e.key, e.value, e.expiry)
+		}
+	}
+	return nil
+}
+
+func readSnapshot(r io.Reader) ([]snapshotEntry, error) {
+	sr := &snapshotReader{
+		r:      bufio.NewReader(r),
+		file:   crc32.New(crcTable),
+		record: crc32.New(crcTable),
+	}
+	entries, err := sr.readAll()
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("%w: truncated", ErrCorruptSnapshot)
+	}
+	return entries, err
+}
+
+func (sr *snapshotReader) readAll() ([]snapshotEntry, error) {
+	header, err := sr.read(uint64(len(snapshotMagic) + 1))
+	if err != nil {
+		return nil, err
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, fmt.Errorf("%w: bad magic", ErrCorruptSnapshot)
+	}
+	if version := header[len(snapshotMagic)]; version != snapshotVersion {
+		return nil, fmt.Errorf("geecache: unsupported snapshot version %d", version)
+	}
+	count, err := binary.ReadUvarint(sr)
+	if err != nil {
+		return nil, err
+	}
+	var entries []snapshotEntry
+	for range count {
+		e, err := sr.readEntry()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	if err := sr.readChecksum(sr.file.Sum32()); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Snapshot 把 group 的本地缓存写入 w，见 Cache.Snapshot
+func (g *Group) Snapshot(w io.Writer) error {
+	return g.localCache.Snapshot(w)
+}
+
+// Restore 把 Snapshot 写入的快照恢复到 group 的本地缓存，见 Cache.Restore
+func (g *Group) Restore(r io.Reader) error {
+	return g.localCache.Restore(r)
+}
+
+// Snapshotter 定期把所有 group 的本地缓存保存到目录 dir 中（每个 group 一个文件），
+// 节点重启后可以从快照恢复，避免所有请求都打到数据库上。
+type Snapshotter struct {
+	dir      string
+	interval time.Duration
+	stop     chan struct{}
+	done     sync.WaitGroup
+	// SaveAll 和 LoadAll 处理的 group
+	registry *Registry
+}
+
+// NewSnapshotter 创建把全局注册表中的 group 每隔 interval 保存到 dir 中的 Snapshotter
+func NewSnapshotter(dir string, interval time.Duration) *Snapshotter {
+	return defaultRegistry.NewSnapshotter(dir, interval)
+}
+
+func (s *Snapshotter) path(group string) string {
+	return filepath.Join(s.dir, url.PathEscape(group)+".snap")
+}
+
+// Save 保存 g 的快照。先写入临时文件再重命名，保存过程中崩溃也不会破坏上一次的快照。
+func (s *Snapshotter) Save(g *Group) error {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(s.dir, ".snap-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := g.Snapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path(g.Name()))
+}
+
+// Load 从快照恢复 g，快照文件不存在时什么也不做
+func (s *Snapshotter) Load(g *Group) error {
+	f, err := os.Open(s.path(g.Name()))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return g.Restore(f)
+}
+
+// SaveAll 保存所有 group 的快照
+func (s *Snapshotter) SaveAll() error {
+	var errs []error
+	for _, g := range s.registry.List() {
+		if err := s.Save(g); err != nil {
+			errs = append(errs, fmt.Errorf("snapshot group %s: %w", g.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// LoadAll 从快照恢复所有 group
+func (s *Snapshotter) LoadAll() error {
+	var errs []error
+	for _, g := range s.registry.List() {
+		if err := s.Load(g); err != nil {
+			errs = append(errs, fmt.Errorf("restore group %s: %w", g.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Start 开始定期保存快照
+func (s *Snapshotter) Start() {
+	s.stop = make(chan struct{})
+	s.done.Add(1)
+	go func() {
+		defer s.done.Done()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.SaveAll(); err != nil {
+					log.Printf("[Snapshotter] %v", err)
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止定期保存快照，并等待正在进行的保存完成
+func (s *Snapshotter) Stop() {
+	close(s.stop)
+	s.done.Wait()
+}
@@ -0,0 +1,163 @@
+package geecache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"geecache/util"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func cacheKeys(c *Cache) []string {
+	var keys []string
+	c.Range(func(key string, _ util.ByteView) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	src := &Cache{cacheBytes: 1 << 20}
+	for i := range 100 {
+		key := strconv.Itoa(i)
+		src.Put(key, util.NewByteView([]byte("value-" + key), ""))
+	}
+	src.Put("gz", util.NewByteView([]byte{0x1f, 0x8b}, "gzip"))
+	src.Get("7") // 改变 LRU 顺序
+
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dst := &Cache{cacheBytes: 1 << 20}
+	if err := dst.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := cacheKeys(src), cacheKeys(dst); !slices.Equal(want, got) {
+		t.Fatalf("LRU order not preserved:\nwant %v\ngot  %v", want, got)
+	}
+	if v, ok := dst.Get("42"); !ok || v.String() != "value-42" {
+		t.Fatalf("restored value mismatch: %v", v)
+	}
+	if v, ok := dst.Get("gz"); !ok || v.Encoding != "gzip" {
+		t.Fatalf("encoding not preserved: %q", v.Encoding)
+	}
+
+	// 容量更小的缓存只保留最新的项
+	small := &Cache{cacheBytes: 100}
+	if err := small.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := small.Get("7"); !ok {
+		t.Fatalf("most recently used key should survive")
+	}
+	if _, ok := small.Get("0"); ok {
+		t.Fatalf("least recently used key should be evicted")
+	}
+}
+
+func TestSnapshotCorruption(t *testing.T) {
+	src := &Cache{cacheBytes: 1 << 20}
+	for i := range 10 {
+		src.Put(strconv.Itoa(i), util.NewByteView([]byte("value-"+strconv.Itoa(i)), ""))
+	}
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	corrupt := map[string][]byte{
+		"truncated":   data[:len(data)-10],
+		"no trailer":  data[:len(data)-4],
+		"empty":       nil,
+		"bad magic":   append([]byte("XXXX"), data[4:]...),
+		"flipped bit": slices.Clone(data),
+	}
+	idx := bytes.Index(data, []byte("value-5"))
+	corrupt["flipped bit"][idx] ^= 0x01
+
+	for name, b := range corrupt {
+		dst := &Cache{cacheBytes: 1 << 20}
+		err := dst.Restore(bytes.NewReader(b))
+		if !errors.Is(err, ErrCorruptSnapshot) {
+			t.Errorf("%s: expected ErrCorruptSnapshot, got %v", name, err)
+		}
+		if len(cacheKeys(dst)) != 0 {
+			t.Errorf("%s: cache modified by a corrupt snapshot", name)
+		}
+	}
+
+	version := slices.Clone(data)
+	version[len(snapshotMagic)] = 99
+	if err := (&Cache{}).Restore(bytes.NewReader(version)); err == nil {
+		t.Errorf("expected an error for an unsupported version")
+	}
+}
+
+func TestSnapshotter(t *testing.T) {
+	var loads int
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte("value-" + key), nil
+	})
+	g := newTestGroup(t, "snapshot-test", 1<<20, getter)
+	for i := range 10 {
+		g.Get(strconv.Itoa(i))
+	}
+
+	dir := t.TempDir()
+	s := NewSnapshotter(dir, 10*time.Millisecond)
+	s.Start()
+	time.Sleep(50 * time.Millisecond)
+	s.Stop()
+
+	// 模拟节点重启：新的 group 从快照恢复，不需要访问数据源
+	if err := DeleteGroup(context.Background(), "snapshot-test"); err != nil {
+		t.Fatal(err)
+	}
+	g = newTestGroup(t, "snapshot-test", 1<<20, getter)
+	if err := s.LoadAll(); err != nil {
+		t.Fatal(err)
+	}
+	loads = 0
+	for i := range 10 {
+		if v, err := g.Get(strconv.Itoa(i)); err != nil || v.String() != "value-"+strconv.Itoa(i) {
+			t.Fatalf("get %d: %v, %v", i, v, err)
+		}
+	}
+	if loads != 0 {
+		t.Fatalf("expected all keys restored from the snapshot, %d loaded from source", loads)
+	}
+}
+
+func TestSnapshotExpiry(t *testing.T) {
+	now := time.Unix(1000, 0)
+	clock := func() time.Time { return now }
+	src := &Cache{cacheBytes: 1 << 20, ttl: time.Minute, now: clock}
+	src.Put("old", util.NewByteView([]byte("1"), ""))
+	now = now.Add(30 * time.Second)
+	src.Put("new", util.NewByteView([]byte("2"), ""))
+
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// 恢复时保留原来的过期时间，已经过期的项被丢弃
+	now = now.Add(40 * time.Second)
+	dst := &Cache{cacheBytes: 1 << 20, now: clock}
+	if err := dst.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if keys := cacheKeys(dst); !slices.Equal(keys, []string{"new"}) {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+	now = now.Add(20 * time.Second)
+	if _, ok := dst.Get("new"); ok {
+		t.Fatalf("restored key should keep its expiry")
+	}
+}
@@ -0,0 +1,78 @@
+package source
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// FS 从文件系统读取 key 的值，key 对应的文件内容就是值
+type FS struct {
+	fsys fs.FS
+	opts options
+}
+
+// NewFS 创建从 fsys 读取值的 Getter，key 按照 WithPathPattern 映射成 fsys 中的路径。
+// key 映射出的路径不合法（例如包含 ".."）或者文件不存在时表示 key 不存在。
+func NewFS(fsys fs.FS, opts ...Option) *FS {
+	return &FS{fsys: fsys, opts: newOptions(opts)}
+}
+
+// NewDir 创建从目录 dir 读取值的 Getter，见 NewFS
+func NewDir(dir string, opts ...Option) *FS {
+	return NewFS(os.DirFS(dir), opts...)
+}
+
+func (f *FS) Get(key string) ([]byte, error) {
+	name := strings.ReplaceAll(f.opts.pattern, "{key}", key)
+	// 不允许通过 key 访问目录之外的文件
+	if !fs.ValidPath(name) {
+		return nil, notFound(key)
+	}
+	ctx, cancel := f.opts.context()
+	defer cancel()
+	type result struct {
+		value []byte
+		err   error
+	}
+	// 文件系统的读取不支持取消，超时之后不再等待，读取在后台完成
+	done := make(chan result, 1)
+	go func() {
+		value, err := f.read(name)
+		done <- result{value, err}
+	}()
+	select {
+	case r := <-done:
+		if errors.Is(r.err, fs.ErrNotExist) {
+			return nil, notFound(key)
+		}
+		if r.err != nil {
+			return nil, fmt.Errorf("source: reading %s: %w", key, r.err)
+		}
+		return r.value, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("source: reading %s: %w", key, ctx.Err())
+	}
+}
+
+func (f *FS) read(name string) ([]byte, error) {
+	file, err := f.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fs.ErrNotExist
+	}
+	if f.opts.maxSize > 0 && info.Size() > f.opts.maxSize {
+		return nil, fmt.Errorf("file is %d bytes, exceeds the limit of %d bytes", info.Size(), f.opts.maxSize)
+	}
+	return io.ReadAll(file)
+}
@@ -0,0 +1,56 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"geecache"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestDir(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "Tom.json"), []byte(`{"score": 630}`), 0o644)
+	os.WriteFile(filepath.Join(dir, "big.json"), make([]byte, 100), 0o644)
+	os.Mkdir(filepath.Join(dir, "sub.json"), 0o755)
+	os.WriteFile(filepath.Join(filepath.Dir(dir), "secret.json"), []byte("secret"), 0o644)
+
+	d := NewDir(dir, WithPathPattern("{key}.json"), WithMaxSize(50))
+	if v, err := d.Get("Tom"); err != nil || string(v) != `{"score": 630}` {
+		t.Fatalf("Get(Tom) = %q, %v", v, err)
+	}
+	for _, key := range []string{"Jack", "sub", "../secret", "/etc/passwd"} {
+		if _, err := d.Get(key); !errors.Is(err, geecache.ErrNotFound) {
+			t.Fatalf("Get(%q) should be not found, got %v", key, err)
+		}
+	}
+	if _, err := d.Get("big"); err == nil || errors.Is(err, geecache.ErrNotFound) {
+		t.Fatalf("Get(big) should exceed the size limit, got %v", err)
+	}
+}
+
+// slowFS 在打开文件之前等待
+type slowFS struct {
+	fs.FS
+	delay time.Duration
+}
+
+func (s slowFS) Open(name string) (fs.File, error) {
+	time.Sleep(s.delay)
+	return s.FS.Open(name)
+}
+
+func TestFSTimeout(t *testing.T) {
+	fsys := fstest.MapFS{"Tom": {Data: []byte("630")}}
+	if v, err := NewFS(fsys).Get("Tom"); err != nil || string(v) != "630" {
+		t.Fatalf("Get(Tom) = %q, %v", v, err)
+	}
+	slow := NewFS(slowFS{fsys, 200 * time.Millisecond}, WithTimeout(20*time.Millisecond))
+	if _, err := slow.Get("Tom"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package source
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// HTTP 从 HTTP 源站读取 key 的值
+type HTTP struct {
+	template string
+	// {key} 在查询参数中时用 QueryEscape 转义，否则用 PathEscape
+	inQuery bool
+	opts    options
+}
+
+// NewHTTP 创建从 urlTemplate 读取值的 Getter，urlTemplate 中的 {key} 会被替换成转义后的 key，
+// 例如 "http://origin/scores/{key}" 或 "http://origin/scores?name={key}"。
+// 源站返回 2xx 时响应体就是值，返回 WithNotFoundStatus 中的状态码时表示 key 不存在，其他状态码都是错误。
+func NewHTTP(urlTemplate string, opts ...Option) (*HTTP, error) {
+	i := strings.Index(urlTemplate, "{key}")
+	if i < 0 {
+		return nil, fmt.Errorf("source: url template %q has no {key}", urlTemplate)
+	}
+	if _, err := url.Parse(strings.ReplaceAll(urlTemplate, "{key}", "key")); err != nil {
+		return nil, fmt.Errorf("source: invalid url template: %w", err)
+	}
+	q := strings.IndexByte(urlTemplate, '?')
+	return &HTTP{template: urlTemplate, inQuery: q >= 0 && q < i, opts: newOptions(opts)}, nil
+}
+
+func (h *HTTP) url(key string) string {
+	if h.inQuery {
+		return strings.ReplaceAll(h.template, "{key}", url.QueryEscape(key))
+	}
+	return strings.ReplaceAll(h.template, "{key}", url.PathEscape(key))
+}
+
+func (h *HTTP) Get(key string) ([]byte, error) {
+	ctx, cancel := h.opts.context()
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range h.opts.header {
+		req.Header[k] = v
+	}
+	resp, err := h.opts.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("source: fetching %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case slices.Contains(h.opts.notFound, resp.StatusCode):
+		return nil, notFound(key)
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return nil, fmt.Errorf("source: origin returned %s for %s", resp.Status, key)
+	}
+	if h.opts.maxSize > 0 && resp.ContentLength > h.opts.maxSize {
+		return nil, fmt.Errorf("source: value of %s is %d bytes, exceeds the limit of %d bytes", key, resp.ContentLength, h.opts.maxSize)
+	}
+	body := io.Reader(resp.Body)
+	if h.opts.maxSize > 0 {
+		// 多读一个字节，用来发现没有 Content-Length 的超大响应
+		body = io.LimitReader(resp.Body, h.opts.maxSize+1)
+	}
+	value, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("source: reading %s: %w", key, err)
+	}
+	if h.opts.maxSize > 0 && int64(len(value)) > h.opts.maxSize {
+		return nil, fmt.Errorf("source: value of %s exceeds the limit of %d bytes", key, h.opts.maxSize)
+	}
+	return value, nil
+}
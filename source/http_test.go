@@ -0,0 +1,70 @@
+package source
+
+import (
+	"errors"
+	"geecache"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTP(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		key := r.URL.Query().Get("name")
+		if key == "" {
+			key = strings.TrimPrefix(r.URL.Path, "/scores/")
+		}
+		switch key {
+		case "Tom", "a b/c":
+			w.Write([]byte("630:" + key))
+		case "gone":
+			w.WriteHeader(http.StatusGone)
+		case "slow":
+			time.Sleep(200 * time.Millisecond)
+		case "big":
+			w.Write([]byte(strings.Repeat("x", 100)))
+		case "teapot":
+			w.WriteHeader(http.StatusTeapot)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	if _, err := NewHTTP(ts.URL + "/scores"); err == nil {
+		t.Fatal("template without {key} should be rejected")
+	}
+	opts := []Option{WithHeader("Authorization", "Bearer secret"), WithTimeout(50 * time.Millisecond), WithMaxSize(10)}
+	for _, tpl := range []string{ts.URL + "/scores/{key}", ts.URL + "/lookup?name={key}"} {
+		h, err := NewHTTP(tpl, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, want := range map[string]string{"Tom": "630:Tom", "a b/c": "630:a b/c"} {
+			if v, err := h.Get(key); err != nil || string(v) != want {
+				t.Fatalf("%s: Get(%q) = %q, %v", tpl, key, v, err)
+			}
+		}
+		for _, key := range []string{"Jack", "gone"} {
+			if _, err := h.Get(key); !errors.Is(err, geecache.ErrNotFound) {
+				t.Fatalf("%s: Get(%q) should be not found, got %v", tpl, key, err)
+			}
+		}
+		for _, key := range []string{"slow", "big", "teapot"} {
+			if _, err := h.Get(key); err == nil || errors.Is(err, geecache.ErrNotFound) {
+				t.Fatalf("%s: Get(%q) should fail, got %v", tpl, key, err)
+			}
+		}
+	}
+
+	h, _ := NewHTTP(ts.URL+"/scores/{key}", WithNotFoundStatus(http.StatusUnauthorized))
+	if _, err := h.Get("Tom"); !errors.Is(err, geecache.ErrNotFound) {
+		t.Fatalf("custom not found status should map to ErrNotFound, got %v", err)
+	}
+}
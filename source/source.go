@@ -0,0 +1,106 @@
+// Package source 提供常用的数据源 Getter：HTTP 源站、database/sql 和文件系统。
+// key 不存在时返回的错误都包装了 geecache.ErrNotFound，可以用 errors.Is 判断。
+package source
+
+import (
+	"context"
+	"fmt"
+	"geecache"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultTimeout = 5 * time.Second
+)
+
+type options struct {
+	timeout  time.Duration
+	maxSize  int64
+	header   http.Header
+	client   *http.Client
+	notFound []int
+	column   string
+	pattern  string
+}
+
+// Option 用于配置 Getter，不适用于某个 Getter 的 Option 会被忽略
+type Option func(*options)
+
+// WithTimeout 设置加载一个 key 的超时时间，默认 5s，<= 0 表示不限制
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithMaxSize 限制值的最大字节数，超过时返回错误，<= 0 表示不限制。适用于 HTTP 和 FS。
+func WithMaxSize(n int64) Option {
+	return func(o *options) {
+		o.maxSize = n
+	}
+}
+
+// WithHeader 给发往源站的请求加上 header。适用于 HTTP。
+func WithHeader(key, value string) Option {
+	return func(o *options) {
+		if o.header == nil {
+			o.header = make(http.Header)
+		}
+		o.header.Add(key, value)
+	}
+}
+
+// WithClient 使用 c 访问源站，默认是 http.DefaultClient。适用于 HTTP。
+func WithClient(c *http.Client) Option {
+	return func(o *options) {
+		o.client = c
+	}
+}
+
+// WithNotFoundStatus 设置表示 key 不存在的状态码，默认是 404 和 410。适用于 HTTP。
+func WithNotFoundStatus(codes ...int) Option {
+	return func(o *options) {
+		o.notFound = codes
+	}
+}
+
+// WithColumn 从查询结果中取名为 name 的列作为值，默认取第一列。适用于 SQL。
+func WithColumn(name string) Option {
+	return func(o *options) {
+		o.column = name
+	}
+}
+
+// WithPathPattern 设置 key 对应的文件路径，{key} 会被替换成 key，例如 "{key}.json"，默认是 "{key}"。适用于 FS。
+func WithPathPattern(pattern string) Option {
+	return func(o *options) {
+		o.pattern = pattern
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		timeout:  defaultTimeout,
+		client:   http.DefaultClient,
+		notFound: []int{http.StatusNotFound, http.StatusGone},
+		pattern:  "{key}",
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// context 返回带有超时时间的 context
+func (o *options) context() (context.Context, context.CancelFunc) {
+	if o.timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), o.timeout)
+}
+
+// notFound 返回 key 不存在的错误
+func notFound(key string) error {
+	return fmt.Errorf("%s: %w", key, geecache.ErrNotFound)
+}
@@ -0,0 +1,94 @@
+package source
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// SQL 通过 database/sql 查询 key 的值
+type SQL struct {
+	db    *sql.DB
+	query string
+	opts  options
+}
+
+// NewSQL 创建用 query 查询值的 Getter。query 只有一个参数，就是 key，占位符的写法取决于驱动，例如
+// "SELECT score FROM scores WHERE name = ?" 或 "SELECT score FROM scores WHERE name = $1"。
+// 查询结果的第一行中 WithColumn 指定的列（默认第一列）就是值，没有结果或者值是 NULL 时表示 key 不存在。
+func NewSQL(db *sql.DB, query string, opts ...Option) *SQL {
+	return &SQL{db: db, query: query, opts: newOptions(opts)}
+}
+
+// Close 关闭 db 和它的连接池，之后的 Get 都会失败
+func (s *SQL) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQL) Get(key string) ([]byte, error) {
+	ctx, cancel := s.opts.context()
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, s.query, key)
+	if err != nil {
+		return nil, fmt.Errorf("source: querying %s: %w", key, err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("source: querying %s: %w", key, err)
+		}
+		return nil, notFound(key)
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	col := 0
+	if s.opts.column != "" {
+		if col = slices.Index(columns, s.opts.column); col < 0 {
+			return nil, fmt.Errorf("source: query returned no column %q", s.opts.column)
+		}
+	}
+	dest := make([]any, len(columns))
+	var value any
+	for i := range dest {
+		if i == col {
+			dest[i] = &value
+		} else {
+			dest[i] = new(any)
+		}
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, fmt.Errorf("source: scanning %s: %w", key, err)
+	}
+	if value == nil {
+		return nil, notFound(key)
+	}
+	b, err := columnBytes(value)
+	if err != nil {
+		return nil, fmt.Errorf("source: column of %s: %w", key, err)
+	}
+	return b, nil
+}
+
+// columnBytes 把 Scan 得到的值转换成字节，数字和时间转换成文本形式
+func columnBytes(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return slices.Clone(v), nil
+	case string:
+		return []byte(v), nil
+	case int64:
+		return strconv.AppendInt(nil, v, 10), nil
+	case float64:
+		return strconv.AppendFloat(nil, v, 'g', -1, 64), nil
+	case bool:
+		return strconv.AppendBool(nil, v), nil
+	case time.Time:
+		return v.AppendFormat(nil, time.RFC3339Nano), nil
+	default:
+		return nil, fmt.Errorf("unsupported type %T", v)
+	}
+}
@@ -0,0 +1,144 @@
+package source
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"geecache"
+	"io"
+	"testing"
+	"time"
+)
+
+// stubDriver 是一个内存中的 SQL 驱动，不解析 SQL：每个 query 对应一张固定的表，
+// 返回第一列等于参数的行
+type stubDriver struct {
+	tables map[string]stubTable
+}
+
+type stubTable struct {
+	columns []string
+	rows    [][]driver.Value
+	// 查询之前等待的时间，用于测试超时
+	delay time.Duration
+	err   error
+}
+
+func (d *stubDriver) Open(name string) (driver.Conn, error) {
+	return &stubConn{d}, nil
+}
+
+type stubConn struct {
+	d *stubDriver
+}
+
+func (c *stubConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+func (c *stubConn) Close() error              { return nil }
+func (c *stubConn) Begin() (driver.Tx, error) { return nil, errors.New("transactions not supported") }
+
+func (c *stubConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	table, ok := c.d.tables[query]
+	if !ok {
+		return nil, errors.New("no such table")
+	}
+	select {
+	case <-time.After(table.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if table.err != nil {
+		return nil, table.err
+	}
+	rows := &stubRows{columns: table.columns}
+	for _, row := range table.rows {
+		if row[0] == args[0].Value {
+			rows.rows = append(rows.rows, row)
+		}
+	}
+	return rows, nil
+}
+
+type stubRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *stubRows) Columns() []string { return r.columns }
+func (r *stubRows) Close() error      { return nil }
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestSQL(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	sql.Register("stub", &stubDriver{tables: map[string]stubTable{
+		"scores": {
+			columns: []string{"name", "score", "updated"},
+			rows: [][]driver.Value{
+				{"Tom", int64(630), now},
+				{"Jack", []byte("589"), nil},
+				{"Sam", nil, now},
+				{"Ann", 98.5, now},
+				{"Bob", true, now},
+			},
+		},
+		"slow":   {columns: []string{"name"}, delay: time.Second},
+		"broken": {columns: []string{"name"}, err: errors.New("connection reset")},
+	}})
+	db, err := sql.Open("stub", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	scores := NewSQL(db, "scores", WithColumn("score"))
+	for key, want := range map[string]string{"Tom": "630", "Jack": "589", "Ann": "98.5", "Bob": "true"} {
+		if v, err := scores.Get(key); err != nil || string(v) != want {
+			t.Fatalf("Get(%q) = %q, %v, want %q", key, v, err, want)
+		}
+	}
+	// 没有这一行，或者值是 NULL
+	for _, key := range []string{"Alice", "Sam"} {
+		if _, err := scores.Get(key); !errors.Is(err, geecache.ErrNotFound) {
+			t.Fatalf("Get(%q) should be not found, got %v", key, err)
+		}
+	}
+	if v, err := NewSQL(db, "scores", WithColumn("updated")).Get("Tom"); err != nil || string(v) != "2024-01-02T03:04:05Z" {
+		t.Fatalf("time column = %q, %v", v, err)
+	}
+	if v, err := NewSQL(db, "scores").Get("Tom"); err != nil || string(v) != "Tom" {
+		t.Fatalf("first column = %q, %v", v, err)
+	}
+	if _, err := NewSQL(db, "scores", WithColumn("missing")).Get("Tom"); err == nil {
+		t.Fatal("expected error for a missing column")
+	}
+
+	if _, err := NewSQL(db, "slow", WithTimeout(20*time.Millisecond)).Get("Tom"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+	if _, err := NewSQL(db, "broken").Get("Tom"); err == nil || errors.Is(err, geecache.ErrNotFound) {
+		t.Fatalf("expected query error, got %v", err)
+	}
+
+	// Close 关闭连接池
+	other, err := sql.Open("stub", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := NewSQL(other, "scores")
+	if err := closed.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := closed.Get("Tom"); err == nil {
+		t.Fatal("Get after Close should fail")
+	}
+}
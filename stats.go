@@ -0,0 +1,68 @@
+package geecache
+
+import "sync/atomic"
+
+// stats 是 Group 的统计计数器
+type stats struct {
+	gets          atomic.Int64
+	cacheHits     atomic.Int64
+	loads         atomic.Int64
+	loadsDeduped  atomic.Int64
+	peerLoads     atomic.Int64
+	peerErrors    atomic.Int64
+	localLoads    atomic.Int64
+	localLoadErrs atomic.Int64
+	sets          atomic.Int64
+	deletes       atomic.Int64
+	unauthorized  atomic.Int64
+}
+
+// Stats are per-group statistics.
+type Stats struct {
+	// Get 被调用的次数
+	Gets int64 `json:"gets"`
+	// 命中本地缓存（包括第二级存储）的次数
+	CacheHits int64 `json:"cache_hits"`
+	// 本地缓存未命中，需要从远程节点或数据源加载的次数
+	Loads int64 `json:"loads"`
+	// 经过 singleflight 合并之后实际执行的加载次数
+	LoadsDeduped int64 `json:"loads_deduped"`
+	// 从远程节点加载成功和失败的次数
+	PeerLoads  int64 `json:"peer_loads"`
+	PeerErrors int64 `json:"peer_errors"`
+	// 从数据源加载成功和失败的次数
+	LocalLoads    int64 `json:"local_loads"`
+	LocalLoadErrs int64 `json:"local_load_errs"`
+	// Set 和 Delete 被调用的次数
+	Sets    int64 `json:"sets"`
+	Deletes int64 `json:"deletes"`
+	// 内存缓存中的项数和占用的字节数
+	Items int64 `json:"items"`
+	Bytes int64 `json:"bytes"`
+	// 访问 group 时因为没有通过认证或者没有权限被拒绝的请求数
+	Unauthorized int64 `json:"unauthorized"`
+}
+
+// Stats returns a snapshot of the group's statistics.
+func (g *Group) Stats() Stats {
+	return Stats{
+		Gets:          g.stats.gets.Load(),
+		CacheHits:     g.stats.cacheHits.Load(),
+		Loads:         g.stats.loads.Load(),
+		LoadsDeduped:  g.stats.loadsDeduped.Load(),
+		PeerLoads:     g.stats.peerLoads.Load(),
+		PeerErrors:    g.stats.peerErrors.Load(),
+		LocalLoads:    g.stats.localLoads.Load(),
+		LocalLoadErrs: g.stats.localLoadErrs.Load(),
+		Sets:          g.stats.sets.Load(),
+		Deletes:       g.stats.deletes.Load(),
+		Items:         int64(g.localCache.Len()),
+		Bytes:         g.localCache.Bytes(),
+		Unauthorized:  g.stats.unauthorized.Load(),
+	}
+}
+
+// RecordUnauthorized 记录一次因为没有通过认证或者没有权限而被拒绝的请求，由 HTTP 接口的认证层调用
+func (g *Group) RecordUnauthorized() {
+	g.stats.unauthorized.Add(1)
+}
@@ -0,0 +1,118 @@
+package geecache
+
+import (
+	"encoding/binary"
+	"geecache/lru"
+	"geecache/slab"
+	"geecache/util"
+)
+
+// store 是 Cache 的内存存储，默认是 lruStore，WithSlabStore 换成 slabStore。
+// 调用者必须持有 Cache.mtx。
+type store interface {
+	get(key string) (cacheValue, bool)
+	put(key string, value cacheValue)
+	remove(key string) bool
+	setMaxBytes(n int64)
+	len() int
+	bytes() int64
+	// rangeEntries 从最新到最旧遍历所有缓存项，fn 返回 false 时停止
+	rangeEntries(fn func(key string, value cacheValue) bool)
+}
+
+// newStoreFunc 创建容量为 maxBytes 的 store，缓存项被淘汰时调用 onEvicted
+type newStoreFunc func(maxBytes int64, onEvicted func(key string, value cacheValue)) store
+
+// lruStore 用 list+map 的 lru.Cache 保存缓存项
+type lruStore struct {
+	lru *lru.Cache
+}
+
+func newLRUStore(accounting lru.Accounting) newStoreFunc {
+	return func(maxBytes int64, onEvicted func(key string, value cacheValue)) store {
+		return lruStore{lru.New(maxBytes, func(key string, value lru.Value) {
+			onEvicted(key, value.(cacheValue))
+		}, lru.WithAccounting(accounting))}
+	}
+}
+
+func (s lruStore) get(key string) (cacheValue, bool) {
+	v, ok := s.lru.Get(key)
+	if !ok {
+		return cacheValue{}, false
+	}
+	return v.(cacheValue), true
+}
+
+func (s lruStore) put(key string, value cacheValue) { s.lru.Put(key, value) }
+func (s lruStore) remove(key string) bool           { return s.lru.Remove(key) }
+func (s lruStore) setMaxBytes(n int64)              { s.lru.SetMaxBytes(n) }
+func (s lruStore) len() int                         { return s.lru.Len() }
+func (s lruStore) bytes() int64                     { return s.lru.Bytes() }
+
+func (s lruStore) rangeEntries(fn func(key string, value cacheValue) bool) {
+	s.lru.Range(func(key string, value lru.Value) bool {
+		return fn(key, value.(cacheValue))
+	})
+}
+
+// slabStore 把缓存项编码后保存在 slab.Cache 的 page 中，GC 不需要扫描每个缓存项。
+// 编码格式：8 字节过期时间 | 1 字节 Encoding 长度 | Encoding | 数据
+type slabStore struct {
+	slab      *slab.Cache
+	onEvicted func(key string, value cacheValue)
+}
+
+func newSlabStore(opts ...slab.Option) newStoreFunc {
+	return func(maxBytes int64, onEvicted func(key string, value cacheValue)) store {
+		s := slabStore{onEvicted: onEvicted}
+		s.slab = slab.New(maxBytes, func(key string, b []byte) {
+			// b 只在回调中有效，解码时复制一份
+			onEvicted(key, decodeSlabValue(append([]byte(nil), b...)))
+		}, opts...)
+		return s
+	}
+}
+
+func encodeSlabValue(v cacheValue) []byte {
+	b := make([]byte, 9, 9+len(v.view.Encoding)+v.view.Size())
+	binary.LittleEndian.PutUint64(b, uint64(v.expiry))
+	b[8] = byte(len(v.view.Encoding))
+	b = append(b, v.view.Encoding...)
+	return v.view.AppendTo(b)
+}
+
+// decodeSlabValue 解码 encodeSlabValue 的结果，返回的值引用 b
+func decodeSlabValue(b []byte) cacheValue {
+	n := 9 + int(b[8])
+	return cacheValue{
+		view:   util.NewByteView(b[n:], string(b[9:n])),
+		expiry: int64(binary.LittleEndian.Uint64(b)),
+	}
+}
+
+func (s slabStore) get(key string) (cacheValue, bool) {
+	b, ok := s.slab.Get(key, nil)
+	if !ok {
+		return cacheValue{}, false
+	}
+	return decodeSlabValue(b), true
+}
+
+func (s slabStore) put(key string, value cacheValue) {
+	// 超过一个 page 的缓存项放不进 slab，当作写入后立即被淘汰
+	if !s.slab.Put(key, encodeSlabValue(value)) {
+		s.onEvicted(key, value)
+	}
+}
+
+func (s slabStore) remove(key string) bool { return s.slab.Remove(key) }
+func (s slabStore) setMaxBytes(n int64)    { s.slab.SetMaxBytes(n) }
+func (s slabStore) len() int               { return s.slab.Len() }
+func (s slabStore) bytes() int64           { return s.slab.Bytes() }
+
+func (s slabStore) rangeEntries(fn func(key string, value cacheValue) bool) {
+	s.slab.Range(func(key string, b []byte) bool {
+		return fn(key, decodeSlabValue(append([]byte(nil), b...)))
+	})
+}
@@ -1,22 +1,77 @@
 package util
 
+import (
+	"bytes"
+	"io"
+)
+
 // ByteView holds an immutable view of bytes.
+// 底层的切片不对外暴露，读取时使用 At、Slice、WriteTo、Reader 等不拷贝的方法，
+// 需要可修改的副本时使用 ByteSlice。
 type ByteView struct {
-	B []byte
+	b []byte
+	// Encoding 是数据使用的压缩算法（见 geecache/compress），为空表示没有压缩
+	Encoding string
+}
+
+// NewByteView 返回引用 b 的 ByteView，不拷贝 b。调用者之后不能再修改 b。
+func NewByteView(b []byte, encoding string) ByteView {
+	return ByteView{b: b, Encoding: encoding}
 }
 
 func (b ByteView) Size() int {
-	return len(b.B)
+	return len(b.b)
+}
+
+// At returns the byte at index i.
+func (b ByteView) At(i int) byte {
+	return b.b[i]
+}
+
+// Slice 返回 [from, to) 范围的数据，与 b 共享底层数组，Encoding 不变
+func (b ByteView) Slice(from, to int) ByteView {
+	return ByteView{b: b.b[from:to], Encoding: b.Encoding}
 }
 
 // ByteSlice returns a copy of the data as a byte slice.
 func (b ByteView) ByteSlice() []byte {
-	return CloneBytes(b.B)
+	return CloneBytes(b.b)
+}
+
+// AppendTo 把数据追加到 dst 后返回
+func (b ByteView) AppendTo(dst []byte) []byte {
+	return append(dst, b.b...)
 }
 
 // String returns the data as a string, making a copy if necessary.
 func (v ByteView) String() string {
-	return string(v.B)
+	return string(v.b)
+}
+
+// Equal 判断两个 ByteView 的数据和 Encoding 是否都相同
+func (b ByteView) Equal(b2 ByteView) bool {
+	return b.Encoding == b2.Encoding && bytes.Equal(b.b, b2.b)
+}
+
+// EqualBytes 判断数据是否等于 b2
+func (b ByteView) EqualBytes(b2 []byte) bool {
+	return bytes.Equal(b.b, b2)
+}
+
+// EqualString 判断数据是否等于 s，不会分配内存
+func (b ByteView) EqualString(s string) bool {
+	return string(b.b) == s
+}
+
+// WriteTo implements io.WriterTo. 直接写出底层数据，不拷贝。
+func (b ByteView) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(b.b)
+	return int64(n), err
+}
+
+// Reader 返回读取数据的 io.ReadSeeker，不拷贝数据
+func (b ByteView) Reader() io.ReadSeeker {
+	return bytes.NewReader(b.b)
 }
 
 func CloneBytes(b []byte) []byte {
@@ -0,0 +1,164 @@
+package util
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// mustPanic 断言 f 会 panic
+func mustPanic(t *testing.T, name string, f func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("%s should panic", name)
+		}
+	}()
+	f()
+}
+
+func TestByteViewBounds(t *testing.T) {
+	v := NewByteView([]byte("geecache"), "gzip")
+	if v.Size() != 8 || v.At(0) != 'g' || v.At(7) != 'e' {
+		t.Fatalf("unexpected view %q", v.String())
+	}
+	s := v.Slice(3, 8)
+	if !s.EqualString("cache") || s.Encoding != "gzip" {
+		t.Fatalf("Slice(3, 8) = %q, %q", s.String(), s.Encoding)
+	}
+	if e := v.Slice(8, 8); e.Size() != 0 {
+		t.Fatalf("empty slice has size %d", e.Size())
+	}
+
+	mustPanic(t, "At(-1)", func() { v.At(-1) })
+	mustPanic(t, "At(Size())", func() { v.At(v.Size()) })
+	mustPanic(t, "Slice past the end", func() { v.Slice(0, 9) })
+	mustPanic(t, "Slice with from > to", func() { v.Slice(5, 4) })
+	// 切片之后只能访问切片范围内的数据
+	mustPanic(t, "At past the slice", func() { s.At(s.Size()) })
+
+	var zero ByteView
+	if zero.Size() != 0 || zero.String() != "" || !zero.EqualBytes(nil) || zero.Slice(0, 0).Size() != 0 {
+		t.Fatalf("unexpected zero view %q", zero.String())
+	}
+}
+
+// recorder 记录 Write 收到的切片
+type recorder struct {
+	p []byte
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.p = p
+	return len(p), nil
+}
+
+func TestByteViewZeroCopy(t *testing.T) {
+	b := []byte("geecache")
+	v := NewByteView(b, "")
+
+	// Slice 与原来的数据共享底层数组
+	s := v.Slice(3, 8)
+	b[3] = 'C'
+	if !s.EqualString("Cache") || !v.EqualString("geeCache") {
+		t.Fatalf("Slice should share memory, got %q and %q", s.String(), v.String())
+	}
+
+	// WriteTo 直接写出底层数组
+	var w recorder
+	n, err := s.WriteTo(&w)
+	if err != nil || n != 5 {
+		t.Fatalf("WriteTo: %d, %v", n, err)
+	}
+	if &w.p[0] != &b[3] {
+		t.Fatal("WriteTo should write the underlying array without copying")
+	}
+	if allocs := testing.AllocsPerRun(100, func() { v.WriteTo(&w) }); allocs != 0 {
+		t.Fatalf("WriteTo allocated %v times", allocs)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { v.EqualString("geeCache") }); allocs != 0 {
+		t.Fatalf("EqualString allocated %v times", allocs)
+	}
+}
+
+func TestByteViewReader(t *testing.T) {
+	v := NewByteView([]byte("0123456789"), "")
+	r := v.Reader()
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "0123" {
+		t.Fatalf("read %q, %v", buf, err)
+	}
+	for _, tt := range []struct {
+		offset int64
+		whence int
+		pos    int64
+		next   string
+	}{
+		{6, io.SeekStart, 6, "6789"},
+		{-8, io.SeekCurrent, 2, "2345"},
+		{-3, io.SeekEnd, 7, "789"},
+	} {
+		pos, err := r.Seek(tt.offset, tt.whence)
+		if err != nil || pos != tt.pos {
+			t.Fatalf("Seek(%d, %d) = %d, %v, want %d", tt.offset, tt.whence, pos, err, tt.pos)
+		}
+		data, err := io.ReadAll(io.LimitReader(r, 4))
+		if err != nil || string(data) != tt.next {
+			t.Fatalf("after Seek(%d, %d) read %q, %v, want %q", tt.offset, tt.whence, data, err, tt.next)
+		}
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal("seeking before the start should fail")
+	}
+	// 读到结尾之后返回 io.EOF
+	r.Seek(0, io.SeekEnd)
+	if n, err := r.Read(buf); n != 0 || err != io.EOF {
+		t.Fatalf("read at the end: %d, %v", n, err)
+	}
+
+	// 每个 Reader 有独立的位置，切片的 Reader 只能读到切片范围内的数据
+	if data, _ := io.ReadAll(v.Slice(2, 5).Reader()); string(data) != "234" {
+		t.Fatalf("reader of a slice read %q", data)
+	}
+	if data, _ := io.ReadAll(v.Reader()); string(data) != "0123456789" {
+		t.Fatalf("new reader read %q", data)
+	}
+}
+
+func TestByteSliceCopies(t *testing.T) {
+	b := []byte("geecache")
+	v := NewByteView(b, "")
+
+	c := v.ByteSlice()
+	if !bytes.Equal(c, b) {
+		t.Fatalf("ByteSlice() = %q", c)
+	}
+	// 修改副本不影响 ByteView，修改 ByteView 的数据也不影响副本
+	c[0] = 'G'
+	if !v.EqualString("geecache") {
+		t.Fatalf("modifying the copy changed the view to %q", v.String())
+	}
+	b[1] = 'E'
+	if string(c) != "Geecache" {
+		t.Fatalf("modifying the view changed the copy to %q", c)
+	}
+
+	// 切片的副本只包含切片范围内的数据，追加不会覆盖原来的数据
+	s := v.Slice(0, 3).ByteSlice()
+	s = append(s, "XXXXX"...)
+	if !v.EqualString("gEecache") || string(s) != "gEeXXXXX" {
+		t.Fatalf("append to the copy of a slice: view %q, copy %q", v.String(), s)
+	}
+
+	// AppendTo 同样拷贝数据
+	dst := v.AppendTo([]byte("> "))
+	dst[2] = '!'
+	if string(dst) != "> !Eecache" || !v.EqualString("gEecache") {
+		t.Fatalf("AppendTo: %q, view %q", dst, v.String())
+	}
+
+	if !v.Equal(NewByteView([]byte("gEecache"), "")) || v.Equal(NewByteView([]byte("gEecache"), "gzip")) {
+		t.Fatal("Equal should compare the data and the encoding")
+	}
+}
@@ -0,0 +1,140 @@
+package geecache
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// WriteBehindConfig 配置 write-behind 队列，为零的字段使用默认值
+type WriteBehindConfig struct {
+	// 队列中积累了 BatchSize 个 key 时立即写入数据源，默认 100
+	BatchSize int
+	// 队列中的 key 最多等待 FlushInterval 后写入数据源，默认 1s
+	FlushInterval time.Duration
+	// 写入失败时最多重试 MaxRetries 次，之后丢弃这一批数据，默认 3
+	MaxRetries int
+	// 第一次重试前等待的时间，之后每次加倍，默认 100ms
+	RetryBackoff time.Duration
+}
+
+// writeBehind 把 Group.Set 的值异步、批量地写入数据源。
+// 同一个 key 在写入之前被多次 Set 时只写入最后一次的值。
+type writeBehind struct {
+	setter Setter
+	cfg    WriteBehindConfig
+
+	mtx     sync.Mutex
+	pending map[string][]byte
+	// 同一时刻只有一个批次在写入，旧的批次不会覆盖新的批次
+	flushMtx sync.Mutex
+	kick     chan struct{}
+	// 关闭后后台的写入协程退出
+	done chan struct{}
+}
+
+func newWriteBehind(setter Setter, cfg WriteBehindConfig) *writeBehind {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = 3
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = 100 * time.Millisecond
+	}
+	w := &writeBehind{
+		setter:  setter,
+		cfg:     cfg,
+		pending: make(map[string][]byte),
+		kick:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go w.loop()
+	return w
+}
+
+func (w *writeBehind) enqueue(key string, value []byte) {
+	w.mtx.Lock()
+	w.pending[key] = value
+	full := len(w.pending) >= w.cfg.BatchSize
+	w.mtx.Unlock()
+	if full {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *writeBehind) loop() {
+	ticker := time.NewTicker(w.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.kick:
+		case <-w.done:
+			return
+		}
+		if err := w.flush(); err != nil {
+			log.Printf("[WriteBehind] %v", err)
+		}
+	}
+}
+
+// close 停止后台的写入，再把队列中剩下的 key 写入数据源
+func (w *writeBehind) close() error {
+	close(w.done)
+	return w.flush()
+}
+
+// flush 把队列中的所有 key 写入数据源，失败时按指数退避重试
+func (w *writeBehind) flush() error {
+	w.flushMtx.Lock()
+	defer w.flushMtx.Unlock()
+	w.mtx.Lock()
+	batch := w.pending
+	w.pending = make(map[string][]byte)
+	w.mtx.Unlock()
+
+	backoff := w.cfg.RetryBackoff
+	var err error
+	for attempt := 0; len(batch) > 0; attempt++ {
+		if attempt > 0 {
+			if attempt > w.cfg.MaxRetries {
+				return fmt.Errorf("dropped %d keys after %d retries: %w", len(batch), w.cfg.MaxRetries, err)
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		err = w.write(batch)
+	}
+	return nil
+}
+
+// write 写入 batch，成功写入的 key 从 batch 中删除。逐个写入时返回遇到的第一个错误。
+func (w *writeBehind) write(batch map[string][]byte) error {
+	if bs, ok := w.setter.(BatchSetter); ok {
+		if err := bs.SetBatch(batch); err != nil {
+			return err
+		}
+		clear(batch)
+		return nil
+	}
+	var firstErr error
+	for key, value := range batch {
+		if err := w.setter.Set(key, value); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("set %s: %w", key, err)
+			}
+			continue
+		}
+		delete(batch, key)
+	}
+	return firstErr
+}